@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+)
+
+// adminListTasksHandler lists tasks across all users and workspaces. It
+// accepts the same query parameters as GET /tasks plus owner_id and
+// workspace_id to narrow it to one user or workspace.
+func adminListTasksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts, err := parseListOptions(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if v := q.Get("owner_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "owner_id must be a positive integer")
+			return
+		}
+		opts.OwnerID = id
+	}
+	if v := q.Get("workspace_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 1 {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "workspace_id must be a positive integer")
+			return
+		}
+		opts.WorkspaceID = id
+	}
+
+	tasks, total, err := taskRepo.List(r.Context(), opts)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, taskPage{Items: tasksIn(tasks, displayZone(w, r)), Total: total, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+// adminDeleteUserHandler deletes a user account together with all of its
+// personal tasks and API keys; tasks assigned to it are unassigned. The user
+// leaves their workspaces, whose ownership passes to the longest-standing
+// member, and workspaces left empty are deleted with their tasks. Existing
+// sessions of the user stop working because requireAuth no longer finds the
+// user.
+func adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "user id must be a positive integer")
+		return
+	}
+	if id == currentUser(r.Context()).ID {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "admins cannot delete their own account")
+		return
+	}
+
+	if err := userStore.Delete(r.Context(), id); err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, CodeNotFound, "user not found")
+			return
+		}
+		serverError(w, err)
+		return
+	}
+	if _, err := taskRepo.DeleteByOwner(r.Context(), id); err != nil {
+		serverError(w, err)
+		return
+	}
+	if _, err := taskRepo.Unassign(r.Context(), id); err != nil {
+		serverError(w, err)
+		return
+	}
+	emptied, err := workspaces.RemoveUser(r.Context(), id)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	for _, wsID := range emptied {
+		if _, err := taskRepo.DeleteByWorkspace(r.Context(), wsID); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	if err := apiKeys.DeleteByUser(r.Context(), id); err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := templates.DeleteByOwner(r.Context(), id); err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := tagColorStore.DeleteByOwner(r.Context(), id); err != nil {
+		serverError(w, err)
+		return
+	}
+	if err := webhooks.DeleteByUser(r.Context(), id); err != nil {
+		serverError(w, err)
+		return
+	}
+	webhookDispatch.log.forget(id)
+	auditLog.recordDetail(r, currentUser(r.Context()).ID, AuditAdminAction, strconv.Itoa(id), "delete_user")
+	w.WriteHeader(http.StatusNoContent)
+}
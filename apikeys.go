@@ -0,0 +1,297 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// apiKeyPrefix starts every API key so that leaked keys are easy to spot in
+// logs and by secret scanners.
+const apiKeyPrefix = "tms_"
+
+// maxAPIKeyNameLen bounds the label a user gives a key.
+const maxAPIKeyNameLen = 100
+
+// APIKey lets scripts authenticate with an Authorization: Bearer header
+// instead of a session cookie. Only a hash of the key is stored; the key
+// itself is shown once, when it is created.
+type APIKey struct {
+	ID     string
+	UserID int
+	Name   string
+	// Hint is the start of the key, enough for the user to recognise it.
+	Hint       string
+	Hash       [sha256.Size]byte
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// ErrAPIKeyNotFound is returned by an APIKeyStore when no key matches.
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKeyStore stores API keys. Implementations must be safe for concurrent use.
+type APIKeyStore interface {
+	// Create assigns a new ID and creation time to k, stores it and returns
+	// the stored key.
+	Create(ctx context.Context, k APIKey) (APIKey, error)
+	// GetByHash returns the key whose hash is hash.
+	GetByHash(ctx context.Context, hash [sha256.Size]byte) (APIKey, error)
+	// ListByUser returns userID's keys, oldest first.
+	ListByUser(ctx context.Context, userID int) ([]APIKey, error)
+	// Touch records that the key with the given ID was just used.
+	Touch(ctx context.Context, id string, at time.Time) error
+	// Delete removes userID's key with the given ID.
+	Delete(ctx context.Context, userID int, id string) error
+	// DeleteByUser removes all of userID's keys.
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// MemoryAPIKeyStore is an in-memory APIKeyStore. Data is lost on restart.
+type MemoryAPIKeyStore struct {
+	mu     sync.RWMutex
+	keys   map[string]APIKey
+	byHash map[[sha256.Size]byte]string
+}
+
+// NewMemoryAPIKeyStore returns an empty MemoryAPIKeyStore.
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{
+		keys:   make(map[string]APIKey),
+		byHash: make(map[[sha256.Size]byte]string),
+	}
+}
+
+func (s *MemoryAPIKeyStore) Create(ctx context.Context, k APIKey) (APIKey, error) {
+	id, err := newTaskID()
+	if err != nil {
+		return APIKey{}, err
+	}
+	k.ID = id
+	k.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[k.ID] = k
+	s.byHash[k.Hash] = k.ID
+	return k, nil
+}
+
+func (s *MemoryAPIKeyStore) GetByHash(ctx context.Context, hash [sha256.Size]byte) (APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.byHash[hash]
+	if !ok {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return s.keys[id], nil
+}
+
+func (s *MemoryAPIKeyStore) ListByUser(ctx context.Context, userID int) ([]APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := make([]APIKey, 0)
+	for _, k := range s.keys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+	return keys, nil
+}
+
+func (s *MemoryAPIKeyStore) Touch(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return ErrAPIKeyNotFound
+	}
+	k.LastUsedAt = &at
+	s.keys[id] = k
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) Delete(ctx context.Context, userID int, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok || k.UserID != userID {
+		return ErrAPIKeyNotFound
+	}
+	delete(s.keys, id)
+	delete(s.byHash, k.Hash)
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) DeleteByUser(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, k := range s.keys {
+		if k.UserID == userID {
+			delete(s.keys, id)
+			delete(s.byHash, k.Hash)
+		}
+	}
+	return nil
+}
+
+// newAPIKey generates a random key and returns it with its hash.
+func newAPIKey() (string, [sha256.Size]byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", [sha256.Size]byte{}, err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	return key, sha256.Sum256([]byte(key)), nil
+}
+
+// bearerToken returns the credentials of an "Authorization: Bearer" header,
+// and whether the request carried one at all.
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	if h == "" {
+		return "", false
+	}
+	scheme, token, _ := strings.Cut(h, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", true
+	}
+	return strings.TrimSpace(token), true
+}
+
+// apiKeyUser resolves an API key to the user it belongs to and records the
+// use. It returns ErrAPIKeyNotFound for unknown keys and for keys whose user
+// has been deleted.
+func apiKeyUser(ctx context.Context, key string) (User, APIKey, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return User{}, APIKey{}, ErrAPIKeyNotFound
+	}
+	k, err := apiKeys.GetByHash(ctx, sha256.Sum256([]byte(key)))
+	if err != nil {
+		return User{}, APIKey{}, err
+	}
+	user, err := userStore.Get(ctx, k.UserID)
+	if errors.Is(err, ErrUserNotFound) {
+		return User{}, APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return User{}, APIKey{}, err
+	}
+	if err := apiKeys.Touch(ctx, k.ID, time.Now().UTC()); err != nil && !errors.Is(err, ErrAPIKeyNotFound) {
+		return User{}, APIKey{}, err
+	}
+	return user, k, nil
+}
+
+// apiKeyInfo describes one of the user's API keys. Key is only set in the
+// response to POST /me/api-keys.
+type apiKeyInfo struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Key        string     `json:"key,omitempty"`
+	Hint       string     `json:"hint"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func newAPIKeyInfo(k APIKey) apiKeyInfo {
+	return apiKeyInfo{
+		ID:         k.ID,
+		Name:       k.Name,
+		Hint:       k.Hint,
+		CreatedAt:  k.CreatedAt,
+		LastUsedAt: k.LastUsedAt,
+	}
+}
+
+// apiKeyInput is the body of POST /me/api-keys.
+type apiKeyInput struct {
+	Name string `json:"name"`
+}
+
+// createAPIKeyHandler generates an API key for the logged-in user and returns
+// it once. Keys can only be created from a session, so that a leaked key
+// can't be used to mint replacements that outlive its revocation.
+func createAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.Context().Value(apiKeyKey).(APIKey); ok {
+		writeError(w, http.StatusForbidden, CodeForbidden, "API keys can only be created from a logged-in session")
+		return
+	}
+	var in apiKeyInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	switch {
+	case in.Name == "":
+		writeValidationErrors(w, validationErrors{"name": "required"}, -1)
+		return
+	case utf8.RuneCountInString(in.Name) > maxAPIKeyNameLen:
+		writeValidationErrors(w, validationErrors{"name": fmt.Sprintf("must be at most %d characters", maxAPIKeyNameLen)}, -1)
+		return
+	}
+
+	key, hash, err := newAPIKey()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	k, err := apiKeys.Create(r.Context(), APIKey{
+		UserID: currentUser(r.Context()).ID,
+		Name:   in.Name,
+		Hint:   key[:len(apiKeyPrefix)+4],
+		Hash:   hash,
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	info := newAPIKeyInfo(k)
+	info.Key = key
+	w.Header().Set("Cache-Control", "no-store")
+	encode(w, r, http.StatusCreated, info)
+}
+
+// listAPIKeysHandler lists the logged-in user's API keys, oldest first.
+func listAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := apiKeys.ListByUser(r.Context(), currentUser(r.Context()).ID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	infos := make([]apiKeyInfo, len(keys))
+	for i, k := range keys {
+		infos[i] = newAPIKeyInfo(k)
+	}
+	encode(w, r, http.StatusOK, map[string][]apiKeyInfo{"api_keys": infos})
+}
+
+// revokeAPIKeyHandler deletes one of the logged-in user's API keys. Requests
+// using it fail with 401 from then on.
+func revokeAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	err := apiKeys.Delete(r.Context(), currentUser(r.Context()).ID, r.PathValue("id"))
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "api key not found")
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
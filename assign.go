@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// assignInput is the body of POST /tasks/{id}/assign. A null assignee_id
+// unassigns the task.
+type assignInput struct {
+	AssigneeID *int `json:"assignee_id"`
+}
+
+// assignTaskHandler assigns a task to a user, or unassigns it. Only the
+// task's owner or an admin may do so, or for a workspace task any member, and
+// workspace tasks can only be assigned to members. If the assignee changes,
+// the owner and the new and previous assignees get a task.assigned event.
+func assignTaskHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r.Context())
+	id, ok := taskIDParam(w, r)
+	if !ok {
+		return
+	}
+	task, err := taskRepo.Get(r.Context(), id)
+	if err == nil && task.DeletedAt != nil {
+		err = ErrTaskNotFound
+	}
+	if err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	if !inActiveWorkspace(r.Context(), task) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "task is not in the active workspace")
+		return
+	}
+	if task.WorkspaceID == nil && task.OwnerID != user.ID && user.Role != RoleAdmin {
+		writeError(w, http.StatusForbidden, CodeForbidden, "only the task's owner or an admin can assign it")
+		return
+	}
+	if !checkIfMatch(w, r, task) {
+		return
+	}
+
+	var in assignInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.AssigneeID != nil {
+		_, err := userStore.Get(r.Context(), *in.AssigneeID)
+		if errors.Is(err, ErrUserNotFound) {
+			writeValidationErrors(w, validationErrors{"assignee_id": "user not found"}, -1)
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if task.WorkspaceID != nil {
+			_, err := workspaces.Member(r.Context(), *task.WorkspaceID, *in.AssigneeID)
+			if errors.Is(err, ErrNotMember) {
+				writeValidationErrors(w, validationErrors{"assignee_id": "user is not a member of the task's workspace"}, -1)
+				return
+			}
+			if err != nil {
+				workspaceStoreError(w, err)
+				return
+			}
+		}
+	}
+
+	task.AssigneeID = in.AssigneeID
+	if err := taskRepo.Update(r.Context(), task); err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	writeTask(w, r, http.StatusOK, task.in(displayZone(w, r)))
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxAttachments bounds the attachments of a single task.
+const maxAttachments = 20
+
+// maxFilenameLength bounds the stored name of an attachment, in bytes.
+const maxFilenameLength = 255
+
+// multipartOverhead is how far an upload's body may exceed the file size
+// limit to leave room for the multipart boundaries and part headers.
+const multipartOverhead = 16 << 10
+
+// AttachmentConfig controls file uploads.
+type AttachmentConfig struct {
+	// Dir is the directory the filesystem blob store keeps files in.
+	Dir string
+	// MaxBytes caps the size of a single file.
+	MaxBytes int64
+	// AllowedTypes are the media types accepted, either exact ("image/png")
+	// or a whole type ("image/*").
+	AllowedTypes []string
+}
+
+// allows reports whether files of the given media type may be uploaded.
+func (c AttachmentConfig) allows(mediaType string) bool {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, t := range c.AllowedTypes {
+		if t == mediaType || t == major+"/*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Attachment describes a file attached to a task. The contents are kept in
+// the BlobStore under attachmentBlobKey.
+type Attachment struct {
+	ID          string `json:"id"`
+	Filename    string `json:"filename"`
+	Size        int64  `json:"size"`
+	ContentType string `json:"content_type"`
+	// Checksum is the hex-encoded SHA-256 of the contents.
+	Checksum   string    `json:"checksum"`
+	UploadedBy int       `json:"uploaded_by"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// attachmentBlobKey returns the BlobStore key of an attachment of a task.
+func attachmentBlobKey(taskID, attachmentID string) string {
+	return taskID + "/" + attachmentID
+}
+
+// attachmentIndex returns the position of the attachment with the given ID,
+// or -1.
+func (t Task) attachmentIndex(id string) int {
+	for i, a := range t.Attachments {
+		if a.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// isAttachmentUpload reports whether r is a POST /tasks/{id}/attachments,
+// whose body limit is set by the handler instead of MAX_BODY_BYTES.
+func isAttachmentUpload(r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		return false
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/tasks/")
+	if !ok {
+		return false
+	}
+	id, ok := strings.CutSuffix(rest, "/attachments")
+	return ok && id != "" && !strings.Contains(id, "/")
+}
+
+// isAttachmentDownload reports whether r is a GET or HEAD
+// /tasks/{id}/attachments/{attID}, whose response streams the file.
+func isAttachmentDownload(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	rest, ok := strings.CutPrefix(r.URL.Path, "/tasks/")
+	if !ok {
+		return false
+	}
+	parts := strings.Split(rest, "/")
+	return len(parts) == 3 && parts[0] != "" && parts[1] == "attachments" && parts[2] != ""
+}
+
+// errFileTooLarge is returned by the reader of an upload once the file
+// exceeds the size limit.
+var errFileTooLarge = errors.New("file too large")
+
+// fileSizeLimiter reads up to limit bytes from r and fails with
+// errFileTooLarge if there are more.
+type fileSizeLimiter struct {
+	r     io.Reader
+	limit int64
+	n     int64
+}
+
+func (l *fileSizeLimiter) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.limit {
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+// uploadAttachmentHandler attaches the file sent as the "file" part of a
+// multipart/form-data body to one of the current user's tasks and returns
+// the updated task. The file is streamed to the blob store as it arrives.
+// Its content type is the part's, or, if that is missing or generic,
+// sniffed from the first bytes, and must be in the allowlist.
+func uploadAttachmentHandler(cfg AttachmentConfig) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := currentUser(r.Context()).ID
+		task, ok := loadOwnedTask(w, r)
+		if !ok || !checkIfMatch(w, r, task) {
+			return
+		}
+		if len(task.Attachments) >= maxAttachments {
+			writeError(w, http.StatusConflict, CodeConflict, fmt.Sprintf("a task can have at most %d attachments", maxAttachments))
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBytes+multipartOverhead)
+		mr, err := r.MultipartReader()
+		if err != nil {
+			writeError(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, "body must be multipart/form-data")
+			return
+		}
+		var part io.Reader
+		var filename, declared string
+		for {
+			p, err := mr.NextPart()
+			if err == io.EOF {
+				writeValidationErrors(w, validationErrors{"file": "is required"}, -1)
+				return
+			}
+			if err != nil {
+				writeUploadError(w, cfg, err)
+				return
+			}
+			if p.FormName() == "file" {
+				part, filename, declared = p, p.FileName(), p.Header.Get("Content-Type")
+				break
+			}
+		}
+		filename = cleanFilename(filename)
+		if filename == "" {
+			writeValidationErrors(w, validationErrors{"file": "must have a filename"}, -1)
+			return
+		}
+
+		br := bufio.NewReaderSize(part, 512)
+		head, err := br.Peek(512)
+		if err != nil && err != io.EOF && !errors.Is(err, bufio.ErrBufferFull) {
+			writeUploadError(w, cfg, err)
+			return
+		}
+		contentType, mediaType, ok := attachmentContentType(declared, head)
+		if !ok || !cfg.allows(mediaType) {
+			writeError(w, http.StatusUnsupportedMediaType, CodeUnsupportedMediaType, fmt.Sprintf("files of type %q are not accepted", contentType))
+			return
+		}
+
+		id, err := newTaskID()
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		key := attachmentBlobKey(task.ID, id)
+		sum := sha256.New()
+		size, err := blobStore.Put(r.Context(), key, io.TeeReader(&fileSizeLimiter{r: br, limit: cfg.MaxBytes}, sum))
+		if err != nil {
+			writeUploadError(w, cfg, err)
+			return
+		}
+
+		task.Attachments = append(task.Attachments, Attachment{
+			ID:          id,
+			Filename:    filename,
+			Size:        size,
+			ContentType: contentType,
+			Checksum:    hex.EncodeToString(sum.Sum(nil)),
+			UploadedBy:  userID,
+			CreatedAt:   time.Now().UTC(),
+		})
+		if err := taskRepo.Update(r.Context(), task); err != nil {
+			deleteBlob(r, key)
+			taskRepoError(w, err)
+			return
+		}
+		writeTask(w, r, http.StatusCreated, task.in(displayZone(w, r)))
+	}
+}
+
+// writeUploadError answers a failure reading or storing an upload.
+func writeUploadError(w http.ResponseWriter, cfg AttachmentConfig, err error) {
+	var maxErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, errFileTooLarge), errors.As(err, &maxErr):
+		writeError(w, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, fmt.Sprintf("file too large (limit %d bytes)", cfg.MaxBytes))
+	case errors.Is(err, io.ErrUnexpectedEOF), strings.HasPrefix(err.Error(), "multipart: "):
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "malformed multipart body")
+	default:
+		serverError(w, err)
+	}
+}
+
+// attachmentContentType returns the content type to store for a file whose
+// part declared declared and that starts with head, its media type without
+// parameters, and whether it could be parsed. Parameters other than charset
+// are dropped.
+func attachmentContentType(declared string, head []byte) (contentType, mediaType string, ok bool) {
+	if declared == "" || declared == "application/octet-stream" {
+		declared = http.DetectContentType(head)
+	}
+	mediaType, params, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return declared, "", false
+	}
+	if charset, ok := params["charset"]; ok {
+		return mime.FormatMediaType(mediaType, map[string]string{"charset": charset}), mediaType, true
+	}
+	return mediaType, mediaType, true
+}
+
+// cleanFilename strips any directories and control characters from the
+// name the client gave a file and caps its length.
+func cleanFilename(name string) string {
+	if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+		name = name[i+1:]
+	}
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if name == "." || name == ".." {
+		return ""
+	}
+	for len(name) > maxFilenameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+// downloadAttachmentHandler returns the contents of an attachment of a task
+// the current user can see.
+func downloadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadVisibleTask(w, r)
+	if !ok {
+		return
+	}
+	i := task.attachmentIndex(r.PathValue("attID"))
+	if i < 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, "attachment not found")
+		return
+	}
+	att := task.Attachments[i]
+	blob, err := blobStore.Open(r.Context(), attachmentBlobKey(task.ID, att.ID))
+	if err != nil {
+		if errors.Is(err, ErrBlobNotFound) {
+			slog.Error("attachment has no blob", "task_id", task.ID, "attachment_id", att.ID)
+		}
+		serverError(w, err)
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", att.ContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": att.Filename}))
+	w.Header().Set("ETag", `"`+att.Checksum+`"`)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if rs, ok := blob.(io.ReadSeeker); ok {
+		// Adds range requests and If-None-Match.
+		http.ServeContent(w, r, "", att.CreatedAt, rs)
+		return
+	}
+	w.Header().Set("Content-Length", fmt.Sprint(att.Size))
+	if _, err := io.Copy(w, blob); err != nil {
+		slog.Warn("sending attachment failed", "task_id", task.ID, "attachment_id", att.ID, "error", err)
+	}
+}
+
+// deleteAttachmentHandler removes an attachment and its contents and
+// returns the updated task.
+func deleteAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadOwnedTask(w, r)
+	if !ok || !checkIfMatch(w, r, task) {
+		return
+	}
+	i := task.attachmentIndex(r.PathValue("attID"))
+	if i < 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, "attachment not found")
+		return
+	}
+	att := task.Attachments[i]
+	task.Attachments = append(task.Attachments[:i], task.Attachments[i+1:]...)
+	if err := taskRepo.Update(r.Context(), task); err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	deleteBlob(r, attachmentBlobKey(task.ID, att.ID))
+	writeTask(w, r, http.StatusOK, task.in(displayZone(w, r)))
+}
+
+// deleteBlob removes a blob no task refers to any more. A failure only
+// leaves an orphaned file behind, so it is logged rather than reported.
+func deleteBlob(r *http.Request, key string) {
+	if err := blobStore.Delete(r.Context(), key); err != nil {
+		slog.Error("deleting attachment blob failed", "blob_key", key, "error", err)
+	}
+}
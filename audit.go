@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Audited actions.
+const (
+	AuditLogin          = "login"
+	AuditLogout         = "logout"
+	AuditTaskCreate     = "task_create"
+	AuditTaskDelete     = "task_delete"
+	AuditPasswordChange = "password_change"
+	AuditAdminAction    = "admin_action"
+)
+
+var auditActions = map[string]bool{
+	AuditLogin:          true,
+	AuditLogout:         true,
+	AuditTaskCreate:     true,
+	AuditTaskDelete:     true,
+	AuditPasswordChange: true,
+	AuditAdminAction:    true,
+}
+
+// AuditEntry records who did what to which object, and from where.
+type AuditEntry struct {
+	Time    time.Time `json:"time"`
+	ActorID int       `json:"actor_id"`
+	Action  string    `json:"action"`
+	// TargetID is the ID of the task or user acted on, if any.
+	TargetID string `json:"target_id,omitempty"`
+	// Detail qualifies the action, e.g. "delete_user" for admin_action or
+	// "hard owner=7" for a task_delete of another member's task.
+	Detail string `json:"detail,omitempty"`
+	IP     string `json:"ip"`
+}
+
+// AuditSink persists audit entries. Write is only ever called from the
+// AuditLog's worker goroutine.
+type AuditSink interface {
+	Write(e AuditEntry) error
+}
+
+// auditQueueSize is how many entries may wait for the sinks before new ones
+// are dropped.
+const auditQueueSize = 1024
+
+// auditMemoryEntries is how many recent entries GET /admin/audit can see.
+const auditMemoryEntries = 10000
+
+// AuditLog hands entries to its sinks on a background goroutine so that
+// recording never slows down a request. Entries are kept in memory for
+// GET /admin/audit and written to every other sink as well.
+type AuditLog struct {
+	memory *MemoryAuditSink
+	sinks  []AuditSink
+
+	queue chan AuditEntry
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+// startAuditLog starts an AuditLog writing to an in-memory sink plus sinks.
+func startAuditLog(sinks ...AuditSink) *AuditLog {
+	mem := NewMemoryAuditSink(auditMemoryEntries)
+	l := &AuditLog{
+		memory: mem,
+		sinks:  append([]AuditSink{mem}, sinks...),
+		queue:  make(chan AuditEntry, auditQueueSize),
+		quit:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// record queues an entry for action by actorID on targetID. It never blocks;
+// if the queue is full the entry is dropped and logged.
+func (l *AuditLog) record(r *http.Request, actorID int, action, targetID string) {
+	l.recordDetail(r, actorID, action, targetID, "")
+}
+
+func (l *AuditLog) recordDetail(r *http.Request, actorID int, action, targetID, detail string) {
+	e := AuditEntry{
+		Time:     time.Now().UTC(),
+		ActorID:  actorID,
+		Action:   action,
+		TargetID: targetID,
+		Detail:   detail,
+		IP:       clientIP(r),
+	}
+	select {
+	case l.queue <- e:
+	default:
+		slog.Warn("audit queue full, entry dropped", "action", action, "actor_id", actorID, "target_id", targetID)
+	}
+}
+
+// stop writes the entries still queued and stops the worker. As with the
+// recurrence worker the queue stays open so late records can't panic.
+func (l *AuditLog) stop() {
+	close(l.quit)
+	<-l.done
+}
+
+func (l *AuditLog) run() {
+	defer close(l.done)
+	for {
+		select {
+		case e := <-l.queue:
+			l.write(e)
+		case <-l.quit:
+			for {
+				select {
+				case e := <-l.queue:
+					l.write(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (l *AuditLog) write(e AuditEntry) {
+	for _, s := range l.sinks {
+		if err := s.Write(e); err != nil {
+			slog.Error("writing audit entry failed", "action", e.Action, "error", err)
+		}
+	}
+}
+
+// MemoryAuditSink keeps the most recent entries in memory.
+type MemoryAuditSink struct {
+	mu      sync.RWMutex
+	max     int
+	entries []AuditEntry
+}
+
+// NewMemoryAuditSink returns a sink that keeps up to max entries.
+func NewMemoryAuditSink(max int) *MemoryAuditSink {
+	return &MemoryAuditSink{max: max}
+}
+
+func (s *MemoryAuditSink) Write(e AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.entries) >= s.max {
+		// Drop the oldest entries in chunks so that most writes don't copy.
+		n := copy(s.entries, s.entries[len(s.entries)-s.max*9/10:])
+		s.entries = s.entries[:n]
+	}
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+// auditFilter selects entries for GET /admin/audit. Zero fields match all.
+type auditFilter struct {
+	From   *time.Time
+	To     *time.Time
+	Action string
+	Limit  int
+}
+
+// query returns up to f.Limit entries matching f, newest first.
+func (s *MemoryAuditSink) query(f auditFilter) []AuditEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]AuditEntry, 0)
+	for i := len(s.entries) - 1; i >= 0 && len(out) < f.Limit; i-- {
+		e := s.entries[i]
+		if f.Action != "" && e.Action != f.Action {
+			continue
+		}
+		if f.From != nil && e.Time.Before(*f.From) {
+			continue
+		}
+		if f.To != nil && !e.Time.Before(*f.To) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// FileAuditSink appends entries to a file as JSON lines.
+type FileAuditSink struct {
+	f   *os.File
+	enc *json.Encoder
+}
+
+// NewFileAuditSink opens path for appending, creating it if needed.
+func NewFileAuditSink(path string) (*FileAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditSink{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (s *FileAuditSink) Write(e AuditEntry) error {
+	return s.enc.Encode(e)
+}
+
+// Close closes the file. It must only be called after the AuditLog using
+// the sink has stopped.
+func (s *FileAuditSink) Close() error {
+	return s.f.Close()
+}
+
+// Audit query limits for GET /admin/audit.
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// adminAuditHandler lists recent audit entries, newest first. The from and
+// to query parameters (RFC 3339) bound the time range, from inclusive and
+// to exclusive; action restricts it to one action.
+func adminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	f := auditFilter{Action: q.Get("action"), Limit: defaultAuditLimit}
+	if f.Action != "" && !auditActions[f.Action] {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "action must be one of login, logout, task_create, task_delete, password_change, admin_action")
+		return
+	}
+	var err error
+	if f.From, err = parseTimeParam(q, "from"); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if f.To, err = parseTimeParam(q, "to"); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditLimit {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("limit must be an integer between 1 and %d", maxAuditLimit))
+			return
+		}
+		f.Limit = n
+	}
+	encode(w, r, http.StatusOK, map[string][]AuditEntry{"entries": auditLog.memory.query(f)})
+}
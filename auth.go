@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/alexedwards/scs/v2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// requireAuth rejects requests without an authenticated user with 401.
+// Requests carrying an Authorization header are authenticated by API key
+// alone and never fall back to the session cookie. Either way the user is
+// loaded and stored in the request context for currentUser.
+func requireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if token, ok := bearerToken(r); ok {
+			user, key, err := apiKeyUser(ctx, token)
+			if errors.Is(err, ErrAPIKeyNotFound) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="tms"`)
+				writeError(w, http.StatusUnauthorized, CodeUnauthorized, "invalid API key")
+				return
+			}
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+			ctx = context.WithValue(ctx, apiKeyKey, key)
+			next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, userKey, user)))
+			return
+		}
+
+		userID := sessionUserID(ctx)
+		if userID == 0 {
+			writeError(w, http.StatusUnauthorized, CodeUnauthorized, "not logged in")
+			return
+		}
+		user, err := userStore.Get(ctx, userID)
+		if errors.Is(err, ErrUserNotFound) {
+			// The session outlived its user, e.g. a persistent session store
+			// with the in-memory user store after a restart.
+			writeError(w, http.StatusUnauthorized, CodeUnauthorized, "not logged in")
+			return
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		touchSession(ctx)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, userKey, user)))
+	})
+}
+
+// requireRole rejects requests whose user doesn't have role with 403. It
+// must run behind requireAuth so that anonymous requests still get a 401.
+func requireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if currentUser(r.Context()).Role != role {
+			writeError(w, http.StatusForbidden, CodeForbidden, "insufficient permissions")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// currentUser returns the user stored by requireAuth. It must only be called
+// from handlers behind requireAuth.
+func currentUser(ctx context.Context) User {
+	user, ok := ctx.Value(userKey).(User)
+	if !ok {
+		panic("currentUser called outside requireAuth")
+	}
+	return user
+}
+
+// seedAdmin creates an admin account from ADMIN_USERNAME/ADMIN_PASSWORD when
+// the user store is empty, so that a fresh deployment has someone who can
+// manage it. It does nothing if username is empty or users already exist.
+func seedAdmin(ctx context.Context, store UserStore, username, password string) error {
+	if username == "" {
+		return nil
+	}
+	n, err := store.Count(ctx)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("hashing ADMIN_PASSWORD: %w", err)
+	}
+	user, err := store.Create(ctx, User{Username: username, PasswordHash: hash, Role: RoleAdmin})
+	if err != nil {
+		return err
+	}
+	slog.Info("created initial admin user", "user_id", user.ID, "username", user.Username)
+	return nil
+}
+
+// destroyUserSessions deletes every stored session belonging to userID except
+// the one with token keep, and returns how many were deleted. It is a no-op
+// for session stores that can't be enumerated.
+func destroyUserSessions(ctx context.Context, userID int, keep string) (int, error) {
+	if _, ok := sessionManager.Store.(scs.IterableStore); !ok {
+		return 0, nil
+	}
+	n := 0
+	err := forEachUserSession(ctx, userID, func(sctx context.Context, token string) error {
+		if token == keep {
+			return nil
+		}
+		if err := sessionManager.Destroy(sctx); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyPasswordHash is compared against when the username is unknown so that
+// a failed login takes the same time whether or not the user exists.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// loginHandler logs a user in. After too many consecutive failures for one
+// username the account is locked out with a 429, whether or not it exists.
+func loginHandler(lockout *loginLockout) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in credentials
+		if !decodeJSON(w, r, &in) {
+			return
+		}
+
+		username := normalizeUsername(in.Username)
+		if wait, locked := lockout.locked(username); locked {
+			writeLockedOut(w, wait)
+			return
+		}
+		user, err := userStore.GetByUsername(r.Context(), username)
+		if err != nil && !errors.Is(err, ErrUserNotFound) {
+			serverError(w, err)
+			return
+		}
+
+		hash := user.PasswordHash
+		if err != nil {
+			hash = dummyPasswordHash
+		}
+		if bcrypt.CompareHashAndPassword(hash, []byte(in.Password)) != nil || err != nil {
+			if wait, locked := lockout.fail(username); locked {
+				slog.Warn("login locked out after repeated failures", "username", username, "ip", clientIP(r))
+				writeLockedOut(w, wait)
+				return
+			}
+			writeError(w, http.StatusUnauthorized, CodeInvalidCredentials, "invalid username or password")
+			return
+		}
+		lockout.succeed(username)
+
+		// Issue a fresh session token on privilege change to prevent session fixation.
+		if err := sessionManager.RenewToken(r.Context()); err != nil {
+			serverError(w, err)
+			return
+		}
+		putSessionUser(r.Context(), user)
+		recordSessionStart(r.Context(), r)
+		auditLog.record(r, user.ID, AuditLogin, "")
+
+		encode(w, r, http.StatusOK, map[string]any{"id": user.ID, "username": user.Username, "role": user.Role})
+	}
+}
+
+// passwordPolicyError lists the rules a new password breaks, as returned by
+// PasswordPolicy.Check. Its messages are safe to show to the client.
+type passwordPolicyError struct {
+	violations map[string]string
+}
+
+func (e passwordPolicyError) Error() string { return "password does not meet the password policy" }
+
+// hashPassword checks password against policy and returns its bcrypt hash.
+// Policy violations are reported as passwordPolicyError.
+func hashPassword(password string, policy PasswordPolicy) ([]byte, error) {
+	if violations := policy.Check(password); violations != nil {
+		return nil, passwordPolicyError{violations}
+	}
+	return bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+}
+
+// passwordError writes a 422 for a policy violation, with one entry per
+// broken rule keyed "<field>.<rule>", and a 500 otherwise.
+func passwordError(w http.ResponseWriter, err error, field string) {
+	var pe passwordPolicyError
+	if errors.As(err, &pe) {
+		fields := validationErrors{}
+		for rule, msg := range pe.violations {
+			fields[field+"."+rule] = msg
+		}
+		writeAPIError(w, http.StatusUnprocessableEntity, apiError{Code: CodeValidationFailed, Message: pe.Error(), Fields: fields})
+		return
+	}
+	serverError(w, err)
+}
+
+// registerHandler creates an account. Passwords that don't meet policy are
+// rejected; only the bcrypt hash is ever stored.
+func registerHandler(policy PasswordPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in credentials
+		if !decodeJSON(w, r, &in) {
+			return
+		}
+
+		username := normalizeUsername(in.Username)
+		if username == "" {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "username must not be empty")
+			return
+		}
+		hash, err := hashPassword(in.Password, policy)
+		if err != nil {
+			passwordError(w, err, "password")
+			return
+		}
+
+		user, err := userStore.Create(r.Context(), User{Username: username, PasswordHash: hash, Role: RoleUser})
+		if errors.Is(err, ErrUsernameTaken) {
+			writeError(w, http.StatusConflict, CodeConflict, "username already taken")
+			return
+		} else if err != nil {
+			serverError(w, err)
+			return
+		}
+		encode(w, r, http.StatusCreated, map[string]any{"id": user.ID, "username": user.Username})
+	}
+}
+
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+	userID := sessionUserID(r.Context())
+	if err := sessionManager.Destroy(r.Context()); err != nil {
+		serverError(w, err)
+		return
+	}
+	if userID != 0 {
+		auditLog.record(r, userID, AuditLogout, "")
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userProfile is the public view of a User. It never includes the password
+// hash.
+type userProfile struct {
+	ID        int       `json:"id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// meHandler returns the profile of the logged-in user.
+func meHandler(w http.ResponseWriter, r *http.Request) {
+	u := currentUser(r.Context())
+	encode(w, r, http.StatusOK, userProfile{ID: u.ID, Username: u.Username, Role: u.Role, CreatedAt: u.CreatedAt})
+}
+
+type passwordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// changePasswordHandler replaces the logged-in user's password after checking
+// the current one. The session token is rotated and every other session of
+// the user is destroyed, so a stolen session doesn't survive the change.
+func changePasswordHandler(policy PasswordPolicy) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var in passwordChange
+		if !decodeJSON(w, r, &in) {
+			return
+		}
+
+		user := currentUser(r.Context())
+		if bcrypt.CompareHashAndPassword(user.PasswordHash, []byte(in.CurrentPassword)) != nil {
+			writeError(w, http.StatusUnauthorized, CodeInvalidCredentials, "current password is incorrect")
+			return
+		}
+		hash, err := hashPassword(in.NewPassword, policy)
+		if err != nil {
+			passwordError(w, err, "new_password")
+			return
+		}
+
+		user.PasswordHash = hash
+		if err := userStore.Update(r.Context(), user); err != nil {
+			serverError(w, err)
+			return
+		}
+		if err := sessionManager.RenewToken(r.Context()); err != nil {
+			serverError(w, err)
+			return
+		}
+		if _, err := destroyUserSessions(r.Context(), user.ID, sessionManager.Token(r.Context())); err != nil {
+			// The password has changed; failing to kick out other sessions
+			// is logged rather than reported as a failed change.
+			slog.Error("destroying other sessions failed", "user_id", user.ID, "error", err)
+		}
+		auditLog.record(r, user.ID, AuditPasswordChange, "")
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Outcomes of a task of a batch operation.
+const (
+	BatchSucceeded = "succeeded"
+	BatchNotFound  = "not_found"
+	BatchForbidden = "forbidden"
+)
+
+// batchResult is the outcome of a batch operation for one task. Task is the
+// task as it is now, only set when Status is BatchSucceeded.
+type batchResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"`
+	Task   *Task  `json:"task,omitempty"`
+}
+
+// batchDoneInput is the body of POST /tasks/batch/done.
+type batchDoneInput struct {
+	IDs  []string `json:"ids"`
+	Done *bool    `json:"done"`
+}
+
+func (in batchDoneInput) Validate() error {
+	errs := validationErrors{}
+	if len(in.IDs) == 0 {
+		errs["ids"] = "required"
+	}
+	seen := make(map[string]bool, len(in.IDs))
+	for i, id := range in.IDs {
+		if seen[id] {
+			errs[fmt.Sprintf("ids[%d]", i)] = "duplicate"
+		}
+		seen[id] = true
+	}
+	if in.Done == nil {
+		errs["done"] = "required"
+	}
+	return errs.orNil()
+}
+
+// batchDoneHandler marks up to maxTasks tasks done or not done at once, and
+// reports for each listed ID, in order, whether that succeeded. The tasks
+// are those PATCH /tasks/{id} could change: the user's personal tasks or,
+// with a workspace active, any of its tasks. Others are forbidden, and
+// missing tasks or those in the trash not found. Tasks already in the
+// requested state succeed unchanged.
+func batchDoneHandler(maxTasks int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := currentUser(r.Context()).ID
+
+		var in batchDoneInput
+		if !decodeJSON(w, r, &in) {
+			return
+		}
+		if len(in.IDs) > maxTasks {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("at most %d tasks can be updated at once, got %d", maxTasks, len(in.IDs)))
+			return
+		}
+		if err := in.Validate(); err != nil {
+			writeValidationErrors(w, err, -1)
+			return
+		}
+
+		changed, err := taskRepo.SetDone(r.Context(), userID, activeWorkspaceID(r.Context()), in.IDs, *in.Done, time.Now().UTC())
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		byID := make(map[string]Task, len(changed))
+		for _, t := range changed {
+			byID[t.ID] = t
+			recurringTasks.enqueue(t)
+		}
+
+		zone := displayZone(w, r)
+		results := make([]batchResult, len(in.IDs))
+		for i, id := range in.IDs {
+			results[i] = batchResult{ID: id, Status: BatchSucceeded}
+			t, ok := byID[id]
+			if !ok {
+				// Unchanged: tell why, or fetch it as it already is.
+				if _, err := parseTaskID(id); err != nil {
+					results[i].Status = BatchNotFound
+					continue
+				}
+				t, err = taskRepo.Get(r.Context(), id)
+				// The same checks as loadOwnedTask, in the same order.
+				switch {
+				case errors.Is(err, ErrTaskNotFound):
+					results[i].Status = BatchNotFound
+					continue
+				case err != nil:
+					serverError(w, err)
+					return
+				case !inActiveWorkspace(r.Context(), t) || (t.WorkspaceID == nil && t.OwnerID != userID):
+					results[i].Status = BatchForbidden
+					continue
+				case t.DeletedAt != nil:
+					results[i].Status = BatchNotFound
+					continue
+				}
+			}
+			t = t.in(zone)
+			results[i].Task = &t
+		}
+		encode(w, r, http.StatusOK, map[string][]batchResult{"results": results})
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// taskRepos returns a constructor for the task repository of each backend.
+// PostgreSQL skips the test unless TEST_DATABASE_URL names a database to
+// use; it gets migrated.
+func taskRepos() map[string]func(t *testing.T) TaskRepository {
+	return map[string]func(t *testing.T) TaskRepository{
+		"memory": func(t *testing.T) TaskRepository {
+			return NewMemoryTaskRepo(0)
+		},
+		"postgres": func(t *testing.T) TaskRepository {
+			url := os.Getenv("TEST_DATABASE_URL")
+			if url == "" {
+				t.Skip("TEST_DATABASE_URL is not set")
+			}
+			ctx := context.Background()
+			db, err := openPostgres(ctx, url, 2, 2, time.Minute, RetryConfig{MaxAttempts: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			if err := runMigrations(ctx, db); err != nil {
+				t.Fatal(err)
+			}
+			return NewPostgresTaskRepo(db)
+		},
+	}
+}
+
+// batchFixture holds the tasks the SetDone tests work on. Owner and
+// colleague share workspace; the IDs are random so that runs against a
+// shared database don't see each other's tasks.
+type batchFixture struct {
+	owner, colleague, workspace int
+	personal, trashed, done     Task
+	colleagues, shared, foreign Task
+}
+
+func newBatchFixture(t *testing.T, repo TaskRepository) batchFixture {
+	t.Helper()
+	ctx := context.Background()
+	base := rand.IntN(1<<30) + 1<<30
+	f := batchFixture{owner: base, colleague: base + 1, workspace: base}
+	other := base + 1
+	create := func(task Task) Task {
+		t.Helper()
+		created, err := repo.Create(ctx, task, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return created
+	}
+	now := time.Now().UTC()
+	f.personal = create(Task{OwnerID: f.owner, Title: "personal"})
+	f.trashed = create(Task{OwnerID: f.owner, Title: "trashed", DeletedAt: &now})
+	f.done = create(Task{OwnerID: f.owner, Title: "done", Done: true, CompletedAt: &now})
+	f.colleagues = create(Task{OwnerID: f.colleague, Title: "colleague's"})
+	f.shared = create(Task{OwnerID: f.colleague, WorkspaceID: &f.workspace, Title: "shared"})
+	f.foreign = create(Task{OwnerID: f.owner, WorkspaceID: &other, Title: "elsewhere"})
+	return f
+}
+
+func changedIDs(tasks []Task) map[string]bool {
+	ids := make(map[string]bool, len(tasks))
+	for _, t := range tasks {
+		ids[t.ID] = true
+	}
+	return ids
+}
+
+func TestSetDone(t *testing.T) {
+	for backend, newRepo := range taskRepos() {
+		t.Run(backend, func(t *testing.T) {
+			ctx := context.Background()
+			repo := newRepo(t)
+			f := newBatchFixture(t, repo)
+			all := []string{f.personal.ID, f.trashed.ID, f.done.ID, f.colleagues.ID, f.shared.ID, f.foreign.ID, newTestTaskID(t)}
+			at := time.Now().UTC().Truncate(time.Second)
+
+			// Personal scope: only the owner's own live personal tasks
+			// that aren't done yet.
+			changed, err := repo.SetDone(ctx, f.owner, 0, all, true, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := changedIDs(changed); len(ids) != 1 || !ids[f.personal.ID] {
+				t.Errorf("personal scope changed %v, want only %s", ids, f.personal.ID)
+			}
+			got, err := repo.Get(ctx, f.personal.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Done || got.CompletedAt == nil || !got.CompletedAt.Equal(at) {
+				t.Errorf("personal task: done %v, completed at %v, want done at %v", got.Done, got.CompletedAt, at)
+			}
+
+			// Workspace scope: any member's tasks of that workspace.
+			changed, err = repo.SetDone(ctx, f.owner, f.workspace, all, true, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids := changedIDs(changed); len(ids) != 1 || !ids[f.shared.ID] {
+				t.Errorf("workspace scope changed %v, want only %s", ids, f.shared.ID)
+			}
+
+			// Marking not done clears the completion time.
+			changed, err = repo.SetDone(ctx, f.owner, 0, []string{f.personal.ID, f.done.ID}, false, at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(changed) != 2 {
+				t.Errorf("reopened %d tasks, want 2", len(changed))
+			}
+			for _, task := range changed {
+				if task.Done || task.CompletedAt != nil {
+					t.Errorf("task %s: done %v, completed at %v after reopening", task.ID, task.Done, task.CompletedAt)
+				}
+			}
+		})
+	}
+}
+
+// newTestTaskID returns a well-formed ID that no task has.
+func newTestTaskID(t *testing.T) string {
+	t.Helper()
+	id, err := newTaskID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestBatchDoneHandler(t *testing.T) {
+	savedRepo, savedWorker := taskRepo, recurringTasks
+	t.Cleanup(func() { taskRepo, recurringTasks = savedRepo, savedWorker })
+	taskRepo = NewMemoryTaskRepo(0)
+	recurringTasks = &recurrenceWorker{queue: make(chan string, 16)}
+	f := newBatchFixture(t, taskRepo)
+	missing := newTestTaskID(t)
+
+	batch := func(t *testing.T, workspaceID int, body string) (int, map[string]string) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodPost, "/tasks/batch/done", strings.NewReader(body))
+		ctx := context.WithValue(r.Context(), userKey, User{ID: f.owner})
+		ctx = context.WithValue(ctx, workspaceKey, workspaceID)
+		w := httptest.NewRecorder()
+		batchDoneHandler(3).ServeHTTP(w, r.WithContext(ctx))
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		var res struct {
+			Results []batchResult `json:"results"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		statuses := make(map[string]string, len(res.Results))
+		for _, r := range res.Results {
+			statuses[r.ID] = r.Status
+			if (r.Task != nil) != (r.Status == BatchSucceeded) {
+				t.Errorf("%s: status %s with task %v", r.ID, r.Status, r.Task)
+			}
+		}
+		return http.StatusOK, statuses
+	}
+	ids := func(ids ...string) string {
+		b, _ := json.Marshal(ids)
+		return `{"ids":` + string(b) + `,"done":true}`
+	}
+
+	tests := []struct {
+		name      string
+		workspace int
+		id        string
+		want      string
+	}{
+		{"own personal task", 0, f.personal.ID, BatchSucceeded},
+		{"already done", 0, f.done.ID, BatchSucceeded},
+		{"missing", 0, missing, BatchNotFound},
+		{"malformed ID", 0, "nope", BatchNotFound},
+		{"trashed", 0, f.trashed.ID, BatchNotFound},
+		{"another user's personal task", 0, f.colleagues.ID, BatchForbidden},
+		{"workspace task without the workspace active", 0, f.shared.ID, BatchForbidden},
+		{"colleague's task in the active workspace", f.workspace, f.shared.ID, BatchSucceeded},
+		{"own task of another workspace", f.workspace, f.foreign.ID, BatchForbidden},
+		{"personal task with a workspace active", f.workspace, f.done.ID, BatchForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, statuses := batch(t, tt.workspace, ids(tt.id))
+			if code != http.StatusOK {
+				t.Fatalf("status %d, want 200", code)
+			}
+			if got := statuses[tt.id]; got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("forbidden tasks are left alone", func(t *testing.T) {
+		for _, id := range []string{f.colleagues.ID, f.foreign.ID} {
+			task, err := taskRepo.Get(context.Background(), id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if task.Done {
+				t.Errorf("%s (%s) was marked done", task.ID, task.Title)
+			}
+		}
+	})
+
+	t.Run("over the cap", func(t *testing.T) {
+		if code, _ := batch(t, 0, ids(f.personal.ID, f.done.ID, f.trashed.ID, missing)); code != http.StatusBadRequest {
+			t.Errorf("status %d, want 400", code)
+		}
+	})
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrBlobNotFound is returned by a BlobStore for keys it has no blob for.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps the contents of attachments. Keys are slash-separated
+// paths such as "<taskID>/<attachmentID>"; the metadata lives on the task.
+type BlobStore interface {
+	// Put stores the contents of r under key, replacing any blob already
+	// there, and returns the number of bytes written. If reading r fails
+	// nothing is stored and the error is returned as is.
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Open(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob under key; deleting a missing blob is not an
+	// error.
+	Delete(ctx context.Context, key string) error
+	// List returns the keys of every stored blob starting with prefix.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// FSBlobStore is a BlobStore keeping each blob in a file under a directory.
+type FSBlobStore struct {
+	dir string
+}
+
+// NewFSBlobStore returns a store keeping blobs under dir, creating it if
+// needed.
+func NewFSBlobStore(dir string) (*FSBlobStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &FSBlobStore{dir: dir}, nil
+}
+
+// path returns the file a key is kept in, refusing keys that would escape
+// the store's directory.
+func (s *FSBlobStore) path(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) || strings.HasSuffix(key, ".tmp") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file that is renamed into place once
+// complete, so that a failed or partial upload never shows up under key.
+func (s *FSBlobStore) Put(ctx context.Context, key string, r io.Reader) (int64, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o750); err != nil {
+		return 0, err
+	}
+	f, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *FSBlobStore) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+func (s *FSBlobStore) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	// Drop the task's directory once its last blob is gone; this fails
+	// harmlessly while others remain.
+	if dir := filepath.Dir(p); dir != filepath.Clean(s.dir) {
+		os.Remove(dir)
+	}
+	return nil
+}
+
+// List skips the temporary files of uploads in progress.
+func (s *FSBlobStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, p)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	return keys, err
+}
+
+// startAttachmentSweeper removes, every interval, the blobs of tasks that
+// no longer exist. Removing a single attachment deletes its blob right
+// away, but tasks are removed in bulk in several places (the trash purge,
+// imports, deleting a user or workspace) that don't know about attachments.
+// The returned function stops the sweeper and waits for a running sweep to
+// finish.
+func startAttachmentSweeper(repo TaskRepository, blobs BlobStore, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			n, err := sweepAttachments(ctx, repo, blobs)
+			if err != nil {
+				slog.Error("sweeping attachments failed", "error", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("swept attachments of removed tasks", "blobs", n)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// sweepAttachments deletes the blobs of removed tasks and returns how many
+// it deleted.
+func sweepAttachments(ctx context.Context, repo TaskRepository, blobs BlobStore) (int, error) {
+	keys, err := blobs.List(ctx, "")
+	if err != nil {
+		return 0, err
+	}
+	removed := make(map[string]bool)
+	n := 0
+	for _, key := range keys {
+		taskID := path.Dir(key)
+		gone, checked := removed[taskID]
+		if !checked {
+			_, err := repo.Get(ctx, taskID)
+			if err != nil && !errors.Is(err, ErrTaskNotFound) {
+				return n, err
+			}
+			gone = err != nil
+			removed[taskID] = gone
+		}
+		if !gone {
+			continue
+		}
+		if err := blobs.Delete(ctx, key); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// taskCalendar is the body of GET /tasks/calendar. Days and Counts have an
+// entry for every day of the range, Weeks one for every ISO week it
+// touches, as YYYY-Www.
+type taskCalendar struct {
+	From     string            `json:"from"`
+	To       string            `json:"to"`
+	TimeZone string            `json:"time_zone"`
+	Days     map[string][]Task `json:"days"`
+	Counts   map[string]int    `json:"counts"`
+	Weeks    map[string]int    `json:"weeks"`
+}
+
+// isoWeek returns the ISO week of t as YYYY-Www.
+func isoWeek(t time.Time) string {
+	year, week := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", year, week)
+}
+
+// calendarHandler returns the tasks of the active workspace, or the current
+// user's personal tasks, due between the from and to days, both included,
+// grouped by the day they are due in the zone named by X-Timezone. The
+// range may span at most maxDays days.
+func calendarHandler(maxDays int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		loc := displayZone(w, r)
+		from, errFrom := time.ParseInLocation(time.DateOnly, q.Get("from"), loc)
+		to, errTo := time.ParseInLocation(time.DateOnly, q.Get("to"), loc)
+		switch {
+		case errFrom != nil || errTo != nil:
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "from and to are required, as YYYY-MM-DD")
+			return
+		case to.Before(from):
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "to must not be before from")
+			return
+		}
+		// Counted in calendar days: one may have 23 or 25 hours.
+		end := to.AddDate(0, 0, 1)
+		days := 0
+		for d := from; d.Before(end); d = d.AddDate(0, 0, 1) {
+			if days++; days > maxDays {
+				writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("the range can span at most %d days", maxDays))
+				return
+			}
+		}
+
+		// DueAfter excludes its own instant.
+		after := from.Add(-time.Nanosecond)
+		opts := ListOptions{DueAfter: &after, DueBefore: &end}
+		scopeToWorkspace(&opts, currentUser(r.Context()).ID, activeWorkspaceID(r.Context()))
+		tasks, _, err := taskRepo.List(r.Context(), opts)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].DueDate.Before(*tasks[j].DueDate) })
+
+		cal := taskCalendar{
+			From:     from.Format(time.DateOnly),
+			To:       to.Format(time.DateOnly),
+			TimeZone: loc.String(),
+			Days:     make(map[string][]Task, days),
+			Counts:   make(map[string]int, days),
+			Weeks:    make(map[string]int),
+		}
+		for d := from; d.Before(end); d = d.AddDate(0, 0, 1) {
+			cal.Days[d.Format(time.DateOnly)] = []Task{}
+			cal.Counts[d.Format(time.DateOnly)] = 0
+			cal.Weeks[isoWeek(d)] = 0
+		}
+		for _, t := range tasksIn(tasks, loc) {
+			due := t.DueDate.In(loc)
+			day := due.Format(time.DateOnly)
+			cal.Days[day] = append(cal.Days[day], t)
+			cal.Counts[day]++
+			cal.Weeks[isoWeek(due)]++
+		}
+		encode(w, r, http.StatusOK, cal)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxCommentLen bounds the body of a comment, in characters, before
+// escaping.
+const maxCommentLen = 5000
+
+// Comment is one entry of a task's discussion thread.
+type Comment struct {
+	ID       string `json:"id"`
+	TaskID   string `json:"task_id"`
+	AuthorID int    `json:"author_id"`
+	// Body is plain text, stored HTML-escaped so that clients can insert it
+	// into a page as is.
+	Body      string    `json:"body"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// commentInput is the body of POST /tasks/{id}/comments.
+type commentInput struct {
+	Body string `json:"body"`
+}
+
+// commentPage is the envelope returned by GET /tasks/{id}/comments.
+type commentPage struct {
+	Items  []Comment `json:"items"`
+	Total  int       `json:"total"`
+	Limit  int       `json:"limit"`
+	Offset int       `json:"offset"`
+}
+
+// cleanCommentBody drops control characters other than newlines and tabs,
+// normalizes line endings and trims surrounding space.
+func cleanCommentBody(body string) string {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	return strings.TrimSpace(strings.Map(func(r rune) rune {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, body))
+}
+
+// createCommentHandler adds a comment to a task the current user can see,
+// which includes its assignee and the members of its workspace.
+func createCommentHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r.Context()).ID
+	task, ok := loadVisibleTask(w, r)
+	if !ok {
+		return
+	}
+
+	var in commentInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	body := cleanCommentBody(in.Body)
+	errs := validationErrors{}
+	switch {
+	case body == "":
+		errs["body"] = "required"
+	case utf8.RuneCountInString(body) > maxCommentLen:
+		errs["body"] = fmt.Sprintf("must be at most %d characters", maxCommentLen)
+	}
+	if err := errs.orNil(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+
+	c, err := taskRepo.AddComment(r.Context(), Comment{TaskID: task.ID, AuthorID: userID, Body: html.EscapeString(body)})
+	if err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	encode(w, r, http.StatusCreated, c)
+}
+
+// listCommentsHandler returns a page of a task's comments, newest first.
+func listCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	limit, offset, err := parsePage(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	task, ok := loadVisibleTask(w, r)
+	if !ok {
+		return
+	}
+	comments, total, err := taskRepo.Comments(r.Context(), task.ID, limit, offset)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, commentPage{Items: comments, Total: total, Limit: limit, Offset: offset})
+}
+
+// deleteCommentHandler removes a comment. Only its author and admins may,
+// and admins may on any task, whoever it belongs to.
+func deleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r.Context())
+	var task Task
+	var ok bool
+	if user.Role == RoleAdmin {
+		task, ok = loadAnyTask(w, r)
+	} else {
+		task, ok = loadVisibleTask(w, r)
+	}
+	if !ok {
+		return
+	}
+	c, err := taskRepo.GetComment(r.Context(), task.ID, r.PathValue("commentID"))
+	if err != nil {
+		commentError(w, err)
+		return
+	}
+	if c.AuthorID != user.ID && user.Role != RoleAdmin {
+		writeError(w, http.StatusForbidden, CodeForbidden, "only the author or an admin can delete a comment")
+		return
+	}
+	if err := taskRepo.DeleteComment(r.Context(), task.ID, c.ID); err != nil {
+		commentError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// loadAnyTask loads the live task named by the {id} path segment without
+// checking who it belongs to, for admins. If it returns false a response has
+// already been written.
+func loadAnyTask(w http.ResponseWriter, r *http.Request) (Task, bool) {
+	task, ok := loadAnyTaskOrTrashed(w, r)
+	if ok && task.DeletedAt != nil {
+		taskRepoError(w, ErrTaskNotFound)
+		return Task{}, false
+	}
+	return task, ok
+}
+
+// loadAnyTaskOrTrashed is loadAnyTask for endpoints that also act on tasks
+// in the trash.
+func loadAnyTaskOrTrashed(w http.ResponseWriter, r *http.Request) (Task, bool) {
+	id, ok := taskIDParam(w, r)
+	if !ok {
+		return Task{}, false
+	}
+	task, err := taskRepo.Get(r.Context(), id)
+	if err != nil {
+		taskRepoError(w, err)
+		return Task{}, false
+	}
+	return task, true
+}
+
+// commentError maps comment lookup errors to responses.
+func commentError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrCommentNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "comment not found")
+		return
+	}
+	taskRepoError(w, err)
+}
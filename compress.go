@@ -0,0 +1,306 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressionConfig controls response compression.
+type CompressionConfig struct {
+	// MinBytes is the smallest response body worth compressing; shorter
+	// bodies are sent as they are.
+	MinBytes int
+	// Level is the compression level, from 1 (fastest) to 9 (smallest). 0
+	// disables compression.
+	Level int
+}
+
+// compressor is the part of *gzip.Writer and *zlib.Writer that pooling needs.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// compressResponses gzip- or deflate-encodes response bodies of at least
+// cfg.MinBytes for clients that accept it. Bodies that are already encoded
+// or of an already compressed media type, responses that are flushed before
+// reaching the threshold (event streams) and requests to the paths in exempt
+// are passed through untouched. Writers are pooled, since allocating one per
+// request costs hundreds of kilobytes.
+func compressResponses(cfg CompressionConfig, exempt []string, next http.Handler) http.Handler {
+	if cfg.Level == 0 {
+		return next
+	}
+	skip := make(map[string]bool, len(exempt))
+	for _, p := range exempt {
+		skip[p] = true
+	}
+	// The level has been validated, so the constructors can't fail.
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() any {
+			zw, _ := gzip.NewWriterLevel(io.Discard, cfg.Level)
+			return zw
+		}},
+		"deflate": {New: func() any {
+			zw, _ := zlib.NewWriterLevel(io.Discard, cfg.Level)
+			return zw
+		}},
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if skip[r.URL.Path] || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, minBytes: cfg.MinBytes}
+		// Clients that accept neither encoding still go through the writer,
+		// which adds Vary to their responses too.
+		if cw.encoding = negotiateEncoding(r.Header.Get("Accept-Encoding")); cw.encoding != "" {
+			cw.pool = pools[cw.encoding]
+		} else {
+			cw.state = compressBypassed
+		}
+		next.ServeHTTP(cw, r)
+		cw.close()
+	})
+}
+
+// negotiateEncoding picks "gzip" or "deflate" from an Accept-Encoding header,
+// preferring gzip when both are equally acceptable, or returns "" if the
+// client accepts neither.
+func negotiateEncoding(header string) string {
+	gzipQ, deflateQ, anyQ := -1.0, -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			k, v, ok := strings.Cut(param, "=")
+			if ok && strings.TrimSpace(k) == "q" {
+				if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+					q = f
+				} else {
+					q = 0
+				}
+			}
+		}
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "deflate":
+			deflateQ = q
+		case "*":
+			anyQ = q
+		}
+	}
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if deflateQ < 0 {
+		deflateQ = anyQ
+	}
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	}
+	return ""
+}
+
+// compressibleType reports whether a body of the given media type is likely
+// to shrink. Event streams are excluded because their clients read them
+// incrementally.
+func compressibleType(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		// Unset or unparseable; net/http would sniff it as text or binary.
+		return true
+	}
+	switch {
+	case mt == "text/event-stream":
+		return false
+	case mt == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mt, "image/"), strings.HasPrefix(mt, "audio/"), strings.HasPrefix(mt, "video/"), strings.HasPrefix(mt, "font/woff"):
+		return false
+	}
+	switch mt {
+	case "application/gzip", "application/x-gzip", "application/zip", "application/zstd", "application/x-bzip2", "application/pdf":
+		return false
+	}
+	return true
+}
+
+// Compression states of a compressWriter.
+const (
+	compressPending = iota
+	compressActive
+	compressBypassed
+)
+
+// compressWriter buffers the start of a response until it knows whether it
+// is worth compressing: once minBytes have been written it starts
+// compressing, and if the handler finishes or flushes first the buffered
+// bytes are sent as they are.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	minBytes int
+	pool     *sync.Pool
+
+	state  int
+	status int
+	buf    []byte
+	zw     compressor
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if code < http.StatusOK {
+		// Informational responses go out as they are; the final one follows.
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if cw.status != 0 {
+		if cw.state != compressPending {
+			// Superfluous; let net/http report it.
+			cw.ResponseWriter.WriteHeader(code)
+		}
+		return
+	}
+	cw.status = code
+	// Set here rather than up front: http.TimeoutHandler replaces the
+	// headers set before the handler ran with its own.
+	cw.Header().Add("Vary", "Accept-Encoding")
+	switch {
+	case cw.state == compressBypassed:
+		cw.ResponseWriter.WriteHeader(code)
+	case !cw.compressible():
+		cw.bypass()
+	}
+}
+
+// compressible reports whether the response, as described by its status
+// and headers so far, may be compressed.
+func (cw *compressWriter) compressible() bool {
+	h := cw.Header()
+	if cw.status == http.StatusNoContent || cw.status == http.StatusNotModified {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || !compressibleType(h.Get("Content-Type")) {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.minBytes {
+		return false
+	}
+	return true
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	switch cw.state {
+	case compressActive:
+		return cw.zw.Write(b)
+	case compressBypassed:
+		return cw.ResponseWriter.Write(b)
+	}
+	cw.buf = append(cw.buf, b...)
+	if len(cw.buf) >= cw.minBytes {
+		if err := cw.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start sends the headers of a compressed response and compresses what has
+// been buffered so far.
+func (cw *compressWriter) start() error {
+	h := cw.Header()
+	if h.Get("Content-Type") == "" {
+		// Sniff the plain bytes; net/http would otherwise sniff compressed ones.
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	// The compressed bytes differ from the identity representation, so a
+	// strong tag no longer applies to them.
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	cw.state = compressActive
+	cw.zw = cw.pool.Get().(compressor)
+	cw.zw.Reset(cw.ResponseWriter)
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.zw.Write(buf)
+	return err
+}
+
+// bypass sends the response uncompressed from now on, starting with what has
+// been buffered.
+func (cw *compressWriter) bypass() error {
+	cw.state = compressBypassed
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// FlushError is used by http.ResponseController. A response that is flushed
+// before it is large enough to compress is assumed to be a stream and is
+// sent uncompressed.
+func (cw *compressWriter) FlushError() error {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	switch cw.state {
+	case compressPending:
+		if err := cw.bypass(); err != nil {
+			return err
+		}
+	case compressActive:
+		if err := cw.zw.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+func (cw *compressWriter) Flush() {
+	cw.FlushError()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the response once the handler has returned and puts the
+// compressor back in the pool.
+func (cw *compressWriter) close() {
+	switch cw.state {
+	case compressPending:
+		cw.bypass()
+	case compressActive:
+		cw.zw.Close()
+		cw.zw.Reset(io.Discard)
+		cw.pool.Put(cw.zw)
+		cw.zw = nil
+	}
+}
@@ -0,0 +1,654 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// Config holds every tunable of the server. It is loaded once at startup by
+// LoadConfig so that misconfiguration is reported at boot.
+type Config struct {
+	Port            string
+	ShutdownTimeout time.Duration
+	// ShutdownDrainDelay is how long /readyz answers 503 after a shutdown
+	// signal, while requests are still served, before the server stops
+	// accepting connections. It gives load balancers time to notice.
+	ShutdownDrainDelay time.Duration
+	// MaxBodyBytes caps the size of request bodies.
+	MaxBodyBytes int64
+	// RequestTimeout aborts API requests that run longer with a 503; 0
+	// disables it.
+	RequestTimeout time.Duration
+	Compression    CompressionConfig
+	Log            LogConfig
+	// MaintenanceMode starts the server in maintenance mode, answering
+	// everything but health checks, login and the admin API with a 503 that
+	// asks clients to retry after MaintenanceRetryAfter.
+	MaintenanceMode       bool
+	MaintenanceRetryAfter time.Duration
+	// EnablePprof serves the net/http/pprof handlers under /debug/pprof/
+	// to admins authenticating with an API key.
+	EnablePprof bool
+
+	// StoreBackend selects where sessions (and, for "postgres", tasks) are
+	// kept: "memory", "sqlite", "redis" or "postgres".
+	StoreBackend  string
+	SessionDBPath string
+	RedisAddr     string
+	RedisPassword string
+	RedisDB       int
+	// StoreRetry is how boot retries connecting to Redis or PostgreSQL,
+	// which may still be starting up.
+	StoreRetry RetryConfig
+	// MemoryMaxSessions and MemoryMaxTasks cap the in-memory session store
+	// and task repository, evicting the least recently used sessions and the
+	// oldest tasks beyond them; 0 means no limit.
+	MemoryMaxSessions int
+	MemoryMaxTasks    int
+
+	DatabaseURL       string
+	DBMaxOpenConns    int
+	DBMaxIdleConns    int
+	DBConnMaxLifetime time.Duration
+	// MigrateOnStart applies pending SQL migrations at boot. When off, boot
+	// fails if any are pending.
+	MigrateOnStart bool
+	// StartupSelfCheck makes boot fail unless a record can be written to,
+	// read from and deleted from each store.
+	StartupSelfCheck bool
+
+	Session SessionConfig
+
+	PasswordPolicy PasswordPolicy
+	UserCache      UserCacheConfig
+	// AdminUsername and AdminPassword seed an admin account on startup when
+	// no users exist yet.
+	AdminUsername string
+	AdminPassword string
+	// GoogleOAuth enables signing in with Google.
+	GoogleOAuth GoogleOAuthConfig
+
+	// TaskQuota is how many tasks, trash included, a user may own unless an
+	// admin overrides it for them; 0 means no limit.
+	TaskQuota int
+	// BulkMaxTasks is the largest batch accepted by POST /tasks/bulk and
+	// POST /tasks/batch/done.
+	BulkMaxTasks int
+	// CalendarMaxDays is the longest range GET /tasks/calendar accepts.
+	CalendarMaxDays int
+	// TrashRetention is how long deleted tasks stay in the trash before they
+	// are purged.
+	TrashRetention time.Duration
+	// HistorySize is how many change log entries are kept per task.
+	HistorySize int
+	// TimeFormat is how task timestamps are written: "rfc3339nano",
+	// "rfc3339" (whole seconds) or "unix_ms" (milliseconds since the epoch,
+	// as a number).
+	TimeFormat string
+	// CursorSecret signs the pagination cursors of GET /tasks. If empty a
+	// random key is used, which differs per process.
+	CursorSecret string
+	// AuditLogFile, if set, is a file audit entries are appended to as JSON
+	// lines, in addition to the in-memory log behind GET /admin/audit.
+	AuditLogFile string
+	Reminders    ReminderConfig
+	Attachments  AttachmentConfig
+	Webhooks     WebhookConfig
+
+	CORSAllowedOrigins []string
+	SecurityHeaders    SecurityHeadersConfig
+
+	// TrustedProxies are the proxies whose X-Forwarded-For and X-Real-IP
+	// headers are believed when resolving the client IP.
+	TrustedProxies []netip.Prefix
+	RateLimit      RateLimitConfig
+	LoginRateLimit RateLimitConfig
+	LoginLockout   LockoutConfig
+}
+
+// SessionConfig holds the session lifetime and cookie attributes.
+type SessionConfig struct {
+	Lifetime       time.Duration
+	IdleTimeout    time.Duration
+	CookieName     string
+	CookieSecure   bool
+	CookieHTTPOnly bool
+	CookieSameSite http.SameSite
+	// CookieDomain, if set, shares the cookie with every subdomain of it,
+	// such as the admin panel on admin.example.com for example.com; empty
+	// keeps it to the host that set it. Leading dots are dropped.
+	CookieDomain string
+	// CookiePath limits the cookie to requests under it.
+	CookiePath string
+	// StoreFailureMode is what happens to a request whose session can't be
+	// loaded from the store: "strict" fails it, "degrade" serves it
+	// unauthenticated.
+	StoreFailureMode string
+}
+
+// RateLimitConfig is a token-bucket rate and burst per client IP.
+type RateLimitConfig struct {
+	RPS   float64
+	Burst int
+}
+
+// LoadConfig reads the configuration from the environment. Unset variables
+// get secure defaults; invalid values are reported as errors rather than
+// silently ignored.
+func LoadConfig() (*Config, error) {
+	cfg := &Config{
+		Port:          envString("PORT", "8080"),
+		StoreBackend:  envString("STORE_BACKEND", "memory"),
+		SessionDBPath: envString("SESSION_DB_PATH", "sessions.db"),
+		RedisAddr:     envString("REDIS_ADDR", "localhost:6379"),
+		RedisPassword: os.Getenv("REDIS_PASSWORD"),
+		DatabaseURL:   os.Getenv("DATABASE_URL"),
+	}
+	cfg.Session.CookieName = envString("SESSION_COOKIE_NAME", "session")
+	cfg.Session.CookieDomain = strings.ToLower(strings.TrimLeft(os.Getenv("SESSION_COOKIE_DOMAIN"), "."))
+	cfg.Session.CookiePath = envString("SESSION_COOKIE_PATH", "/")
+	cfg.TimeFormat = envString("TIME_FORMAT", timeFormatRFC3339Nano)
+	cfg.Session.StoreFailureMode = envString("SESSION_STORE_FAILURE_MODE", sessionFailStrict)
+
+	// Collect every error so that one boot reports all problems at once.
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	var err error
+	cfg.ShutdownTimeout, err = envDuration("SHUTDOWN_TIMEOUT", 10*time.Second)
+	check(err)
+	cfg.ShutdownDrainDelay, err = envDuration("SHUTDOWN_DRAIN_DELAY", 5*time.Second)
+	check(err)
+	cfg.RedisDB, err = envInt("REDIS_DB", 0)
+	check(err)
+	cfg.DBMaxOpenConns, err = envInt("DB_MAX_OPEN_CONNS", 25)
+	check(err)
+	cfg.DBMaxIdleConns, err = envInt("DB_MAX_IDLE_CONNS", 25)
+	check(err)
+	cfg.DBConnMaxLifetime, err = envDuration("DB_CONN_MAX_LIFETIME", 5*time.Minute)
+	check(err)
+	cfg.MemoryMaxSessions, err = envInt("MEMORY_MAX_SESSIONS", 0)
+	check(err)
+	cfg.MemoryMaxTasks, err = envInt("MEMORY_MAX_TASKS", 0)
+	check(err)
+	cfg.StoreRetry.MaxAttempts, err = envInt("STORE_CONNECT_ATTEMPTS", 5)
+	check(err)
+	cfg.StoreRetry.BaseDelay, err = envDuration("STORE_CONNECT_BASE_DELAY", 500*time.Millisecond)
+	check(err)
+	cfg.MigrateOnStart, err = envBool("MIGRATE_ON_START", true)
+	check(err)
+	cfg.StartupSelfCheck, err = envBool("STARTUP_SELFCHECK", true)
+	check(err)
+	maxBody, err := envInt("MAX_BODY_BYTES", 1<<20)
+	check(err)
+	cfg.MaxBodyBytes = int64(maxBody)
+	cfg.RequestTimeout, err = envDuration("REQUEST_TIMEOUT", 30*time.Second)
+	check(err)
+	cfg.MaintenanceMode, err = envBool("MAINTENANCE_MODE", false)
+	check(err)
+	cfg.MaintenanceRetryAfter, err = envDuration("MAINTENANCE_RETRY_AFTER", 2*time.Minute)
+	check(err)
+	cfg.EnablePprof, err = envBool("ENABLE_PPROF", false)
+	check(err)
+	cfg.Compression.MinBytes, err = envInt("COMPRESS_MIN_BYTES", 1024)
+	check(err)
+	cfg.Compression.Level, err = envInt("COMPRESS_LEVEL", 6)
+	check(err)
+	cfg.Log.Bodies, err = envBool("LOG_BODIES", false)
+	check(err)
+	cfg.Log.BodyMaxBytes, err = envInt("LOG_BODY_MAX_BYTES", 4096)
+	check(err)
+	cfg.Log.SlowRequestThreshold, err = envDuration("LOG_SLOW_REQUEST_THRESHOLD", 0)
+	check(err)
+	// Configured fields are added to the defaults, which can't be dropped.
+	cfg.Log.RedactFields = parseFieldNames(defaultRedactFields + "," + os.Getenv("LOG_REDACT_FIELDS"))
+
+	cfg.Session.Lifetime, err = envDuration("SESSION_LIFETIME", 24*time.Hour)
+	check(err)
+	cfg.Session.IdleTimeout, err = envDuration("SESSION_IDLE_TIMEOUT", 0)
+	check(err)
+	cfg.Session.CookieSecure, err = envBool("SESSION_COOKIE_SECURE", true)
+	check(err)
+	cfg.Session.CookieHTTPOnly, err = envBool("SESSION_COOKIE_HTTP_ONLY", true)
+	check(err)
+	cfg.Session.CookieSameSite, err = envSameSite("SESSION_COOKIE_SAMESITE", http.SameSiteLaxMode)
+	check(err)
+
+	cfg.PasswordPolicy.MinLength, err = envInt("PASSWORD_MIN_LENGTH", 8)
+	check(err)
+	cfg.PasswordPolicy.RequireMixedCase, err = envBool("PASSWORD_REQUIRE_MIXED_CASE", false)
+	check(err)
+	cfg.PasswordPolicy.RequireDigit, err = envBool("PASSWORD_REQUIRE_DIGIT", false)
+	check(err)
+	cfg.PasswordPolicy.RequireSymbol, err = envBool("PASSWORD_REQUIRE_SYMBOL", false)
+	check(err)
+	cfg.PasswordPolicy.RejectCommon, err = envBool("PASSWORD_REJECT_COMMON", true)
+	check(err)
+	cfg.UserCache.Size, err = envInt("USER_CACHE_SIZE", 1000)
+	check(err)
+	cfg.UserCache.TTL, err = envDuration("USER_CACHE_TTL", 30*time.Second)
+	check(err)
+	cfg.AdminUsername = normalizeUsername(os.Getenv("ADMIN_USERNAME"))
+	cfg.AdminPassword = os.Getenv("ADMIN_PASSWORD")
+	cfg.GoogleOAuth = GoogleOAuthConfig{
+		ClientID:     os.Getenv("GOOGLE_CLIENT_ID"),
+		ClientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
+	}
+
+	cfg.TaskQuota, err = envInt("TASK_QUOTA", 10000)
+	check(err)
+	cfg.BulkMaxTasks, err = envInt("BULK_MAX_TASKS", 100)
+	check(err)
+	cfg.CalendarMaxDays, err = envInt("CALENDAR_MAX_DAYS", 90)
+	check(err)
+	cfg.TrashRetention, err = envDuration("TRASH_RETENTION", 30*24*time.Hour)
+	check(err)
+	cfg.HistorySize, err = envInt("HISTORY_SIZE", 100)
+	check(err)
+	cfg.CursorSecret = os.Getenv("CURSOR_SECRET")
+
+	cfg.AuditLogFile = os.Getenv("AUDIT_LOG_FILE")
+	cfg.Reminders.Notifier = envString("NOTIFIER", "log")
+	cfg.Reminders.LeadTime, err = envDuration("REMINDER_LEAD_TIME", time.Hour)
+	check(err)
+	cfg.Reminders.Interval, err = envDuration("REMINDER_INTERVAL", time.Minute)
+	check(err)
+	cfg.Reminders.SMTP = SMTPConfig{
+		Addr:     os.Getenv("SMTP_ADDR"),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("SMTP_FROM"),
+	}
+	cfg.Webhooks.MaxAttempts, err = envInt("WEBHOOK_MAX_ATTEMPTS", 5)
+	check(err)
+	cfg.Webhooks.BaseDelay, err = envDuration("WEBHOOK_RETRY_BASE_DELAY", 10*time.Second)
+	check(err)
+	cfg.Webhooks.Timeout, err = envDuration("WEBHOOK_TIMEOUT", 10*time.Second)
+	check(err)
+	cfg.Webhooks.AllowPrivateTargets, err = envBool("WEBHOOK_ALLOW_PRIVATE_TARGETS", false)
+	check(err)
+	cfg.Attachments.Dir = envString("ATTACHMENT_DIR", "attachments")
+	maxAttachment, err := envInt("ATTACHMENT_MAX_BYTES", 10<<20)
+	check(err)
+	cfg.Attachments.MaxBytes = int64(maxAttachment)
+	cfg.Attachments.AllowedTypes, err = parseContentTypes(envString("ATTACHMENT_TYPES", defaultAttachmentTypes))
+	check(err)
+
+	cfg.CORSAllowedOrigins, err = parseAllowedOrigins(os.Getenv("CORS_ALLOWED_ORIGINS"))
+	check(err)
+	cfg.SecurityHeaders.ContentSecurityPolicy = envString("CONTENT_SECURITY_POLICY", defaultContentSecurityPolicy)
+	cfg.SecurityHeaders.ReferrerPolicy = envString("REFERRER_POLICY", "no-referrer")
+	check(validReferrerPolicy(cfg.SecurityHeaders.ReferrerPolicy))
+	cfg.SecurityHeaders.BehindTLS, err = envBool("BEHIND_TLS", false)
+	check(err)
+
+	if _, ok := os.LookupEnv("TRUST_PROXY"); ok {
+		check(errors.New("TRUST_PROXY has been replaced by TRUSTED_PROXIES, a list of proxy CIDR ranges"))
+	}
+	cfg.TrustedProxies, err = parseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	check(err)
+	cfg.RateLimit, err = envRateLimit("RATE_LIMIT", 10, 20)
+	check(err)
+	// Login gets a much stricter limit to slow down password guessing.
+	cfg.LoginRateLimit, err = envRateLimit("LOGIN_RATE_LIMIT", 0.2, 5)
+	check(err)
+	cfg.LoginLockout.Threshold, err = envInt("LOGIN_LOCKOUT_THRESHOLD", 5)
+	check(err)
+	cfg.LoginLockout.Window, err = envDuration("LOGIN_LOCKOUT_WINDOW", 15*time.Minute)
+	check(err)
+	cfg.LoginLockout.Duration, err = envDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+	check(err)
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) validate() error {
+	var errs []error
+	switch cfg.StoreBackend {
+	case "memory", "sqlite", "redis":
+	case "postgres":
+		if cfg.DatabaseURL == "" {
+			errs = append(errs, fmt.Errorf("DATABASE_URL is required when STORE_BACKEND=postgres"))
+		}
+		if cfg.DBMaxOpenConns < 0 || cfg.DBMaxIdleConns < 0 || cfg.DBConnMaxLifetime < 0 {
+			errs = append(errs, fmt.Errorf("DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS and DB_CONN_MAX_LIFETIME must not be negative"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown STORE_BACKEND %q (expected \"memory\", \"sqlite\", \"redis\" or \"postgres\")", cfg.StoreBackend))
+	}
+	switch cfg.TimeFormat {
+	case timeFormatRFC3339, timeFormatRFC3339Nano, timeFormatUnixMillis:
+	default:
+		errs = append(errs, fmt.Errorf("unknown TIME_FORMAT %q (expected \"rfc3339\", \"rfc3339nano\" or \"unix_ms\")", cfg.TimeFormat))
+	}
+	switch cfg.Session.StoreFailureMode {
+	case sessionFailStrict, sessionFailDegrade:
+	default:
+		errs = append(errs, fmt.Errorf("unknown SESSION_STORE_FAILURE_MODE %q (expected \"strict\" or \"degrade\")", cfg.Session.StoreFailureMode))
+	}
+	if cfg.StoreRetry.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("STORE_CONNECT_ATTEMPTS must be at least 1, got %d", cfg.StoreRetry.MaxAttempts))
+	}
+	if cfg.StoreRetry.BaseDelay <= 0 {
+		errs = append(errs, fmt.Errorf("STORE_CONNECT_BASE_DELAY must be positive, got %s", cfg.StoreRetry.BaseDelay))
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_TIMEOUT must be positive, got %s", cfg.ShutdownTimeout))
+	}
+	if cfg.ShutdownDrainDelay < 0 {
+		errs = append(errs, fmt.Errorf("SHUTDOWN_DRAIN_DELAY must not be negative, got %s", cfg.ShutdownDrainDelay))
+	}
+	if cfg.MaxBodyBytes <= 0 {
+		errs = append(errs, fmt.Errorf("MAX_BODY_BYTES must be positive, got %d", cfg.MaxBodyBytes))
+	}
+	if cfg.RequestTimeout < 0 {
+		errs = append(errs, fmt.Errorf("REQUEST_TIMEOUT must not be negative, got %s", cfg.RequestTimeout))
+	}
+	if cfg.MaintenanceRetryAfter < time.Second {
+		errs = append(errs, fmt.Errorf("MAINTENANCE_RETRY_AFTER must be at least 1s, got %s", cfg.MaintenanceRetryAfter))
+	}
+	if cfg.Compression.MinBytes < 0 {
+		errs = append(errs, fmt.Errorf("COMPRESS_MIN_BYTES must not be negative, got %d", cfg.Compression.MinBytes))
+	}
+	if cfg.Compression.Level < 0 || cfg.Compression.Level > 9 {
+		errs = append(errs, fmt.Errorf("COMPRESS_LEVEL must be between 0 (off) and 9, got %d", cfg.Compression.Level))
+	}
+	if cfg.Session.Lifetime <= 0 {
+		errs = append(errs, fmt.Errorf("SESSION_LIFETIME must be positive, got %s", cfg.Session.Lifetime))
+	}
+	if cfg.Session.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("SESSION_IDLE_TIMEOUT must not be negative, got %s", cfg.Session.IdleTimeout))
+	}
+	if cfg.PasswordPolicy.MinLength < 1 {
+		errs = append(errs, fmt.Errorf("PASSWORD_MIN_LENGTH must be at least 1, got %d", cfg.PasswordPolicy.MinLength))
+	}
+	if cfg.MemoryMaxSessions < 0 {
+		errs = append(errs, fmt.Errorf("MEMORY_MAX_SESSIONS must not be negative, got %d", cfg.MemoryMaxSessions))
+	}
+	if cfg.MemoryMaxTasks < 0 {
+		errs = append(errs, fmt.Errorf("MEMORY_MAX_TASKS must not be negative, got %d", cfg.MemoryMaxTasks))
+	}
+	if cfg.UserCache.Size < 0 {
+		errs = append(errs, fmt.Errorf("USER_CACHE_SIZE must not be negative, got %d", cfg.UserCache.Size))
+	}
+	if cfg.UserCache.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("USER_CACHE_TTL must be positive, got %s", cfg.UserCache.TTL))
+	}
+	if g := cfg.GoogleOAuth; g.ClientID != "" || g.ClientSecret != "" || g.RedirectURL != "" {
+		if g.ClientID == "" || g.ClientSecret == "" || g.RedirectURL == "" {
+			errs = append(errs, fmt.Errorf("GOOGLE_CLIENT_ID, GOOGLE_CLIENT_SECRET and GOOGLE_REDIRECT_URL must be set together"))
+		} else if u, err := url.Parse(g.RedirectURL); err != nil || !u.IsAbs() {
+			errs = append(errs, fmt.Errorf("GOOGLE_REDIRECT_URL must be an absolute URL, got %q", g.RedirectURL))
+		}
+	}
+	if (cfg.AdminUsername == "") != (cfg.AdminPassword == "") {
+		errs = append(errs, fmt.Errorf("ADMIN_USERNAME and ADMIN_PASSWORD must be set together"))
+	} else if cfg.AdminPassword != "" {
+		violations := cfg.PasswordPolicy.Check(cfg.AdminPassword)
+		rules := make([]string, 0, len(violations))
+		for rule := range violations {
+			rules = append(rules, rule)
+		}
+		sort.Strings(rules)
+		for _, rule := range rules {
+			errs = append(errs, fmt.Errorf("ADMIN_PASSWORD %s (%s)", violations[rule], rule))
+		}
+	}
+	if cfg.Log.BodyMaxBytes < 1 {
+		errs = append(errs, fmt.Errorf("LOG_BODY_MAX_BYTES must be at least 1, got %d", cfg.Log.BodyMaxBytes))
+	}
+	if cfg.Log.SlowRequestThreshold < 0 {
+		errs = append(errs, fmt.Errorf("LOG_SLOW_REQUEST_THRESHOLD must not be negative, got %s", cfg.Log.SlowRequestThreshold))
+	}
+	if cfg.TaskQuota < 0 {
+		errs = append(errs, fmt.Errorf("TASK_QUOTA must be non-negative, got %d", cfg.TaskQuota))
+	}
+	if cfg.BulkMaxTasks < 1 {
+		errs = append(errs, fmt.Errorf("BULK_MAX_TASKS must be at least 1, got %d", cfg.BulkMaxTasks))
+	}
+	if cfg.CalendarMaxDays < 1 {
+		errs = append(errs, fmt.Errorf("CALENDAR_MAX_DAYS must be at least 1, got %d", cfg.CalendarMaxDays))
+	}
+	if cfg.TrashRetention <= 0 {
+		errs = append(errs, fmt.Errorf("TRASH_RETENTION must be positive, got %s", cfg.TrashRetention))
+	}
+	if cfg.HistorySize < 1 {
+		errs = append(errs, fmt.Errorf("HISTORY_SIZE must be at least 1, got %d", cfg.HistorySize))
+	}
+	if cfg.CursorSecret != "" && len(cfg.CursorSecret) < 32 {
+		errs = append(errs, fmt.Errorf("CURSOR_SECRET must be at least 32 bytes, got %d", len(cfg.CursorSecret)))
+	}
+	switch cfg.Reminders.Notifier {
+	case "none", "log":
+	case "smtp":
+		if _, _, err := net.SplitHostPort(cfg.Reminders.SMTP.Addr); err != nil {
+			errs = append(errs, fmt.Errorf("SMTP_ADDR must be host:port when NOTIFIER=smtp, got %q", cfg.Reminders.SMTP.Addr))
+		}
+		if _, err := mail.ParseAddress(cfg.Reminders.SMTP.From); err != nil {
+			errs = append(errs, fmt.Errorf("SMTP_FROM must be an email address when NOTIFIER=smtp, got %q", cfg.Reminders.SMTP.From))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("unknown NOTIFIER %q (expected \"log\", \"smtp\" or \"none\")", cfg.Reminders.Notifier))
+	}
+	if cfg.Reminders.LeadTime <= 0 || cfg.Reminders.Interval <= 0 {
+		errs = append(errs, fmt.Errorf("REMINDER_LEAD_TIME and REMINDER_INTERVAL must be positive"))
+	}
+	if cfg.Webhooks.MaxAttempts < 1 {
+		errs = append(errs, fmt.Errorf("WEBHOOK_MAX_ATTEMPTS must be at least 1, got %d", cfg.Webhooks.MaxAttempts))
+	}
+	if cfg.Webhooks.BaseDelay <= 0 || cfg.Webhooks.Timeout <= 0 {
+		errs = append(errs, fmt.Errorf("WEBHOOK_RETRY_BASE_DELAY and WEBHOOK_TIMEOUT must be positive"))
+	}
+	if cfg.Attachments.MaxBytes <= 0 {
+		errs = append(errs, fmt.Errorf("ATTACHMENT_MAX_BYTES must be positive, got %d", cfg.Attachments.MaxBytes))
+	}
+	if len(cfg.Attachments.AllowedTypes) == 0 {
+		errs = append(errs, fmt.Errorf("ATTACHMENT_TYPES must list at least one media type"))
+	}
+	if cfg.LoginLockout.Threshold < 1 {
+		errs = append(errs, fmt.Errorf("LOGIN_LOCKOUT_THRESHOLD must be at least 1, got %d", cfg.LoginLockout.Threshold))
+	}
+	if cfg.LoginLockout.Window <= 0 || cfg.LoginLockout.Duration <= 0 {
+		errs = append(errs, fmt.Errorf("LOGIN_LOCKOUT_WINDOW and LOGIN_LOCKOUT_DURATION must be positive"))
+	}
+	if cfg.Session.CookieSameSite == http.SameSiteNoneMode && !cfg.Session.CookieSecure {
+		errs = append(errs, fmt.Errorf("SESSION_COOKIE_SAMESITE=none requires SESSION_COOKIE_SECURE=true"))
+	}
+	if d := cfg.Session.CookieDomain; d != "" {
+		if err := validateCookieDomain(d); err != nil {
+			errs = append(errs, fmt.Errorf("SESSION_COOKIE_DOMAIN %w", err))
+		} else if u, err := url.Parse(cfg.GoogleOAuth.RedirectURL); err == nil && u.Hostname() != "" && !cookieDomainMatches(d, u.Hostname()) {
+			// Sign-in would set a cookie the browser drops.
+			errs = append(errs, fmt.Errorf("SESSION_COOKIE_DOMAIN %q does not cover the host of GOOGLE_REDIRECT_URL, %q", d, u.Hostname()))
+		}
+	}
+	if p := cfg.Session.CookiePath; !strings.HasPrefix(p, "/") || strings.ContainsAny(p, "; \t\r\n") {
+		errs = append(errs, fmt.Errorf("SESSION_COOKIE_PATH must be an absolute path without spaces or semicolons, got %q", p))
+	}
+	return errors.Join(errs...)
+}
+
+// apply copies the session settings onto sm.
+func (c SessionConfig) apply(sm *scs.SessionManager) {
+	sm.Lifetime = c.Lifetime
+	sm.IdleTimeout = c.IdleTimeout
+	sm.Cookie.Name = c.CookieName
+	sm.Cookie.Secure = c.CookieSecure
+	sm.Cookie.HttpOnly = c.CookieHTTPOnly
+	sm.Cookie.SameSite = c.CookieSameSite
+	sm.Cookie.Domain = c.CookieDomain
+	sm.Cookie.Path = c.CookiePath
+}
+
+// validateCookieDomain checks that a cookie domain is a host name with at
+// least two labels: browsers refuse cookies for a top-level domain, and an
+// IP address or a name with a port or scheme can have no subdomains.
+func validateCookieDomain(d string) error {
+	if net.ParseIP(d) != nil {
+		return fmt.Errorf("must be a domain name, not an IP address, got %q", d)
+	}
+	labels := strings.Split(d, ".")
+	if len(labels) < 2 {
+		return fmt.Errorf("must have at least two labels, such as example.com, got %q", d)
+	}
+	for _, l := range labels {
+		if l == "" || len(l) > 63 || strings.HasPrefix(l, "-") || strings.HasSuffix(l, "-") || strings.Trim(l, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" {
+			return fmt.Errorf("must be a domain name such as example.com, got %q", d)
+		}
+	}
+	return nil
+}
+
+// cookieDomainMatches reports whether a cookie for domain is sent to host:
+// host is domain or one of its subdomains.
+func cookieDomainMatches(domain, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// defaultAttachmentTypes are the files accepted as attachments unless
+// ATTACHMENT_TYPES says otherwise: images, PDFs and plain text, none of which
+// a browser runs as script.
+const defaultAttachmentTypes = "image/png,image/jpeg,image/gif,image/webp,application/pdf,text/plain"
+
+// parseContentTypes parses a comma-separated list of media types, each
+// either exact or a whole type such as "image/*".
+func parseContentTypes(v string) ([]string, error) {
+	var types []string
+	for _, t := range strings.Split(v, ",") {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" {
+			continue
+		}
+		major, minor, ok := strings.Cut(t, "/")
+		if _, _, err := mime.ParseMediaType(t); err != nil || !ok || major == "*" || minor == "" || strings.Contains(t, ";") {
+			return nil, fmt.Errorf("ATTACHMENT_TYPES: %q is not a media type (expected type/subtype or type/*)", t)
+		}
+		types = append(types, t)
+	}
+	return types, nil
+}
+
+// parseFieldNames splits a comma-separated list of field names, dropping
+// empty entries.
+func parseFieldNames(v string) []string {
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// envRateLimit reads <prefix>_RPS and <prefix>_BURST.
+func envRateLimit(prefix string, defRPS float64, defBurst int) (RateLimitConfig, error) {
+	rps, err := envFloat(prefix+"_RPS", defRPS)
+	if err != nil {
+		return RateLimitConfig{}, err
+	}
+	burst, err := envInt(prefix+"_BURST", defBurst)
+	if err != nil {
+		return RateLimitConfig{}, err
+	}
+	if rps <= 0 || burst <= 0 {
+		return RateLimitConfig{}, fmt.Errorf("%s_RPS and %s_BURST must be positive", prefix, prefix)
+	}
+	return RateLimitConfig{RPS: rps, Burst: burst}, nil
+}
+
+func envString(name, def string) string {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v
+	}
+	return def
+}
+
+func envDuration(name string, def time.Duration) (time.Duration, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: %w", name, v, err)
+	}
+	return d, nil
+}
+
+func envBool(name string, def bool) (bool, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: expected true or false", name, v)
+	}
+	return b, nil
+}
+
+func envInt(name string, def int) (int, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: expected an integer", name, v)
+	}
+	return n, nil
+}
+
+func envFloat(name string, def float64) (float64, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q: expected a number", name, v)
+	}
+	return f, nil
+}
+
+func envSameSite(name string, def http.SameSite) (http.SameSite, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok || v == "" {
+		return def, nil
+	}
+	switch strings.ToLower(v) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	default:
+		return 0, fmt.Errorf("invalid %s %q: expected lax, strict or none", name, v)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	corsAllowedMethods = "GET, POST, PUT, PATCH, DELETE"
+	corsAllowedHeaders = "Authorization, Content-Type, X-CSRF-Token, Idempotency-Key, If-Match, If-None-Match, X-Workspace-ID"
+	corsExposedHeaders = "X-Request-ID, Idempotent-Replayed, ETag"
+)
+
+// parseAllowedOrigins parses a comma-separated CORS_ALLOWED_ORIGINS value.
+// Because responses allow credentials, the "*" wildcard is rejected and every
+// entry must be a bare scheme://host[:port] origin.
+func parseAllowedOrigins(v string) ([]string, error) {
+	var origins []string
+	for _, o := range strings.Split(v, ",") {
+		o = strings.TrimSpace(o)
+		if o == "" {
+			continue
+		}
+		if o == "*" {
+			return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS: wildcard \"*\" is not allowed with credentials")
+		}
+		u, err := url.Parse(o)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS: %q is not a valid origin (expected scheme://host[:port])", o)
+		}
+		origins = append(origins, u.Scheme+"://"+u.Host)
+	}
+	return origins, nil
+}
+
+// corsMiddleware adds CORS headers for requests from an allowed origin and
+// answers preflight requests. Origins not in the allowlist get no CORS
+// headers, so the browser blocks the response.
+func corsMiddleware(allowed []string, next http.Handler) http.Handler {
+	allow := make(map[string]bool, len(allowed))
+	for _, o := range allowed {
+		allow[o] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+		if preflight {
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+		}
+
+		if !allow[origin] {
+			if preflight {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+		if preflight {
+			h.Set("Access-Control-Allow-Methods", corsAllowedMethods)
+			h.Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			h.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+)
+
+// csrfSessionKey is the session key holding the per-session CSRF token.
+const csrfSessionKey = "csrfToken"
+
+// csrfHeader is the request header clients echo the token back in.
+const csrfHeader = "X-CSRF-Token"
+
+// csrfProtect rejects state-changing requests whose X-CSRF-Token header does
+// not match the token stored in the session. Safe methods pass through, as
+// do requests with an Authorization header: browsers never attach one on
+// their own, and requireAuth then ignores the session cookie. It must run
+// inside sessionManager.LoadAndSave.
+func csrfProtect(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if _, ok := bearerToken(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		want := sessionManager.GetString(r.Context(), csrfSessionKey)
+		got := r.Header.Get(csrfHeader)
+		if want == "" || subtle.ConstantTimeCompare([]byte(want), []byte(got)) != 1 {
+			writeError(w, http.StatusForbidden, CodeCSRFFailed, "missing or invalid CSRF token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// csrfTokenHandler returns the session's CSRF token, creating one if needed.
+// It is reachable without logging in so clients can fetch a token before
+// calling POST /login.
+func csrfTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := sessionManager.GetString(r.Context(), csrfSessionKey)
+	if token == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			serverError(w, err)
+			return
+		}
+		token = base64.RawURLEncoding.EncodeToString(b)
+		sessionManager.Put(r.Context(), csrfSessionKey, token)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	encode(w, r, http.StatusOK, map[string]string{"csrf_token": token})
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Response formats supported by GET /tasks.
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// negotiateFormat picks the response format for r. An explicit ?format=
+// wins; otherwise the Accept header is honoured, preferring JSON on ties and
+// when nothing we support is listed.
+func negotiateFormat(r *http.Request) (string, error) {
+	switch f := r.URL.Query().Get("format"); f {
+	case "":
+	case formatJSON, formatCSV:
+		return f, nil
+	default:
+		return "", fmt.Errorf("format must be json or csv")
+	}
+
+	best, bestQ := formatJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		var format string
+		switch mediaType {
+		case "text/csv":
+			format = formatCSV
+		case "application/json", "application/*", "*/*":
+			format = formatJSON
+		default:
+			continue
+		}
+		if q > bestQ || (q == bestQ && format == formatJSON) {
+			best, bestQ = format, q
+		}
+	}
+	return best, nil
+}
+
+var taskCSVHeader = []string{"id", "title", "description", "done", "priority", "tags", "due_date", "created_at", "completed_at"}
+
+// writeTasksCSV streams tasks as a CSV attachment with a header row. Tags are
+// joined with ";" and timestamps use RFC 3339.
+func writeTasksCSV(w http.ResponseWriter, tasks []Task) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="tasks.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(taskCSVHeader); err != nil {
+		return err
+	}
+	for _, t := range tasks {
+		err := cw.Write([]string{
+			t.ID,
+			csvSafe(t.Title),
+			csvSafe(t.Description),
+			strconv.FormatBool(t.Done),
+			t.Priority,
+			csvSafe(strings.Join(t.Tags, ";")),
+			csvTime(t.DueDate),
+			t.CreatedAt.Format(time.RFC3339),
+			csvTime(t.CompletedAt),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// csvSafe prefixes user-supplied cells that a spreadsheet would evaluate as a
+// formula with a single quote.
+func csvSafe(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+func csvTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
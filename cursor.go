@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// ListCursor is the position after which a cursor-paginated list resumes:
+// the creation time and ID of the last task of the previous page.
+type ListCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+// after reports whether t comes after c in the given created_at order.
+func (c ListCursor) after(t Task, desc bool) bool {
+	if desc {
+		return t.CreatedAt.Before(c.CreatedAt) || (t.CreatedAt.Equal(c.CreatedAt) && t.ID < c.ID)
+	}
+	return t.CreatedAt.After(c.CreatedAt) || (t.CreatedAt.Equal(c.CreatedAt) && t.ID > c.ID)
+}
+
+// cursorKey signs the cursors handed out by GET /tasks. Unless CURSOR_SECRET
+// sets it, it is random per process, so cursors don't survive a restart and
+// instances behind a load balancer must share a secret.
+var cursorKey = randomCursorKey()
+
+func randomCursorKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// errInvalidCursor is returned for cursors that weren't issued by this
+// server, were altered, or belong to another sort order.
+var errInvalidCursor = errors.New("invalid cursor")
+
+// cursorPayload is what a cursor token carries, before signing.
+type cursorPayload struct {
+	CreatedAt time.Time `json:"t"`
+	ID        string    `json:"id"`
+	Sort      string    `json:"s"`
+}
+
+// encodeCursor returns the opaque token resuming a list sorted by sort after
+// c: the JSON payload followed by its HMAC-SHA256, base64url-encoded.
+func encodeCursor(c ListCursor, sort string) string {
+	payload, _ := json.Marshal(cursorPayload{CreatedAt: c.CreatedAt, ID: c.ID, Sort: sort})
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(payload))
+}
+
+// decodeCursor verifies a token made by encodeCursor for the same sort
+// order and returns its position.
+func decodeCursor(token, sort string) (ListCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(raw) <= sha256.Size {
+		return ListCursor{}, errInvalidCursor
+	}
+	payload, sum := raw[:len(raw)-sha256.Size], raw[len(raw)-sha256.Size:]
+	mac := hmac.New(sha256.New, cursorKey)
+	mac.Write(payload)
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return ListCursor{}, errInvalidCursor
+	}
+	var p cursorPayload
+	if err := json.Unmarshal(payload, &p); err != nil || p.Sort != sort || p.ID == "" {
+		return ListCursor{}, errInvalidCursor
+	}
+	return ListCursor{CreatedAt: p.CreatedAt, ID: p.ID}, nil
+}
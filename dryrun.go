@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// dryRunResult is what the bulk endpoints answer when called with
+// ?dry_run=true: everything is validated as for the real operation, but
+// nothing is stored and no events are published. Each endpoint adds the
+// fields of its real result.
+type dryRunResult struct {
+	DryRun bool `json:"dry_run"`
+	// WouldSucceed reports whether the real operation would go through.
+	// Bulk operations are all-or-nothing, so any rejection fails them.
+	WouldSucceed bool `json:"would_succeed"`
+	// Rejected lists every invalid item, each with its index, in the shape
+	// the real operation reports the first one in.
+	Rejected []apiError `json:"rejected"`
+	// Error is why the operation as a whole would fail even though every
+	// item is valid, such as the task quota being exceeded.
+	Error *apiError `json:"error,omitempty"`
+}
+
+// newDryRunResult returns the result for the given rejections and
+// operation-level error.
+func newDryRunResult(rejected []apiError, err *apiError) dryRunResult {
+	if rejected == nil {
+		rejected = []apiError{}
+	}
+	return dryRunResult{DryRun: true, WouldSucceed: len(rejected) == 0 && err == nil, Rejected: rejected, Error: err}
+}
+
+// parseDryRun reads the dry_run query parameter.
+func parseDryRun(q url.Values) (bool, error) {
+	v := q.Get("dry_run")
+	if v == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, errors.New("dry_run must be true or false")
+	}
+	return b, nil
+}
+
+// validatable is an item of a bulk request body.
+type validatable interface {
+	Validate() error
+}
+
+// buildTasks validates each item and converts it with convert. It returns
+// the tasks of the valid items and the errors of the others, stopping at the
+// first one unless all is set.
+func buildTasks[T validatable](items []T, all bool, convert func(T) (Task, error)) ([]Task, []apiError) {
+	tasks := make([]Task, 0, len(items))
+	var rejected []apiError
+	for i, item := range items {
+		if err := item.Validate(); err != nil {
+			rejected = append(rejected, validationAPIError(err, i))
+		} else if t, err := convert(item); err != nil {
+			rejected = append(rejected, apiError{Code: CodeBadRequest, Message: fmt.Sprintf("task %d: %v", i, err), Index: &i})
+		} else {
+			tasks = append(tasks, t)
+			continue
+		}
+		if !all {
+			break
+		}
+	}
+	return tasks, rejected
+}
+
+// writeRejection answers a bulk request with the error of an invalid item:
+// a 422 for validation failures and a 400 otherwise.
+func writeRejection(w http.ResponseWriter, e apiError) {
+	status := http.StatusBadRequest
+	if e.Code == CodeValidationFailed {
+		status = http.StatusUnprocessableEntity
+	}
+	writeAPIError(w, status, e)
+}
+
+// previewQuota returns the error creating n tasks for u would fail with
+// because of their task quota, or nil. replaced tasks are removed first and
+// don't count. Unlike the real check it isn't atomic with anything, so a
+// concurrent create can still change the outcome.
+func previewQuota(ctx context.Context, u User, n, replaced int) (*apiError, error) {
+	quota := taskQuota(u)
+	if quota <= 0 {
+		return nil, nil
+	}
+	owned, err := ownedTaskCount(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if owned-replaced+n > quota {
+		return &apiError{Code: CodeConflict, Message: quotaError(quota).Error()}, nil
+	}
+	return nil, nil
+}
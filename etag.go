@@ -0,0 +1,58 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// taskETag returns a strong entity tag for t. It hashes the task's full JSON
+// representation, so any change to a field (including its timestamps)
+// produces a new tag. The timestamps are hashed at full precision in UTC
+// whatever TIME_FORMAT and X-Timezone say, so that the tag names the task's
+// state rather than how it is presented.
+func taskETag(t Task) string {
+	b, err := t.in(time.UTC).marshalJSON(timeFormatRFC3339Nano)
+	if err != nil {
+		// Task always marshals; this only guards against future field types.
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches reports whether etag is listed in an If-Match or If-None-Match
+// header value. "*" matches any current representation. Weak tags are
+// compared by their opaque value, which is right for If-None-Match. For
+// If-Match it accepts the weakened tags of compressed responses, which
+// still name the same task state.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch enforces an If-Match precondition against the current state of
+// task. If it returns false a 412 has already been written.
+func checkIfMatch(w http.ResponseWriter, r *http.Request, task Task) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || etagMatches(ifMatch, taskETag(task)) {
+		return true
+	}
+	writeError(w, http.StatusPreconditionFailed, CodePreconditionFailed, "task has been modified since it was fetched")
+	return false
+}
+
+// writeTask writes task in the encoding r asks for along with its ETag.
+func writeTask(w http.ResponseWriter, r *http.Request, status int, task Task) {
+	if etag := taskETag(task); etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	encode(w, r, status, task)
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Task event types sent to subscribers.
+const (
+	EventTaskCreated  = "task.created"
+	EventTaskUpdated  = "task.updated"
+	EventTaskDeleted  = "task.deleted"
+	EventTaskRestored = "task.restored"
+	EventTaskAssigned = "task.assigned"
+	// EventTaskCommented and EventCommentDeleted carry the comment as well
+	// as the task.
+	EventTaskCommented  = "task.commented"
+	EventCommentDeleted = "task.comment_deleted"
+)
+
+// TaskEvent describes a change to one of a user's tasks.
+type TaskEvent struct {
+	Type    string   `json:"type"`
+	Task    Task     `json:"task"`
+	Comment *Comment `json:"comment,omitempty"`
+}
+
+// eventBufferSize is how many events a subscriber may fall behind before it
+// is disconnected.
+const eventBufferSize = 32
+
+// sseKeepAlive is how often an idle event stream gets a comment line so that
+// proxies don't time it out.
+const sseKeepAlive = 15 * time.Second
+
+// Hub fans task events out to the subscribers of each user. It knows
+// nothing of the transports the events are streamed over. Publishing never
+// blocks: a subscriber whose buffer is full is dropped and its channel
+// closed, and the client is expected to reconnect and refetch.
+type Hub struct {
+	mu        sync.Mutex
+	subs      map[int]map[*subscriber]struct{}
+	observers []func(userID int, ev TaskEvent)
+	closed    bool
+}
+
+// subscriber receives the events of one user until it unsubscribes or is
+// dropped, at which point ch is closed.
+type subscriber struct {
+	userID int
+	ch     chan TaskEvent
+}
+
+func NewHub() *Hub {
+	return &Hub{subs: make(map[int]map[*subscriber]struct{})}
+}
+
+// Subscribe registers a subscriber for the events of userID. It returns the
+// channel they arrive on and a function that unsubscribes and closes it,
+// which may be called any number of times. Once the hub is closed the
+// channel is returned closed.
+func (h *Hub) Subscribe(userID int) (<-chan TaskEvent, func()) {
+	sub := &subscriber{userID: userID, ch: make(chan TaskEvent, eventBufferSize)}
+	unsubscribe := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(sub)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		close(sub.ch)
+		return sub.ch, unsubscribe
+	}
+	if h.subs[userID] == nil {
+		h.subs[userID] = make(map[*subscriber]struct{})
+	}
+	h.subs[userID][sub] = struct{}{}
+	return sub.ch, unsubscribe
+}
+
+// remove deletes sub and closes its channel if it is still registered. The
+// caller must hold h.mu.
+func (h *Hub) remove(sub *subscriber) {
+	subs := h.subs[sub.userID]
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.subs, sub.userID)
+	}
+	close(sub.ch)
+}
+
+// Observe registers fn to be called with every event published, for
+// whichever user, such as to forward events to webhooks. fn runs on the
+// publishing goroutine, outside the hub's lock, and must not block.
+func (h *Hub) Observe(fn func(userID int, ev TaskEvent)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.observers = append(h.observers, fn)
+}
+
+// Publish delivers ev to every subscriber of userID, then passes it to the
+// observers.
+func (h *Hub) Publish(userID int, ev TaskEvent) {
+	h.mu.Lock()
+	for sub := range h.subs[userID] {
+		if !h.deliver(sub, ev) {
+			h.remove(sub)
+		}
+	}
+	observers := h.observers
+	h.mu.Unlock()
+	for _, fn := range observers {
+		fn(userID, ev)
+	}
+}
+
+// deliver hands ev to sub without blocking and reports whether it was
+// taken. A panic is logged and reported as a failed delivery, so that one
+// broken subscriber is dropped rather than taking the others, and the
+// publishing request, down with it.
+func (h *Hub) deliver(sub *subscriber, ev TaskEvent) (ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			slog.Error("delivering task event panicked", "user_id", sub.userID, "type", ev.Type, "panic", p)
+			ok = false
+		}
+	}()
+	select {
+	case sub.ch <- ev:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close disconnects every subscriber and rejects new ones. It is registered
+// with http.Server.RegisterOnShutdown so that open streams don't hold up a
+// graceful shutdown.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for _, subs := range h.subs {
+		for sub := range subs {
+			h.remove(sub)
+		}
+	}
+}
+
+// EventTaskRepo is a TaskRepository that publishes an event to hub after
+// every successful change to a single task, so that handlers and workers
+// can't forget to. Bulk removals (DeleteByOwner, DeleteByWorkspace and
+// PurgeDeleted) and the bookkeeping of Unassign and MarkReminded publish
+// nothing.
+type EventTaskRepo struct {
+	TaskRepository
+	hub *Hub
+}
+
+// NewEventTaskRepo wraps repo so that its changes are published to hub.
+func NewEventTaskRepo(repo TaskRepository, hub *Hub) *EventTaskRepo {
+	return &EventTaskRepo{TaskRepository: repo, hub: hub}
+}
+
+func (r *EventTaskRepo) Create(ctx context.Context, t Task, quota int) (Task, error) {
+	t, err := r.TaskRepository.Create(ctx, t, quota)
+	if err == nil {
+		r.publish(TaskEvent{Type: EventTaskCreated, Task: t})
+	}
+	return t, err
+}
+
+func (r *EventTaskRepo) CreateMany(ctx context.Context, tasks []Task, quota int) ([]Task, error) {
+	tasks, err := r.TaskRepository.CreateMany(ctx, tasks, quota)
+	if err == nil {
+		for _, t := range tasks {
+			r.publish(TaskEvent{Type: EventTaskCreated, Task: t})
+		}
+	}
+	return tasks, err
+}
+
+// ReplaceByOwner lists the owner's live personal tasks first, only to
+// announce their deletion; the replacement itself is atomic. Tasks already
+// in the trash were announced as deleted when they were moved there.
+func (r *EventTaskRepo) ReplaceByOwner(ctx context.Context, ownerID int, tasks []Task, quota int) ([]Task, error) {
+	replaced, _, err := r.TaskRepository.List(ctx, ListOptions{OwnerID: ownerID, Personal: true, IncludeArchived: true})
+	if err != nil {
+		return nil, err
+	}
+	tasks, err = r.TaskRepository.ReplaceByOwner(ctx, ownerID, tasks, quota)
+	if err != nil {
+		return tasks, err
+	}
+	for _, t := range replaced {
+		r.publish(TaskEvent{Type: EventTaskDeleted, Task: t})
+	}
+	for _, t := range tasks {
+		r.publish(TaskEvent{Type: EventTaskCreated, Task: t})
+	}
+	return tasks, nil
+}
+
+// Update reads the stored task first to tell which event the change is:
+// moving to or out of the trash, a change of assignee, which the previous
+// assignee is told about too, or any other update.
+func (r *EventTaskRepo) Update(ctx context.Context, t Task) error {
+	old, err := r.TaskRepository.Get(ctx, t.ID)
+	if err != nil {
+		return err
+	}
+	if err := r.TaskRepository.Update(ctx, t); err != nil {
+		return err
+	}
+	var also []int
+	typ := EventTaskUpdated
+	switch {
+	case old.DeletedAt == nil && t.DeletedAt != nil:
+		typ = EventTaskDeleted
+	case old.DeletedAt != nil && t.DeletedAt == nil:
+		typ = EventTaskRestored
+	case !sameUser(old.AssigneeID, t.AssigneeID):
+		typ = EventTaskAssigned
+		if old.AssigneeID != nil {
+			also = append(also, *old.AssigneeID)
+		}
+	}
+	r.publish(TaskEvent{Type: typ, Task: t}, also...)
+	return nil
+}
+
+// Reorder publishes an update for each listed task whose position changed,
+// which it lists the owner's live tasks first to tell.
+func (r *EventTaskRepo) Reorder(ctx context.Context, ownerID int, ids []string) ([]Task, error) {
+	before, err := livePositions(ctx, r.TaskRepository, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := r.TaskRepository.Reorder(ctx, ownerID, ids)
+	for _, t := range tasks {
+		if before[t.ID] != t.Position {
+			r.publish(TaskEvent{Type: EventTaskUpdated, Task: t})
+		}
+	}
+	return tasks, err
+}
+
+// SetDone publishes an update for each task it changed.
+func (r *EventTaskRepo) SetDone(ctx context.Context, ownerID, workspaceID int, ids []string, done bool, at time.Time) ([]Task, error) {
+	tasks, err := r.TaskRepository.SetDone(ctx, ownerID, workspaceID, ids, done, at)
+	for _, t := range tasks {
+		r.publish(TaskEvent{Type: EventTaskUpdated, Task: t})
+	}
+	return tasks, err
+}
+
+// Delete reads the task first so that its event can be addressed.
+func (r *EventTaskRepo) Delete(ctx context.Context, id string) error {
+	t, err := r.TaskRepository.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if err := r.TaskRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	r.publish(TaskEvent{Type: EventTaskDeleted, Task: t})
+	return nil
+}
+
+func (r *EventTaskRepo) AddComment(ctx context.Context, c Comment) (Comment, error) {
+	c, err := r.TaskRepository.AddComment(ctx, c)
+	if err != nil {
+		return c, err
+	}
+	r.publishComment(ctx, EventTaskCommented, c)
+	return c, nil
+}
+
+func (r *EventTaskRepo) DeleteComment(ctx context.Context, taskID, commentID string) error {
+	c, err := r.TaskRepository.GetComment(ctx, taskID, commentID)
+	if err != nil {
+		return err
+	}
+	if err := r.TaskRepository.DeleteComment(ctx, taskID, commentID); err != nil {
+		return err
+	}
+	r.publishComment(ctx, EventCommentDeleted, c)
+	return nil
+}
+
+// sameUser reports whether a and b are both nil or name the same user.
+func sameUser(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// publishComment publishes an event carrying c and the task it is on. The
+// change is already stored, so failing to load the task only costs the
+// event.
+func (r *EventTaskRepo) publishComment(ctx context.Context, typ string, c Comment) {
+	t, err := r.TaskRepository.Get(ctx, c.TaskID)
+	if err != nil {
+		slog.Warn("loading task for comment event failed", "task_id", c.TaskID, "error", err)
+		return
+	}
+	r.publish(TaskEvent{Type: typ, Task: t, Comment: &c})
+}
+
+// publish notifies the task's owner and assignee, the members of its
+// workspace and any users in also of ev. Each user gets the event once.
+func (r *EventTaskRepo) publish(ev TaskEvent, also ...int) {
+	t := ev.Task
+	recipients := append([]int{t.OwnerID}, also...)
+	if t.AssigneeID != nil {
+		recipients = append(recipients, *t.AssigneeID)
+	}
+	if t.WorkspaceID != nil {
+		members, err := workspaces.Members(context.Background(), *t.WorkspaceID)
+		if err != nil {
+			slog.Warn("loading workspace members for task event failed", "workspace_id", *t.WorkspaceID, "error", err)
+		}
+		for _, m := range members {
+			recipients = append(recipients, m.UserID)
+		}
+	}
+	seen := make(map[int]bool, len(recipients))
+	for _, userID := range recipients {
+		if !seen[userID] {
+			seen[userID] = true
+			r.hub.Publish(userID, ev)
+		}
+	}
+}
+
+// taskEventsHandler streams the events of the current user's tasks, of tasks
+// assigned to them and of the tasks in their workspaces as Server-Sent Events
+// until the client disconnects or falls too far behind.
+func taskEventsHandler(w http.ResponseWriter, r *http.Request) {
+	events, unsubscribe := taskEvents.Subscribe(currentUser(r.Context()).ID)
+	defer unsubscribe()
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	// Tell nginx not to buffer the stream.
+	h.Set("X-Accel-Buffering", "no")
+	rc := http.NewResponseController(w)
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(ev)
+			if err != nil {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
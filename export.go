@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// exportVersion identifies the layout of export documents. Imports reject
+// other versions.
+const exportVersion = 1
+
+// exportDocument is the body of GET /me/export and POST /me/import. Imports
+// are bounded by MAX_BODY_BYTES like any other request.
+type exportDocument struct {
+	Version    int            `json:"version"`
+	ExportedAt time.Time      `json:"exported_at"`
+	Tasks      []exportedTask `json:"tasks"`
+}
+
+// exportedTask is a task without its server-assigned fields: IDs, owner and
+// assignee are not carried across, and imported tasks get a new creation
+// time. Attachments are left out.
+type exportedTask struct {
+	Title        string            `json:"title"`
+	Description  string            `json:"description"`
+	Done         bool              `json:"done"`
+	Tags         []string          `json:"tags"`
+	DueDate      *time.Time        `json:"due_date"`
+	Priority     string            `json:"priority"`
+	Recurrence   string            `json:"recurrence"`
+	Subtasks     []exportedSubtask `json:"subtasks"`
+	AutoComplete bool              `json:"auto_complete"`
+	CompletedAt  *time.Time        `json:"completed_at"`
+	// DeletedAt is set for tasks that were in the trash, ArchivedAt for
+	// those that were archived.
+	DeletedAt  *time.Time `json:"deleted_at"`
+	ArchivedAt *time.Time `json:"archived_at"`
+}
+
+type exportedSubtask struct {
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+}
+
+// exportTasksHandler returns every personal task of the current user,
+// including those in the trash and the archive, as a downloadable document that POST
+// /me/import accepts. Workspace tasks belong to the workspace and are left
+// out.
+func exportTasksHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r.Context()).ID
+
+	doc := exportDocument{Version: exportVersion, ExportedAt: time.Now().UTC(), Tasks: []exportedTask{}}
+	for _, trashed := range []bool{false, true} {
+		tasks, _, err := taskRepo.List(r.Context(), ListOptions{OwnerID: userID, Personal: true, Trashed: trashed, IncludeArchived: true})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		for _, t := range tasks {
+			doc.Tasks = append(doc.Tasks, exportTask(t))
+		}
+	}
+
+	w.Header().Set("Content-Disposition", `attachment; filename="tasks-export.json"`)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, doc)
+}
+
+func exportTask(t Task) exportedTask {
+	subtasks := make([]exportedSubtask, len(t.Subtasks))
+	for i, s := range t.Subtasks {
+		subtasks[i] = exportedSubtask{Title: s.Title, Done: s.Done}
+	}
+	return exportedTask{
+		Title:        t.Title,
+		Description:  t.Description,
+		Done:         t.Done,
+		Tags:         t.Tags,
+		DueDate:      t.DueDate,
+		Priority:     t.Priority,
+		Recurrence:   t.Recurrence,
+		Subtasks:     subtasks,
+		AutoComplete: t.AutoComplete,
+		CompletedAt:  t.CompletedAt,
+		DeletedAt:    t.DeletedAt,
+		ArchivedAt:   t.ArchivedAt,
+	}
+}
+
+// Import modes.
+const (
+	importMerge   = "merge"
+	importReplace = "replace"
+)
+
+// importTasksHandler creates the tasks of an export document as personal
+// tasks of the current user with fresh IDs. With ?mode=replace the user's
+// existing personal tasks, including the trash, are deleted first; the
+// default ?mode=merge keeps them. The whole document is validated before
+// anything is written, and the write itself is all-or-nothing. With
+// ?dry_run=true nothing is written; the response lists every rejected task
+// and how many tasks would be imported and deleted.
+func importTasksHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r.Context()).ID
+
+	mode := r.URL.Query().Get("mode")
+	switch mode {
+	case "":
+		mode = importMerge
+	case importMerge, importReplace:
+	default:
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "mode must be merge or replace")
+		return
+	}
+	dryRun, err := parseDryRun(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	var doc exportDocument
+	if !decodeJSON(w, r, &doc) {
+		return
+	}
+	if doc.Version != exportVersion {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("unsupported export version %d (expected %d)", doc.Version, exportVersion))
+		return
+	}
+
+	tasks, rejected := buildTasks(doc.Tasks, dryRun, func(et exportedTask) (Task, error) {
+		return importTask(userID, et)
+	})
+	if dryRun {
+		writeImportDryRun(w, r, mode, tasks, rejected)
+		return
+	}
+	if len(rejected) > 0 {
+		writeRejection(w, rejected[0])
+		return
+	}
+
+	quota := taskQuota(currentUser(r.Context()))
+	var replaced []Task
+	var created []Task
+	if mode == importReplace {
+		// Fetched only for the audit log; the replacement is atomic.
+		if replaced, _, err = taskRepo.List(r.Context(), ListOptions{OwnerID: userID, Personal: true, IncludeArchived: true}); err != nil {
+			serverError(w, err)
+			return
+		}
+		created, err = taskRepo.ReplaceByOwner(r.Context(), userID, tasks, quota)
+	} else {
+		created, err = taskRepo.CreateMany(r.Context(), tasks, quota)
+	}
+	if err != nil {
+		taskRepoError(w, err)
+		return
+	}
+
+	for _, t := range replaced {
+		auditLog.recordDetail(r, userID, AuditTaskDelete, t.ID, "import_replace")
+	}
+	for _, t := range created {
+		recurringTasks.enqueue(t)
+		auditLog.record(r, userID, AuditTaskCreate, t.ID)
+	}
+	encode(w, r, http.StatusOK, map[string]int{"imported": len(created)})
+}
+
+// importDryRun is the response to POST /me/import?dry_run=true. Imported is
+// how many tasks would be created and Replaced how many personal tasks,
+// counting the trash, ?mode=replace would delete; both are 0 if anything
+// would fail.
+type importDryRun struct {
+	dryRunResult
+	Imported int `json:"imported"`
+	Replaced int `json:"replaced"`
+}
+
+func writeImportDryRun(w http.ResponseWriter, r *http.Request, mode string, tasks []Task, rejected []apiError) {
+	user := currentUser(r.Context())
+	replaced := 0
+	if mode == importReplace {
+		var err error
+		if replaced, err = countWithTrash(r.Context(), ListOptions{OwnerID: user.ID, Personal: true}); err != nil {
+			serverError(w, err)
+			return
+		}
+	}
+	quotaErr, err := previewQuota(r.Context(), user, len(tasks), replaced)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	res := importDryRun{dryRunResult: newDryRunResult(rejected, quotaErr)}
+	if res.WouldSucceed {
+		res.Imported, res.Replaced = len(tasks), replaced
+	}
+	encode(w, r, http.StatusOK, res)
+}
+
+// Validate applies the taskInput limits, plus the subtask limits, to an
+// imported task.
+func (et exportedTask) Validate() error {
+	errs := validationErrors{}
+	validateTitle(errs, et.Title)
+	validateDescription(errs, et.Description)
+	validateTags(errs, et.Tags)
+	if len(et.Subtasks) > maxSubtasks {
+		errs["subtasks"] = fmt.Sprintf("must have at most %d entries", maxSubtasks)
+	} else {
+		for i, s := range et.Subtasks {
+			sub := validationErrors{}
+			validateTitle(sub, s.Title)
+			if msg, ok := sub["title"]; ok {
+				errs[fmt.Sprintf("subtasks[%d].title", i)] = msg
+			}
+		}
+	}
+	return errs.orNil()
+}
+
+// importTask builds the task described by et for userID. Subtasks get fresh
+// IDs too.
+func importTask(userID int, et exportedTask) (Task, error) {
+	priority, err := normalizePriority(et.Priority)
+	if err != nil {
+		return Task{}, err
+	}
+	recurrence, err := normalizeRecurrence(et.Recurrence)
+	if err != nil {
+		return Task{}, err
+	}
+	subtasks := make([]Subtask, len(et.Subtasks))
+	for i, s := range et.Subtasks {
+		id, err := newTaskID()
+		if err != nil {
+			return Task{}, err
+		}
+		subtasks[i] = Subtask{ID: id, Title: s.Title, Done: s.Done}
+	}
+
+	t := Task{
+		OwnerID:      userID,
+		Title:        et.Title,
+		Description:  et.Description,
+		Tags:         normalizeTags(et.Tags),
+		DueDate:      utcTime(et.DueDate),
+		Priority:     priority,
+		Recurrence:   recurrence,
+		Subtasks:     subtasks,
+		AutoComplete: et.AutoComplete,
+		DeletedAt:    utcTime(et.DeletedAt),
+	}
+	t.setDone(et.Done)
+	if t.Done && et.CompletedAt != nil {
+		t.CompletedAt = utcTime(et.CompletedAt)
+	}
+	if et.ArchivedAt != nil {
+		t.Archived, t.ArchivedAt = true, utcTime(et.ArchivedAt)
+	}
+	return t, nil
+}
+
+func utcTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// draining is set once the server is shutting down, so that /readyz fails
+// while in-flight and late requests are still served.
+var draining atomic.Bool
+
+// pinger is implemented by backends that can cheaply check their connection.
+type pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// healthzHandler reports that the process is alive. It never touches a backend.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// readyzHandler reports whether the backends we depend on are reachable, so
+// orchestrators stop routing traffic to an instance that can't serve it.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if draining.Load() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+
+	if err := pingSessionStore(ctx); err != nil {
+		slog.Warn("readiness check failed", "check", "session_store", "error", err)
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+			"status": "unavailable",
+			"check":  "session_store",
+		})
+		return
+	}
+	if p, ok := taskRepo.(pinger); ok {
+		if err := p.Ping(ctx); err != nil {
+			slog.Warn("readiness check failed", "check", "database", "error", err)
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{
+				"status": "unavailable",
+				"check":  "database",
+			})
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
+
+// pingSessionStore checks the session store. Stores without a Ping method are
+// probed with a lookup of a token that can never exist.
+func pingSessionStore(ctx context.Context) error {
+	if p, ok := sessionManager.Store.(pinger); ok {
+		return p.Ping(ctx)
+	}
+	_, _, err := sessionManager.Store.Find("readyz-probe")
+	return err
+}
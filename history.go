@@ -0,0 +1,318 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHistoryTasks bounds how many tasks the in-memory history keeps entries
+// for. Tasks removed in bulk (by the trash purge, an import, or deleting a
+// user or workspace) aren't forgotten explicitly, so their entries go once
+// they are the least recently changed.
+const maxHistoryTasks = 10000
+
+// History actions.
+const (
+	HistoryCreated = "created"
+	HistoryUpdated = "updated"
+)
+
+// HistoryEntry records one change to a task.
+type HistoryEntry struct {
+	TaskID string `json:"task_id"`
+	// ActorID is the user who made the change, or nil for changes made by
+	// the server itself, such as the recurrence worker completing a task.
+	ActorID *int      `json:"actor_id"`
+	Action  string    `json:"action"`
+	At      time.Time `json:"at"`
+	// Changes lists the fields an update changed; it is empty for
+	// creations.
+	Changes []FieldChange `json:"changes"`
+}
+
+// FieldChange is the old and new value of one field, named as in the task's
+// JSON.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+// HistoryStore keeps the change log of tasks.
+type HistoryStore interface {
+	Append(ctx context.Context, e HistoryEntry) error
+	// List returns the entries of a task, newest first.
+	List(ctx context.Context, taskID string) ([]HistoryEntry, error)
+	// Forget drops the entries of a task that was permanently removed.
+	Forget(ctx context.Context, taskID string) error
+}
+
+// MemoryHistoryStore is a HistoryStore keeping the latest size entries of
+// each task in memory. Data is lost on restart.
+type MemoryHistoryStore struct {
+	size int
+
+	mu    sync.Mutex
+	tasks map[string]*list.Element
+	// lru holds *historyRing values, most recently changed first.
+	lru *list.List
+}
+
+type historyRing struct {
+	taskID string
+	// entries is oldest first.
+	entries []HistoryEntry
+}
+
+// NewMemoryHistoryStore returns an empty store keeping size entries per
+// task.
+func NewMemoryHistoryStore(size int) *MemoryHistoryStore {
+	return &MemoryHistoryStore{size: size, tasks: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (s *MemoryHistoryStore) Append(ctx context.Context, e HistoryEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.tasks[e.TaskID]
+	if ok {
+		s.lru.MoveToFront(el)
+	} else {
+		el = s.lru.PushFront(&historyRing{taskID: e.TaskID})
+		s.tasks[e.TaskID] = el
+		for s.lru.Len() > maxHistoryTasks {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.tasks, oldest.Value.(*historyRing).taskID)
+		}
+	}
+	h := el.Value.(*historyRing)
+	if len(h.entries) >= s.size {
+		h.entries = append(h.entries[:0], h.entries[len(h.entries)-s.size+1:]...)
+	}
+	h.entries = append(h.entries, e)
+	return nil
+}
+
+func (s *MemoryHistoryStore) List(ctx context.Context, taskID string) ([]HistoryEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entries := make([]HistoryEntry, 0)
+	if el, ok := s.tasks[taskID]; ok {
+		h := el.Value.(*historyRing)
+		for i := len(h.entries) - 1; i >= 0; i-- {
+			entries = append(entries, h.entries[i])
+		}
+	}
+	return entries, nil
+}
+
+func (s *MemoryHistoryStore) Forget(ctx context.Context, taskID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.tasks[taskID]; ok {
+		s.lru.Remove(el)
+		delete(s.tasks, taskID)
+	}
+	return nil
+}
+
+// diffTasks returns the fields that differ between old and new, in the
+// order Task declares them. Times are compared as instants and empty
+// slices equal nil ones, so that a round trip through a store doesn't show
+// up as a change.
+func diffTasks(old, new Task) []FieldChange {
+	var changes []FieldChange
+	ov, nv := reflect.ValueOf(old), reflect.ValueOf(new)
+	typ := ov.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		name, _, _ := strings.Cut(typ.Field(i).Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		if !historyEqual(ov.Field(i), nv.Field(i)) {
+			changes = append(changes, FieldChange{Field: name, Old: ov.Field(i).Interface(), New: nv.Field(i).Interface()})
+		}
+	}
+	return changes
+}
+
+// historyEqual reports whether two values of a Task field are the same for
+// the purpose of diffTasks.
+func historyEqual(a, b reflect.Value) bool {
+	if a.Kind() == reflect.Pointer {
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return historyEqual(a.Elem(), b.Elem())
+	}
+	if t, ok := a.Interface().(time.Time); ok {
+		return t.Equal(b.Interface().(time.Time))
+	}
+	if a.Kind() == reflect.Slice && a.Len() == 0 && b.Len() == 0 {
+		return true
+	}
+	return reflect.DeepEqual(a.Interface(), b.Interface())
+}
+
+// HistoryTaskRepo is a TaskRepository that records every creation and
+// update made through it in a HistoryStore, attributed to the user of the
+// request the context belongs to. Recording happens after the change is
+// stored and a failure to record is logged, not returned. Unassign and
+// MarkReminded, which only the server itself uses in bulk, aren't recorded.
+type HistoryTaskRepo struct {
+	TaskRepository
+	history HistoryStore
+}
+
+// NewHistoryTaskRepo wraps repo so that its changes are recorded in history.
+func NewHistoryTaskRepo(repo TaskRepository, history HistoryStore) *HistoryTaskRepo {
+	return &HistoryTaskRepo{TaskRepository: repo, history: history}
+}
+
+// record appends an entry for a change made by the user of ctx, if any.
+func (r *HistoryTaskRepo) record(ctx context.Context, taskID, action string, changes []FieldChange) {
+	e := HistoryEntry{TaskID: taskID, Action: action, At: time.Now().UTC(), Changes: changes}
+	if e.Changes == nil {
+		e.Changes = []FieldChange{}
+	}
+	if user, ok := ctx.Value(userKey).(User); ok {
+		e.ActorID = &user.ID
+	}
+	if err := r.history.Append(ctx, e); err != nil {
+		slog.Error("recording task history failed", "task_id", taskID, "error", err)
+	}
+}
+
+func (r *HistoryTaskRepo) Create(ctx context.Context, t Task, quota int) (Task, error) {
+	t, err := r.TaskRepository.Create(ctx, t, quota)
+	if err == nil {
+		r.record(ctx, t.ID, HistoryCreated, nil)
+	}
+	return t, err
+}
+
+func (r *HistoryTaskRepo) CreateMany(ctx context.Context, tasks []Task, quota int) ([]Task, error) {
+	tasks, err := r.TaskRepository.CreateMany(ctx, tasks, quota)
+	for _, t := range tasks {
+		r.record(ctx, t.ID, HistoryCreated, nil)
+	}
+	return tasks, err
+}
+
+func (r *HistoryTaskRepo) ReplaceByOwner(ctx context.Context, ownerID int, tasks []Task, quota int) ([]Task, error) {
+	tasks, err := r.TaskRepository.ReplaceByOwner(ctx, ownerID, tasks, quota)
+	for _, t := range tasks {
+		r.record(ctx, t.ID, HistoryCreated, nil)
+	}
+	return tasks, err
+}
+
+// Update reads the stored task first to diff against it. Concurrent updates
+// of the same task may therefore be recorded against a slightly stale
+// version.
+func (r *HistoryTaskRepo) Update(ctx context.Context, t Task) error {
+	old, err := r.TaskRepository.Get(ctx, t.ID)
+	if err != nil {
+		return err
+	}
+	if err := r.TaskRepository.Update(ctx, t); err != nil {
+		return err
+	}
+	// Update leaves the position alone, whatever t says.
+	t.Position = old.Position
+	if changes := diffTasks(old, t); len(changes) > 0 {
+		r.record(ctx, t.ID, HistoryUpdated, changes)
+	}
+	return nil
+}
+
+// Reorder lists the owner's live tasks first to tell which positions
+// changed. Tasks only renumbered to make room keep their order and aren't
+// recorded.
+func (r *HistoryTaskRepo) Reorder(ctx context.Context, ownerID int, ids []string) ([]Task, error) {
+	before, err := livePositions(ctx, r.TaskRepository, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := r.TaskRepository.Reorder(ctx, ownerID, ids)
+	for _, t := range tasks {
+		if old := before[t.ID]; old != t.Position {
+			r.record(ctx, t.ID, HistoryUpdated, []FieldChange{{Field: "position", Old: old, New: t.Position}})
+		}
+	}
+	return tasks, err
+}
+
+// SetDone reads the listed tasks first, for the completion times that
+// marking them not done clears.
+func (r *HistoryTaskRepo) SetDone(ctx context.Context, ownerID, workspaceID int, ids []string, done bool, at time.Time) ([]Task, error) {
+	before := make(map[string]Task, len(ids))
+	for _, id := range ids {
+		if t, err := r.TaskRepository.Get(ctx, id); err == nil {
+			before[t.ID] = t
+		}
+	}
+	tasks, err := r.TaskRepository.SetDone(ctx, ownerID, workspaceID, ids, done, at)
+	for _, t := range tasks {
+		old, ok := before[t.ID]
+		if !ok {
+			continue
+		}
+		if changes := diffTasks(old, t); len(changes) > 0 {
+			r.record(ctx, t.ID, HistoryUpdated, changes)
+		}
+	}
+	return tasks, err
+}
+
+// livePositions returns the positions of ownerID's live tasks by ID,
+// archived or not.
+func livePositions(ctx context.Context, repo TaskRepository, ownerID int) (map[string]float64, error) {
+	tasks, _, err := repo.List(ctx, ListOptions{OwnerID: ownerID, IncludeArchived: true})
+	if err != nil {
+		return nil, err
+	}
+	positions := make(map[string]float64, len(tasks))
+	for _, t := range tasks {
+		positions[t.ID] = t.Position
+	}
+	return positions, nil
+}
+
+func (r *HistoryTaskRepo) Delete(ctx context.Context, id string) error {
+	if err := r.TaskRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+	if err := r.history.Forget(ctx, id); err != nil {
+		slog.Error("forgetting task history failed", "task_id", id, "error", err)
+	}
+	return nil
+}
+
+// taskHistoryHandler returns the change log of a task, newest first, to
+// whoever may change the task (its owner, or any member of its workspace)
+// and to admins. Tasks in the trash have a history too.
+func taskHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	var task Task
+	var ok bool
+	if currentUser(r.Context()).Role == RoleAdmin {
+		task, ok = loadAnyTaskOrTrashed(w, r)
+	} else {
+		task, ok = loadOwnedTaskOrTrashed(w, r)
+	}
+	if !ok {
+		return
+	}
+	entries, err := taskHistory.List(r.Context(), task.ID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, map[string][]HistoryEntry{"history": entries})
+}
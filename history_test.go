@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDiffTasks(t *testing.T) {
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	later := due.Add(24 * time.Hour)
+	one, two := 1, 2
+	base := Task{
+		ID:        "t1",
+		OwnerID:   1,
+		Title:     "Write report",
+		Tags:      []string{"work", "q1"},
+		DueDate:   &due,
+		Subtasks:  []Subtask{{ID: "s1", Title: "Outline"}, {ID: "s2", Title: "Draft"}},
+		Priority:  PriorityMedium,
+		CreatedAt: due.Add(-time.Hour),
+	}
+	// with returns a copy of base, slices included, changed by f.
+	with := func(f func(*Task)) Task {
+		t := base
+		t.Tags = slices.Clone(base.Tags)
+		t.Subtasks = slices.Clone(base.Subtasks)
+		f(&t)
+		return t
+	}
+
+	tests := []struct {
+		name     string
+		old, new Task
+		want     []string
+	}{
+		{"unchanged", base, with(func(*Task) {}), nil},
+		{"hidden fields", base, with(func(t *Task) { t.UniqueTitle, t.Succeeded = true, true }), nil},
+		{"same instant elsewhere", base, with(func(t *Task) {
+			d := due.In(time.FixedZone("CET", 3600))
+			t.DueDate = &d
+			t.CreatedAt = t.CreatedAt.In(time.FixedZone("EST", -5*3600))
+		}), nil},
+
+		{"due date set", with(func(t *Task) { t.DueDate = nil }), base, []string{"due_date"}},
+		{"due date cleared", base, with(func(t *Task) { t.DueDate = nil }), []string{"due_date"}},
+		{"due date moved", base, with(func(t *Task) { t.DueDate = &later }), []string{"due_date"}},
+		{"no due date either side", with(func(t *Task) { t.DueDate = nil }), with(func(t *Task) { t.DueDate = nil }), nil},
+
+		{"assigned", base, with(func(t *Task) { t.AssigneeID = &one }), []string{"assignee_id"}},
+		{"unassigned", with(func(t *Task) { t.AssigneeID = &one }), base, []string{"assignee_id"}},
+		{"reassigned", with(func(t *Task) { t.AssigneeID = &one }), with(func(t *Task) { t.AssigneeID = &two }), []string{"assignee_id"}},
+		{"same assignee, other pointer", with(func(t *Task) { t.AssigneeID = &one }), with(func(t *Task) { a := 1; t.AssigneeID = &a }), nil},
+
+		{"tag added", base, with(func(t *Task) { t.Tags = append(t.Tags, "urgent") }), []string{"tags"}},
+		{"tag removed", base, with(func(t *Task) { t.Tags = t.Tags[:1] }), []string{"tags"}},
+		{"tags reordered", base, with(func(t *Task) { slices.Reverse(t.Tags) }), []string{"tags"}},
+		{"tags cleared", base, with(func(t *Task) { t.Tags = nil }), []string{"tags"}},
+		{"no tags, nil and empty", with(func(t *Task) { t.Tags = nil }), with(func(t *Task) { t.Tags = []string{} }), nil},
+
+		{"subtask added", base, with(func(t *Task) { t.Subtasks = append(t.Subtasks, Subtask{ID: "s3", Title: "Review"}) }), []string{"subtasks"}},
+		{"subtask done", base, with(func(t *Task) { t.Subtasks[1].Done = true }), []string{"subtasks"}},
+		{"subtask renamed", base, with(func(t *Task) { t.Subtasks[0].Title = "Plan" }), []string{"subtasks"}},
+		{"subtasks removed", base, with(func(t *Task) { t.Subtasks = nil }), []string{"subtasks"}},
+		{"no subtasks, nil and empty", with(func(t *Task) { t.Subtasks = nil }), with(func(t *Task) { t.Subtasks = []Subtask{} }), nil},
+
+		{"several fields, in declaration order", base, with(func(t *Task) {
+			t.Subtasks[0].Done = true
+			t.Title = "Write the report"
+			t.Done = true
+			t.DueDate = nil
+		}), []string{"title", "done", "due_date", "subtasks"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changes := diffTasks(tt.old, tt.new)
+			var got []string
+			for _, c := range changes {
+				got = append(got, c.Field)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("changed %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("old and new values", func(t *testing.T) {
+		changes := diffTasks(base, with(func(t *Task) { t.DueDate, t.AssigneeID = nil, &two }))
+		want := []FieldChange{
+			{Field: "due_date", Old: &due, New: (*time.Time)(nil)},
+			{Field: "assignee_id", Old: (*int)(nil), New: &two},
+		}
+		if !reflect.DeepEqual(changes, want) {
+			t.Errorf("got %+v, want %+v", changes, want)
+		}
+	})
+}
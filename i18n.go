@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultLanguage is the language handlers write error messages in, and the
+// one clients get when none of their preferred languages is supported.
+const defaultLanguage = "en"
+
+// A Catalog translates the messages of error responses. Handlers always
+// write English; writeAPIError asks the catalog for the client's language.
+// Only messages change: error codes and field names are part of the API.
+type Catalog interface {
+	// Supports reports whether the catalog translates into lang, a
+	// lowercase language tag such as "de" or "pt-br".
+	Supports(lang string) bool
+	// Translate returns the English message msg in lang, or msg itself if
+	// there is no translation for it.
+	Translate(lang, msg string) string
+}
+
+// messageCatalog is the Catalog error responses are translated with.
+var messageCatalog Catalog = newFormatCatalog(map[string]map[string]string{
+	"de": germanMessages,
+})
+
+// formatCatalog is a Catalog keyed by the English messages, or the
+// fmt format they are made from. A message made with
+// fmt.Sprintf("must be at most %d characters", n) is found under
+// "must be at most %d characters"; its translation takes the values back as
+// %s, or %[n]s when it puts them in a different order.
+type formatCatalog struct {
+	exact    map[string]map[string]string
+	patterns map[string][]messagePattern
+}
+
+// messagePattern matches the messages made from one format.
+type messagePattern struct {
+	format      string
+	re          *regexp.Regexp
+	translation string
+}
+
+// formatVerb matches the verbs a message format may use.
+var formatVerb = regexp.MustCompile(`%[dsqv%]`)
+
+// newFormatCatalog builds a formatCatalog from the translations of each
+// language, keyed by English format.
+func newFormatCatalog(translations map[string]map[string]string) *formatCatalog {
+	c := &formatCatalog{
+		exact:    make(map[string]map[string]string),
+		patterns: make(map[string][]messagePattern),
+	}
+	for lang, messages := range translations {
+		c.exact[lang] = make(map[string]string)
+		for format, translation := range messages {
+			if !formatVerb.MatchString(format) {
+				c.exact[lang][format] = translation
+				continue
+			}
+			c.patterns[lang] = append(c.patterns[lang], messagePattern{format: format, re: formatPattern(format), translation: translation})
+		}
+		// Longer formats first, so that the most specific one wins.
+		patterns := c.patterns[lang]
+		sort.Slice(patterns, func(i, j int) bool {
+			if len(patterns[i].format) != len(patterns[j].format) {
+				return len(patterns[i].format) > len(patterns[j].format)
+			}
+			return patterns[i].format < patterns[j].format
+		})
+	}
+	return c
+}
+
+// formatPattern returns a regexp matching the messages made from format,
+// capturing their values.
+func formatPattern(format string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	last := 0
+	for _, loc := range formatVerb.FindAllStringIndex(format, -1) {
+		b.WriteString(regexp.QuoteMeta(format[last:loc[0]]))
+		switch format[loc[0]+1] {
+		case 'd':
+			b.WriteString(`(-?\d+)`)
+		case 'q':
+			b.WriteString(`("(?:[^"\\]|\\.)*")`)
+		case '%':
+			b.WriteString("%")
+		default:
+			b.WriteString(`(.*?)`)
+		}
+		last = loc[1]
+	}
+	b.WriteString(regexp.QuoteMeta(format[last:]))
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func (c *formatCatalog) Supports(lang string) bool {
+	_, ok := c.exact[lang]
+	return ok || lang == defaultLanguage
+}
+
+func (c *formatCatalog) Translate(lang, msg string) string {
+	if t, ok := c.exact[lang][msg]; ok {
+		return t
+	}
+	for _, p := range c.patterns[lang] {
+		m := p.re.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		args := make([]any, len(m)-1)
+		for i, v := range m[1:] {
+			args[i] = v
+		}
+		return fmt.Sprintf(p.translation, args...)
+	}
+	return msg
+}
+
+// negotiateLanguage returns the language of c the client prefers according
+// to an Accept-Language header, falling back to defaultLanguage. A regional
+// tag such as de-AT also accepts its base language.
+func negotiateLanguage(header string, c Catalog) string {
+	type choice struct {
+		tag string
+		q   float64
+	}
+	var choices []choice
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			choices = append(choices, choice{tag, q})
+		}
+	}
+	sort.SliceStable(choices, func(i, j int) bool { return choices[i].q > choices[j].q })
+	for _, ch := range choices {
+		if ch.tag == "*" {
+			return defaultLanguage
+		}
+		if c.Supports(ch.tag) {
+			return ch.tag
+		}
+		if base, _, ok := strings.Cut(ch.tag, "-"); ok && c.Supports(base) {
+			return base
+		}
+	}
+	return defaultLanguage
+}
+
+// localizeErrors makes the error responses written through w speak the
+// language the client asks for in Accept-Language. http.TimeoutHandler
+// hands its handler a writer of its own, hiding this one; the middleware is
+// installed again beneath it.
+func localizeErrors(c Catalog, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang := negotiateLanguage(r.Header.Get("Accept-Language"), c)
+		next.ServeHTTP(&localizedWriter{ResponseWriter: w, catalog: c, lang: lang}, r)
+	})
+}
+
+// localizedWriter carries the language negotiated by localizeErrors to
+// writeAPIError.
+type localizedWriter struct {
+	http.ResponseWriter
+	catalog Catalog
+	lang    string
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *localizedWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// localize translates the message and field messages of e for the client
+// of w. Without localizeErrors in front of w, e is returned unchanged.
+func localize(w http.ResponseWriter, e apiError) apiError {
+	lw := localizedWriterOf(w)
+	if lw == nil {
+		return e
+	}
+	w.Header().Add("Vary", "Accept-Language")
+	w.Header().Set("Content-Language", lw.lang)
+	if lw.lang == defaultLanguage {
+		return e
+	}
+	e.Message = lw.catalog.Translate(lw.lang, e.Message)
+	if e.Fields != nil {
+		fields := make(validationErrors, len(e.Fields))
+		for field, msg := range e.Fields {
+			fields[field] = lw.catalog.Translate(lw.lang, msg)
+		}
+		e.Fields = fields
+	}
+	return e
+}
+
+// localizedWriterOf returns the localizedWriter among the writers wrapped
+// by w, or nil.
+func localizedWriterOf(w http.ResponseWriter) *localizedWriter {
+	for {
+		if lw, ok := w.(*localizedWriter); ok {
+			return lw
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = u.Unwrap()
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// idempotencyKeyTTL is how long a replayed Idempotency-Key keeps returning
+// the task it originally created.
+const idempotencyKeyTTL = 24 * time.Hour
+
+// maxIdempotencyKeyLen bounds the header value we are willing to store.
+const maxIdempotencyKeyLen = 255
+
+// idempotencyStore remembers which task each (user, Idempotency-Key) pair
+// created. Like the rate limiters it is per process, so with several
+// instances a retry only deduplicates if it reaches the same instance.
+type idempotencyStore struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[idempotencyKey]*idempotencyEntry
+}
+
+type idempotencyKey struct {
+	userID int
+	key    string
+}
+
+// idempotencyEntry is reserved before the task is created so that concurrent
+// requests with the same key wait for the first one instead of racing it.
+// ready is closed once taskID is set or the creation failed.
+type idempotencyEntry struct {
+	ready       chan struct{}
+	fingerprint [sha256.Size]byte
+	taskID      string
+	created     time.Time
+}
+
+func newIdempotencyStore(ttl time.Duration) *idempotencyStore {
+	s := &idempotencyStore{
+		ttl:     ttl,
+		entries: make(map[idempotencyKey]*idempotencyEntry),
+	}
+	go s.collectGarbage()
+	return s
+}
+
+// reserve returns the live entry for (userID, key). If there is none, a new
+// pending entry is stored and reserve reports owner=true; the caller must
+// then call complete or release on it.
+func (s *idempotencyStore) reserve(userID int, key string, fingerprint [sha256.Size]byte) (e *idempotencyEntry, owner bool) {
+	k := idempotencyKey{userID: userID, key: key}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[k]; ok && time.Since(e.created) < s.ttl {
+		return e, false
+	}
+	e = &idempotencyEntry{
+		ready:       make(chan struct{}),
+		fingerprint: fingerprint,
+		created:     time.Now(),
+	}
+	s.entries[k] = e
+	return e, true
+}
+
+// complete records the task created for a reserved entry and wakes waiters.
+func (s *idempotencyStore) complete(e *idempotencyEntry, taskID string) {
+	s.mu.Lock()
+	e.taskID = taskID
+	s.mu.Unlock()
+	close(e.ready)
+}
+
+// release drops a reserved entry whose request failed so that the client
+// can retry with the same key.
+func (s *idempotencyStore) release(userID int, key string, e *idempotencyEntry) {
+	k := idempotencyKey{userID: userID, key: key}
+	s.mu.Lock()
+	if s.entries[k] == e {
+		delete(s.entries, k)
+	}
+	s.mu.Unlock()
+	close(e.ready)
+}
+
+// result returns the task ID recorded for a finished entry, or "" if the
+// original request failed.
+func (s *idempotencyStore) result(e *idempotencyEntry) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return e.taskID
+}
+
+func (s *idempotencyStore) collectGarbage() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-s.ttl)
+		s.mu.Lock()
+		for k, e := range s.entries {
+			if e.created.Before(cutoff) {
+				delete(s.entries, k)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
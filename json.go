@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// decodeJSON strictly decodes the request body into dst: unknown fields,
+// trailing data and type mismatches are rejected. On failure it writes a
+// 400 (or 413 for an oversized body) describing the problem and returns false.
+func decodeJSON(w http.ResponseWriter, r *http.Request, dst any) bool {
+	return decodeJSONFrom(w, r.Body, dst)
+}
+
+// decodeJSONFrom is decodeJSON for a body already read from the request.
+func decodeJSONFrom(w http.ResponseWriter, body io.Reader, dst any) bool {
+	dec := json.NewDecoder(body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		// A second Decode must hit EOF, otherwise there is more than one value.
+		if err = dec.Decode(&struct{}{}); errors.Is(err, io.EOF) {
+			return true
+		}
+		if err == nil {
+			err = errTrailingData
+		}
+	}
+
+	var (
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+		maxErr    *http.MaxBytesError
+	)
+	switch {
+	case errors.As(err, &maxErr):
+		writeError(w, http.StatusRequestEntityTooLarge, CodePayloadTooLarge, fmt.Sprintf("request body too large (limit %d bytes)", maxErr.Limit))
+	case errors.As(err, &syntaxErr):
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, fmt.Sprintf("malformed JSON body (at character %d)", syntaxErr.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "malformed JSON body")
+	case errors.As(err, &typeErr):
+		if typeErr.Field != "" {
+			writeError(w, http.StatusBadRequest, CodeInvalidJSON, fmt.Sprintf("invalid type for field %q", typeErr.Field))
+		} else {
+			writeError(w, http.StatusBadRequest, CodeInvalidJSON, fmt.Sprintf("invalid JSON type (at character %d)", typeErr.Offset))
+		}
+	case errors.Is(err, io.EOF):
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "request body must not be empty")
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields.
+		field := strings.TrimPrefix(err.Error(), "json: unknown field ")
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "unknown field "+field)
+	case errors.Is(err, errTrailingData):
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "request body must contain a single JSON value")
+	default:
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "malformed JSON body")
+	}
+	return false
+}
+
+var errTrailingData = errors.New("trailing data after JSON value")
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing JSON response: %v", err)
+	}
+}
+
+// Error codes sent in the "code" field of error responses. Clients switch on
+// them, so they are part of the API: add new ones, but never rename these.
+const (
+	CodeBadRequest           = "bad_request"
+	CodeInvalidJSON          = "invalid_json"
+	CodeUnauthorized         = "unauthorized"
+	CodeInvalidCredentials   = "invalid_credentials"
+	CodeForbidden            = "forbidden"
+	CodeCSRFFailed           = "csrf_failed"
+	CodeNotFound             = "not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodePreconditionFailed   = "precondition_failed"
+	CodePayloadTooLarge      = "payload_too_large"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeValidationFailed     = "validation_failed"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeRateLimited          = "rate_limited"
+	CodeTimeout              = "timeout"
+	CodeBadGateway           = "bad_gateway"
+	CodeUnavailable          = "service_unavailable"
+	CodeInternal             = "internal_error"
+)
+
+// apiError is the body of every error response, wrapped as {"error": ...}.
+// Fields and Index are only set for validation failures.
+type apiError struct {
+	Code    string           `json:"code"`
+	Message string           `json:"message"`
+	Fields  validationErrors `json:"fields,omitempty"`
+	Index   *int             `json:"index,omitempty"`
+}
+
+// writeError sends {"error":{"code":...,"message":...}} with the given status.
+// All error responses go through it so that clients see a single shape.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIError(w, status, apiError{Code: code, Message: message})
+}
+
+// writeAPIError sends e, with its messages in the client's language (see
+// localizeErrors).
+func writeAPIError(w http.ResponseWriter, status int, e apiError) {
+	writeJSON(w, status, map[string]apiError{"error": localize(w, e)})
+}
+
+// serverError logs err and sends a generic 500 so internals aren't leaked.
+func serverError(w http.ResponseWriter, err error) {
+	log.Printf("internal error: %v", err)
+	writeError(w, http.StatusInternalServerError, CodeInternal, "internal server error")
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LockoutConfig controls per-account login throttling: Threshold
+// consecutive failed logins within Window lock the account for Duration.
+type LockoutConfig struct {
+	Threshold int
+	Window    time.Duration
+	Duration  time.Duration
+}
+
+// loginLockout counts failed logins per username, whatever IP they come
+// from, complementing the per-IP login rate limit against distributed
+// guessing. Unknown usernames are tracked exactly like real ones so that a
+// lockout doesn't reveal which accounts exist. Like the rate limiters it is
+// per process.
+type loginLockout struct {
+	cfg LockoutConfig
+
+	mu       sync.Mutex
+	accounts map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count int
+	// since is when the first failure counted in count happened.
+	since       time.Time
+	lockedUntil time.Time
+}
+
+// newLoginLockout returns an empty loginLockout. Entries whose window and
+// lockout have both passed are garbage-collected in the background.
+func newLoginLockout(cfg LockoutConfig) *loginLockout {
+	l := &loginLockout{cfg: cfg, accounts: make(map[string]*loginFailures)}
+	go l.collectGarbage(cfg.Window)
+	return l
+}
+
+// locked reports whether username is locked out, and for how much longer.
+func (l *loginLockout) locked(username string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.accounts[username]
+	if !ok {
+		return 0, false
+	}
+	if wait := time.Until(f.lockedUntil); wait > 0 {
+		return wait, true
+	}
+	return 0, false
+}
+
+// fail records a failed login for username. If it is the one that reaches
+// the threshold, the account is locked and fail reports for how long.
+func (l *loginLockout) fail(username string) (time.Duration, bool) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, ok := l.accounts[username]
+	if !ok || now.Sub(f.since) > l.cfg.Window || (!f.lockedUntil.IsZero() && !now.Before(f.lockedUntil)) {
+		f = &loginFailures{since: now}
+		l.accounts[username] = f
+	}
+	f.count++
+	if f.count < l.cfg.Threshold {
+		return 0, false
+	}
+	f.lockedUntil = now.Add(l.cfg.Duration)
+	return l.cfg.Duration, true
+}
+
+// succeed clears username's failures after a successful login.
+func (l *loginLockout) succeed(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.accounts, username)
+}
+
+func (l *loginLockout) collectGarbage(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		now := time.Now()
+		l.mu.Lock()
+		for name, f := range l.accounts {
+			if now.Sub(f.since) > l.cfg.Window && !now.Before(f.lockedUntil) {
+				delete(l.accounts, name)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// writeLockedOut writes the 429 sent while an account is locked.
+func writeLockedOut(w http.ResponseWriter, wait time.Duration) {
+	secs := int(math.Ceil(wait.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	writeError(w, http.StatusTooManyRequests, CodeRateLimited, "too many failed logins, try again later")
+}
@@ -1,37 +1,428 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
 	"time"
 
+	"github.com/alexedwards/scs/postgresstore"
+	"github.com/alexedwards/scs/redisstore"
 	"github.com/alexedwards/scs/v2"
 )
 
 var sessionManager *scs.SessionManager
 
+var taskRepo TaskRepository
+
+var userStore UserStore
+
+var workspaces WorkspaceStore
+
+var apiKeys APIKeyStore
+
+var templates TemplateStore
+
+var tagColorStore TagColorStore
+
+var webhooks WebhookStore
+
+var blobStore BlobStore
+
+var taskHistory HistoryStore
+
+var uniqueTitles *UniqueTitleTaskRepo
+
+var idempotencyKeys *idempotencyStore
+
+var taskEvents *Hub
+
+var auditLog *AuditLog
+
+var recurringTasks *recurrenceWorker
+
+var webhookDispatch *webhookDispatcher
+
 func main() {
+	// Log everything, including the standard library logger, as JSON lines.
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	logger = slog.New(newRedactingHandler(logger.Handler(), cfg.Log.RedactFields))
+	slog.SetDefault(logger)
+
+	// Set up first so that a signal also aborts waiting for the stores.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv, cleanup, err := newServer(ctx, cfg)
+	if errors.Is(err, context.Canceled) && ctx.Err() != nil {
+		log.Printf("Startup aborted: %v", err)
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to initialize server: %v", err)
+	}
+	defer cleanup()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("Server starting", "port", cfg.Port, "version", currentBuildInfo.Version, "commit", currentBuildInfo.Commit)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+		return
+	case <-ctx.Done():
+	}
+	// Restore default signal handling so a second signal kills the process.
+	stop()
+
+	// Fail readiness first and keep serving for a while, so that load
+	// balancers stop sending requests before the listener closes.
+	draining.Store(true)
+	srv.SetKeepAlivesEnabled(false)
+	if cfg.ShutdownDrainDelay > 0 {
+		log.Printf("Draining, failing /readyz for %s before shutting down", cfg.ShutdownDrainDelay)
+		select {
+		case err := <-serverErr:
+			log.Fatalf("Server failed: %v", err)
+		case <-time.After(cfg.ShutdownDrainDelay):
+		}
+	}
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", cfg.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Shutdown did not complete cleanly: %v", err)
+		return
+	}
+	log.Println("Server stopped cleanly")
+}
+
+// newServer initializes the session manager and stores described by cfg and
+// returns an HTTP server ready to listen. Connecting to the stores is
+// retried until ctx is done. The cleanup function releases the backend
+// connections and must be called once the server has shut down.
+func newServer(ctx context.Context, cfg *Config) (*http.Server, func(), error) {
+	var closers []func() error
+	cleanup := func() {
+		// Close in reverse order so that dependents go before what they use.
+		for i := len(closers) - 1; i >= 0; i-- {
+			if err := closers[i](); err != nil {
+				log.Printf("Closing backend: %v", err)
+			}
+		}
+	}
+
 	// Initialize session manager
 	sessionManager = scs.New()
-	sessionManager.Lifetime = 24 * time.Hour // Session expires after 24 hours
-	// For production, you would typically use a persistent store like Redis or a database.
-	// For now, we'll use the default in-memory store, which is not suitable for multi-instance deployments.
-	// sessionManager.Store = redisstore.New(redisClient) // Example for Redis
+	cfg.Session.apply(sessionManager)
+	// scs reports session store failures with a plain-text 500 by default.
+	sessionManager.ErrorFunc = sessionStoreError
+	taskRepo = NewMemoryTaskRepo(cfg.MemoryMaxTasks)
+	userStore = NewMemoryUserStore()
+	workspaces = NewMemoryWorkspaceStore()
+	apiKeys = NewMemoryAPIKeyStore()
+	templates = NewMemoryTemplateStore()
+	tagColorStore = NewMemoryTagColorStore()
+	webhooks = NewMemoryWebhookStore()
+	idempotencyKeys = newIdempotencyStore(idempotencyKeyTTL)
+	taskEvents = NewHub()
+
+	// By default we use the in-memory store, which is not suitable for multi-instance deployments.
+	// Set STORE_BACKEND=sqlite to persist sessions in a local SQLite file,
+	// STORE_BACKEND=redis to share them between instances, or
+	// STORE_BACKEND=postgres to keep both sessions and tasks in PostgreSQL.
+	switch cfg.StoreBackend {
+	case "memory":
+		// Keep the scs default (memstore).
+		if cfg.MemoryMaxSessions > 0 {
+			sessionManager.Store = newCappedSessionStore(sessionManager.Store.(iterableSessionStore), cfg.MemoryMaxSessions)
+		}
+	case "sqlite":
+		store, err := NewSQLiteStore(cfg.SessionDBPath, 5*time.Minute)
+		if err != nil {
+			return nil, nil, fmt.Errorf("opening SQLite session store at %s: %w", cfg.SessionDBPath, err)
+		}
+		closers = append(closers, store.Close)
+		sessionManager.Store = store
+	case "redis":
+		pool, err := newRedisPool(ctx, cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, cfg.StoreRetry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("initializing Redis session store: %w", err)
+		}
+		closers = append(closers, pool.Close)
+		sessionManager.Store = redisstore.New(pool)
+	case "postgres":
+		db, err := openPostgres(ctx, cfg.DatabaseURL, cfg.DBMaxOpenConns, cfg.DBMaxIdleConns, cfg.DBConnMaxLifetime, cfg.StoreRetry)
+		if err != nil {
+			return nil, nil, fmt.Errorf("connecting to PostgreSQL: %w", err)
+		}
+		closers = append(closers, db.Close)
+		if cfg.MigrateOnStart {
+			err = runMigrations(ctx, db)
+		} else {
+			err = checkMigrations(ctx, db)
+		}
+		if err != nil {
+			cleanup()
+			return nil, nil, err
+		}
+		taskRepo = NewPostgresTaskRepo(db)
+		store := postgresstore.New(db)
+		closers = append(closers, func() error { store.StopCleanup(); return nil })
+		sessionManager.Store = store
+	}
+
+	blobs, err := NewFSBlobStore(cfg.Attachments.Dir)
+	if err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("opening attachment directory %s: %w", cfg.Attachments.Dir, err)
+	}
+	blobStore = blobs
+
+	if cfg.StartupSelfCheck {
+		if err := selfCheck(sessionManager.Store, taskRepo, blobStore); err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("startup self-check failed: %w", err)
+		}
+	}
+
+	maintenanceOn.Store(cfg.MaintenanceMode)
+	defaultTaskQuota = cfg.TaskQuota
+	taskTimeFormat = cfg.TimeFormat
+	if cfg.CursorSecret != "" {
+		cursorKey = []byte(cfg.CursorSecret)
+	}
+
+	// Wrapped before the workers start so that their changes are recorded
+	// too.
+	taskHistory = NewMemoryHistoryStore(cfg.HistorySize)
+	uniqueTitles = NewUniqueTitleTaskRepo(taskRepo, userStore, workspaces)
+	taskRepo = uniqueTitles
+	taskRepo = NewHistoryTaskRepo(taskRepo, taskHistory)
+	taskRepo = NewEventTaskRepo(taskRepo, taskEvents)
+
+	// requireAuth loads the user on every request; the cache wraps whichever
+	// store was chosen above.
+	if cfg.UserCache.Size > 0 {
+		userStore = NewCachedUserStore(userStore, cfg.UserCache.Size, cfg.UserCache.TTL)
+	}
+
+	if err := seedAdmin(context.Background(), userStore, cfg.AdminUsername, cfg.AdminPassword); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("seeding admin user: %w", err)
+	}
+
+	var auditSinks []AuditSink
+	if cfg.AuditLogFile != "" {
+		sink, err := NewFileAuditSink(cfg.AuditLogFile)
+		if err != nil {
+			cleanup()
+			return nil, nil, fmt.Errorf("opening audit log: %w", err)
+		}
+		closers = append(closers, sink.Close)
+		auditSinks = append(auditSinks, sink)
+	}
+	// Stopped before the file sink is closed, since closers run in reverse.
+	auditLog = startAuditLog(auditSinks...)
+	closers = append(closers, func() error { auditLog.stop(); return nil })
+
+	recurringTasks = startRecurrenceWorker(taskRepo)
+	closers = append(closers, func() error { recurringTasks.stop(); return nil })
+	webhookDispatch = startWebhookDispatcher(cfg.Webhooks, webhooks)
+	taskEvents.Observe(webhookDispatch.notify)
+	closers = append(closers, func() error { webhookDispatch.stop(); return nil })
+	stopPurger := startTrashPurger(taskRepo, cfg.TrashRetention, time.Hour)
+	closers = append(closers, func() error { stopPurger(); return nil })
+	// Attachments of tasks removed in bulk are swept up with the trash.
+	stopSweeper := startAttachmentSweeper(taskRepo, blobStore, time.Hour)
+	closers = append(closers, func() error { stopSweeper(); return nil })
+	if notifier := newNotifier(cfg.Reminders); notifier != nil {
+		stopReminders := startReminderWorker(taskRepo, userStore, notifier, cfg.Reminders.LeadTime, cfg.Reminders.Interval)
+		closers = append(closers, func() error { stopReminders(); return nil })
+	}
+
+	srv := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: newRouter(cfg),
+	}
+	// Long-lived event streams would otherwise keep Shutdown waiting.
+	srv.RegisterOnShutdown(taskEvents.Close)
+	return srv, cleanup, nil
+}
+
+// newRouter builds the full handler chain on a fresh ServeMux so that it can
+// be exercised with httptest without touching http.DefaultServeMux. The
+// session manager and stores must already be initialized.
+func newRouter(cfg *Config) http.Handler {
+	// The streaming endpoints are long-lived by design, so they are exempt
+	// from the request timeout and from compression.
+	streams := []string{"/tasks/events", "/ws"}
+	// Downloads and exports stream their bodies too, which the request
+	// timeout would buffer whole.
+	untimed := func(r *http.Request) bool {
+		return slices.Contains(streams, r.URL.Path) || r.URL.Path == "/me/export" || isAttachmentDownload(r)
+	}
+	// Profiles and traces run for as long as they are asked to.
+	slowByDesign := append([]string{"/debug/pprof/profile", "/debug/pprof/trace"}, streams...)
+	apiLimiter := newIPRateLimiter(cfg.RateLimit, 10*time.Minute)
+	loginLimiter := newIPRateLimiter(cfg.LoginRateLimit, 10*time.Minute)
+
+	// Every route is recorded so that unknown paths get a 404 and known ones
+	// a 405 for other methods, whichever mux they are on.
+	routes := newRouteTable()
+	mux := routes.newMux()
+	mux.HandleFunc("GET /{$}", homeHandler)
+	mux.HandleFunc("/set-session", setSessionHandler)
+	mux.HandleFunc("/get-session", getSessionHandler)
+
+	mux.HandleFunc("GET /csrf-token", csrfTokenHandler)
+	mux.Handle("POST /login", loginLimiter.middleware(loginHandler(newLoginLockout(cfg.LoginLockout))))
+	mux.HandleFunc("POST /logout", logoutHandler)
+	mux.Handle("POST /register", registerHandler(cfg.PasswordPolicy))
+	if cfg.GoogleOAuth.enabled() {
+		google := cfg.GoogleOAuth.oauth2()
+		mux.Handle("GET /auth/google/login", loginLimiter.middleware(googleLoginHandler(google)))
+		mux.Handle("GET /auth/google/callback", loginLimiter.middleware(googleCallbackHandler(google)))
+	}
+
+	// Task routes are grouped on their own mux so that requireAuth and the
+	// workspace selection cover every one of them, including routes added
+	// later.
+	tasks := routes.newMux()
+	tasks.HandleFunc("POST /tasks", createTaskHandler)
+	tasks.Handle("POST /tasks/bulk", bulkCreateTasksHandler(cfg.BulkMaxTasks))
+	tasks.Handle("POST /tasks/batch/done", batchDoneHandler(cfg.BulkMaxTasks))
+	tasks.HandleFunc("POST /tasks/from-template/{templateID}", createTaskFromTemplateHandler)
+	tasks.HandleFunc("POST /tasks/reorder", reorderTasksHandler)
+	tasks.HandleFunc("GET /tasks", listTasksHandler)
+	tasks.HandleFunc("GET /tasks/count", countTasksHandler)
+	tasks.HandleFunc("GET /tasks/search", searchTasksHandler)
+	tasks.Handle("GET /tasks/calendar", calendarHandler(cfg.CalendarMaxDays))
+	tasks.HandleFunc("GET /tasks/trash", listTrashHandler)
+	tasks.HandleFunc("GET /tasks/archived", listArchivedHandler)
+	tasks.HandleFunc("GET /tasks/events", taskEventsHandler)
+	tasks.HandleFunc("GET /tasks/{id}", getTaskHandler)
+	tasks.HandleFunc("PUT /tasks/{id}", updateTaskHandler)
+	tasks.HandleFunc("PATCH /tasks/{id}", patchTaskHandler)
+	tasks.HandleFunc("DELETE /tasks/{id}", deleteTaskHandler)
+	// A task's actions and collections share one route so that
+	// /tasks/from-template/{templateID} can sit beside them (see subRoutes).
+	task := tasks.subRoutes("/tasks/{id}/{sub}")
+	task.HandleFunc("POST", "restore", restoreTaskHandler)
+	task.HandleFunc("POST", "archive", archiveTaskHandler)
+	task.HandleFunc("POST", "unarchive", unarchiveTaskHandler)
+	task.HandleFunc("POST", "duplicate", duplicateTaskHandler)
+	task.HandleFunc("POST", "assign", assignTaskHandler)
+	task.HandleFunc("POST", "subtasks", createSubtaskHandler)
+	tasks.HandleFunc("PATCH /tasks/{id}/subtasks/{subID}", patchSubtaskHandler)
+	tasks.HandleFunc("DELETE /tasks/{id}/subtasks/{subID}", deleteSubtaskHandler)
+	task.Handle("POST", "attachments", uploadAttachmentHandler(cfg.Attachments))
+	tasks.HandleFunc("GET /tasks/{id}/attachments/{attID}", downloadAttachmentHandler)
+	tasks.HandleFunc("DELETE /tasks/{id}/attachments/{attID}", deleteAttachmentHandler)
+	task.HandleFunc("POST", "comments", createCommentHandler)
+	task.HandleFunc("GET", "comments", listCommentsHandler)
+	tasks.HandleFunc("DELETE /tasks/{id}/comments/{commentID}", deleteCommentHandler)
+	task.HandleFunc("GET", "history", taskHistoryHandler)
+	tasks.HandleFunc("GET /tags", listTagsHandler)
+	tasks.HandleFunc("GET /tags/palette", tagPaletteHandler)
+	tasks.HandleFunc("PUT /tags/{name}/color", setTagColorHandler)
+	authed := requireAuth(selectWorkspace(tasks))
+	mux.mount("/tasks", authed)
+	mux.mount("/tasks/", authed)
+	mux.mount("/tags", authed)
+	mux.mount("/tags/", authed)
+	mux.Handle("GET /me", requireAuth(http.HandlerFunc(meHandler)))
+	// Changing the password checks the current one, so it gets the login limit.
+	mux.Handle("POST /me/password", loginLimiter.middleware(requireAuth(changePasswordHandler(cfg.PasswordPolicy))))
+	mux.Handle("GET /me/sessions", requireAuth(http.HandlerFunc(listSessionsHandler)))
+	mux.Handle("DELETE /me/sessions/{id}", requireAuth(http.HandlerFunc(revokeSessionHandler)))
+	mux.Handle("GET /me/export", requireAuth(http.HandlerFunc(exportTasksHandler)))
+	mux.Handle("POST /me/import", requireAuth(http.HandlerFunc(importTasksHandler)))
+	mux.Handle("POST /me/api-keys", requireAuth(http.HandlerFunc(createAPIKeyHandler)))
+	mux.Handle("GET /me/api-keys", requireAuth(http.HandlerFunc(listAPIKeysHandler)))
+	mux.Handle("DELETE /me/api-keys/{id}", requireAuth(http.HandlerFunc(revokeAPIKeyHandler)))
+	mux.Handle("POST /me/webhooks", requireAuth(http.HandlerFunc(createWebhookHandler)))
+	mux.Handle("GET /me/webhooks", requireAuth(http.HandlerFunc(listWebhooksHandler)))
+	mux.Handle("DELETE /me/webhooks/{id}", requireAuth(http.HandlerFunc(deleteWebhookHandler)))
+	mux.Handle("GET /me/webhooks/deliveries", requireAuth(http.HandlerFunc(listWebhookDeliveriesHandler)))
+	mux.Handle("PUT /me/workspace", requireAuth(http.HandlerFunc(setActiveWorkspaceHandler)))
+	mux.Handle("GET /me/settings", requireAuth(http.HandlerFunc(getSettingsHandler)))
+	mux.Handle("PUT /me/settings", requireAuth(http.HandlerFunc(updateSettingsHandler)))
+	mux.Handle("GET /ws", requireAuth(wsHandler(cfg.CORSAllowedOrigins)))
+	mux.Handle("POST /templates", requireAuth(http.HandlerFunc(createTemplateHandler)))
+	mux.Handle("GET /templates", requireAuth(http.HandlerFunc(listTemplatesHandler)))
+	mux.Handle("GET /templates/{id}", requireAuth(http.HandlerFunc(getTemplateHandler)))
+	mux.Handle("DELETE /templates/{id}", requireAuth(http.HandlerFunc(deleteTemplateHandler)))
+	mux.Handle("POST /workspaces", requireAuth(http.HandlerFunc(createWorkspaceHandler)))
+	mux.Handle("GET /workspaces", requireAuth(http.HandlerFunc(listWorkspacesHandler)))
+	mux.Handle("GET /workspaces/{id}/members", requireAuth(http.HandlerFunc(listWorkspaceMembersHandler)))
+	mux.Handle("POST /workspaces/{id}/members", requireAuth(http.HandlerFunc(addWorkspaceMemberHandler)))
+	mux.Handle("DELETE /workspaces/{id}/members/{userID}", requireAuth(http.HandlerFunc(removeWorkspaceMemberHandler)))
+	mux.Handle("PUT /workspaces/{id}/settings", requireAuth(http.HandlerFunc(updateWorkspaceSettingsHandler)))
 
-	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/set-session", setSessionHandler)
-	http.HandleFunc("/get-session", getSessionHandler)
+	admin := routes.newMux()
+	admin.HandleFunc("GET /admin/tasks", adminListTasksHandler)
+	admin.HandleFunc("DELETE /admin/users/{id}", adminDeleteUserHandler)
+	admin.HandleFunc("GET /admin/users/{id}/quota", adminGetTaskQuotaHandler)
+	admin.HandleFunc("PUT /admin/users/{id}/quota", adminSetTaskQuotaHandler)
+	admin.HandleFunc("GET /admin/audit", adminAuditHandler)
+	admin.Handle("GET /admin/stats", adminStatsHandler(cfg.StoreBackend))
+	admin.HandleFunc("GET /admin/maintenance", adminGetMaintenanceHandler)
+	admin.HandleFunc("POST /admin/maintenance", adminSetMaintenanceHandler)
+	mux.mount("/admin/", requireAuth(requireRole(RoleAdmin, admin)))
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Health probes, build info, metrics, API docs and, when enabled, the
+	// profiler are registered on a separate mux in front of the session
+	// middleware so that they never create session cookies.
+	root := routes.newMux()
+	root.HandleFunc("GET /healthz", healthzHandler)
+	root.HandleFunc("GET /readyz", readyzHandler)
+	root.HandleFunc("GET /version", versionHandler)
+	m := newMetrics()
+	root.Handle("GET /metrics", m.handler())
+	root.HandleFunc("GET /openapi.json", openAPIHandler)
+	root.HandleFunc("GET /docs", docsHandler)
+	if cfg.EnablePprof {
+		registerPprof(root)
 	}
+	// Everything else goes through the session middleware, with CSRF checks
+	// on state-changing requests, a cap on body size and a deadline.
+	root.mount("/", timeoutRequests(cfg.RequestTimeout, untimed, localizeErrors(messageCatalog,
+		apiLimiter.middleware(warnForeignHost(cfg.Session.CookieDomain, loadSessions(cfg.Session.StoreFailureMode, csrfProtect(limitRequestBody(cfg.MaxBodyBytes, isAttachmentUpload, mux))))))))
 
-	fmt.Printf("Server starting on port %s\n", port)
-	// Wrap the http.ServeMux with the session middleware
-	log.Fatal(http.ListenAndServe(":"+port, sessionManager.LoadAndSave(http.DefaultServeMux)))
+	// Wrap everything with request logging and metrics so session handling
+	// is covered too. CORS runs before the session middleware so that
+	// preflight requests don't create sessions; compression sits inside it
+	// and covers every response body, including the 404s and 405s of unknown
+	// routes. Maintenance mode sits inside CORS so that browsers can read its
+	// 503s. Panic recovery sits just inside logging so that the panic is
+	// logged with the request ID and the access log shows the 500; slow
+	// requests are reported from the same place. The client IP is resolved
+	// first so that everything after it agrees on who the client is, and
+	// the security headers are set next so that every response carries
+	// them. Error messages are localized from the outside
+	// in, and again beneath the request timeout (see localizeErrors).
+	return localizeErrors(messageCatalog, resolveClientIP(cfg.TrustedProxies, secureHeaders(cfg.SecurityHeaders,
+		logRequests(slog.Default(), cfg.Log, logSlowRequests(slog.Default(), cfg.Log.SlowRequestThreshold, slowByDesign, recoverPanics(slog.Default(), m.middleware(corsMiddleware(cfg.CORSAllowedOrigins, maintenanceMode(cfg.MaintenanceRetryAfter, compressResponses(cfg.Compression, streams, routes.check(root)))))))))))
 }
 
 func homeHandler(w http.ResponseWriter, r *http.Request) {
@@ -40,13 +431,12 @@ func homeHandler(w http.ResponseWriter, r *http.Request) {
 
 func setSessionHandler(w http.ResponseWriter, r *http.Request) {
 	sessionManager.Put(r.Context(), "message", "Hello from session!")
-	sessionManager.Put(r.Context(), "userID", 123)
-	fmt.Fprintf(w, "Session data set: message='Hello from session!', userID=123")
+	fmt.Fprintf(w, "Session data set: message='Hello from session!'")
 }
 
 func getSessionHandler(w http.ResponseWriter, r *http.Request) {
 	message := sessionManager.GetString(r.Context(), "message")
-	userID := sessionManager.GetInt(r.Context(), "userID")
+	userID := sessionUserID(r.Context())
 	if message == "" {
 		fmt.Fprintf(w, "No session data found. Try /set-session first.")
 	} else {
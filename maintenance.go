@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceOn is set while the server is in maintenance mode. It starts
+// from MAINTENANCE_MODE and is flipped by POST /admin/maintenance. It is per
+// process: with several instances each has to be toggled.
+var maintenanceOn atomic.Bool
+
+// maintenanceExempt reports whether path stays reachable in maintenance
+// mode: the health probes, /metrics, /login, /csrf-token and everything
+// under /admin/ and /debug/pprof/. /admin/ is exempt so that an admin can
+// switch maintenance off again.
+func maintenanceExempt(path string) bool {
+	switch path {
+	case "/healthz", "/readyz", "/metrics", "/login", "/csrf-token":
+		return true
+	}
+	return strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/debug/pprof/")
+}
+
+// maintenanceMode answers every request that isn't exempt with a 503 and a
+// Retry-After of retryAfter while maintenance mode is on.
+func maintenanceMode(retryAfter time.Duration, next http.Handler) http.Handler {
+	secs := strconv.Itoa(int(retryAfter.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maintenanceOn.Load() && !maintenanceExempt(r.URL.Path) {
+			w.Header().Set("Retry-After", secs)
+			writeError(w, http.StatusServiceUnavailable, CodeUnavailable, "down for maintenance, please try again shortly")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// maintenanceStatus is the body of GET and POST /admin/maintenance.
+type maintenanceStatus struct {
+	Enabled bool `json:"enabled"`
+}
+
+// maintenanceInput is the body of POST /admin/maintenance.
+type maintenanceInput struct {
+	Enabled *bool `json:"enabled"`
+}
+
+func adminGetMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	encode(w, r, http.StatusOK, maintenanceStatus{Enabled: maintenanceOn.Load()})
+}
+
+// adminSetMaintenanceHandler switches maintenance mode on or off.
+func adminSetMaintenanceHandler(w http.ResponseWriter, r *http.Request) {
+	var in maintenanceInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.Enabled == nil {
+		writeValidationErrors(w, validationErrors{"enabled": "required"}, -1)
+		return
+	}
+	admin := currentUser(r.Context()).ID
+	if maintenanceOn.Swap(*in.Enabled) != *in.Enabled {
+		detail := "maintenance_off"
+		if *in.Enabled {
+			detail = "maintenance_on"
+		}
+		slog.Warn("maintenance mode changed", "enabled", *in.Enabled, "admin_id", admin)
+		auditLog.recordDetail(r, admin, AuditAdminAction, "", detail)
+	}
+	encode(w, r, http.StatusOK, maintenanceStatus{Enabled: *in.Enabled})
+}
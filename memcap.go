@@ -0,0 +1,107 @@
+package main
+
+import (
+	"container/list"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// evictionWarning tells operators, once, that an in-memory store outgrew
+// its cap.
+type evictionWarning struct {
+	once sync.Once
+}
+
+func (w *evictionWarning) warn(store string, maxEntries int) {
+	w.once.Do(func() {
+		slog.Warn("in-memory store is full, evicting old entries; use a persistent backend", "store", store, "max_entries", maxEntries)
+	})
+}
+
+// iterableSessionStore is a session store that can list its sessions, as
+// scs's memstore can.
+type iterableSessionStore interface {
+	scs.Store
+	scs.IterableStore
+}
+
+// cappedSessionStore keeps at most maxEntries sessions in an in-memory
+// session store, deleting the least recently used ones beyond that. Their
+// users are logged out.
+type cappedSessionStore struct {
+	iterableSessionStore
+	maxEntries int
+
+	mu     sync.Mutex
+	tokens map[string]*list.Element
+	// lru holds tokens, most recently used first.
+	lru     *list.List
+	warning evictionWarning
+}
+
+// newCappedSessionStore wraps store so that it holds at most maxEntries
+// sessions.
+func newCappedSessionStore(store iterableSessionStore, maxEntries int) *cappedSessionStore {
+	return &cappedSessionStore{iterableSessionStore: store, maxEntries: maxEntries, tokens: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (s *cappedSessionStore) Find(token string) ([]byte, bool, error) {
+	b, found, err := s.iterableSessionStore.Find(token)
+	if err != nil {
+		return b, found, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.tokens[token]; ok {
+		if found {
+			s.lru.MoveToFront(el)
+		} else {
+			// Expired, and removed by the store's cleanup sooner or later.
+			s.lru.Remove(el)
+			delete(s.tokens, token)
+		}
+	}
+	return b, found, nil
+}
+
+func (s *cappedSessionStore) Commit(token string, b []byte, expiry time.Time) error {
+	if err := s.iterableSessionStore.Commit(token, b, expiry); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.tokens[token]; ok {
+		s.lru.MoveToFront(el)
+		return nil
+	}
+	s.tokens[token] = s.lru.PushFront(token)
+	// A session leaves the list only once the store has deleted it. One
+	// the store fails to delete is logged and stays, to be tried again by
+	// the next Commit, and the next oldest goes instead.
+	for el := s.lru.Back(); s.lru.Len() > s.maxEntries && el != s.lru.Front(); {
+		prev := el.Prev()
+		oldest := el.Value.(string)
+		if err := s.iterableSessionStore.Delete(oldest); err != nil {
+			slog.Error("evicting session failed", "error", err)
+		} else {
+			s.lru.Remove(el)
+			delete(s.tokens, oldest)
+			s.warning.warn("sessions", s.maxEntries)
+		}
+		el = prev
+	}
+	return nil
+}
+
+func (s *cappedSessionStore) Delete(token string) error {
+	s.mu.Lock()
+	if el, ok := s.tokens[token]; ok {
+		s.lru.Remove(el)
+		delete(s.tokens, token)
+	}
+	s.mu.Unlock()
+	return s.iterableSessionStore.Delete(token)
+}
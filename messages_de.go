@@ -0,0 +1,101 @@
+package main
+
+// germanMessages are the German translations of error and validation
+// messages, keyed by their English text or format (see formatCatalog).
+var germanMessages = map[string]string{
+	// Standard messages.
+	"internal server error":                          "interner Serverfehler",
+	"not logged in":                                  "nicht angemeldet",
+	"insufficient permissions":                       "unzureichende Berechtigungen",
+	"missing or invalid CSRF token":                  "CSRF-Token fehlt oder ist ungültig",
+	"no such endpoint":                               "unbekannter Endpunkt",
+	"method %s not allowed":                          "Methode %s nicht erlaubt",
+	"rate limit exceeded":                            "Anfragelimit überschritten",
+	"down for maintenance, please try again shortly": "wegen Wartungsarbeiten nicht verfügbar, bitte versuchen Sie es gleich noch einmal",
+	"invalid API key":                                "ungültiger API-Schlüssel",
+	"invalid username or password":                   "ungültiger Benutzername oder ungültiges Passwort",
+	"too many failed logins, try again later":        "zu viele fehlgeschlagene Anmeldungen, bitte später erneut versuchen",
+	"current password is incorrect":                  "das aktuelle Passwort ist falsch",
+	"username must not be empty":                     "der Benutzername darf nicht leer sein",
+	"username already taken":                         "der Benutzername ist bereits vergeben",
+	"password does not meet the password policy":     "das Passwort erfüllt die Passwortrichtlinie nicht",
+
+	// Request bodies.
+	"request body failed validation":                "der Anfrageinhalt ist ungültig",
+	"malformed JSON body":                           "fehlerhafter JSON-Inhalt",
+	"malformed JSON body (at character %d)":         "fehlerhafter JSON-Inhalt (bei Zeichen %s)",
+	"invalid JSON type (at character %d)":           "ungültiger JSON-Typ (bei Zeichen %s)",
+	"invalid type for field %q":                     "ungültiger Typ für das Feld %s",
+	"unknown field %s":                              "unbekanntes Feld %s",
+	"request body must not be empty":                "der Anfrageinhalt darf nicht leer sein",
+	"request body must contain a single JSON value": "der Anfrageinhalt muss genau einen JSON-Wert enthalten",
+	"request body too large (limit %d bytes)":       "der Anfrageinhalt ist zu groß (höchstens %s Bytes)",
+	"merge patch must be a JSON object":             "ein Merge-Patch muss ein JSON-Objekt sein",
+
+	// Not found.
+	"task not found":       "Aufgabe nicht gefunden",
+	"subtask not found":    "Teilaufgabe nicht gefunden",
+	"comment not found":    "Kommentar nicht gefunden",
+	"attachment not found": "Anhang nicht gefunden",
+	"template not found":   "Vorlage nicht gefunden",
+	"workspace not found":  "Arbeitsbereich nicht gefunden",
+	"member not found":     "Mitglied nicht gefunden",
+	"user not found":       "Benutzer nicht gefunden",
+	"webhook not found":    "Webhook nicht gefunden",
+	"session not found":    "Sitzung nicht gefunden",
+	"api key not found":    "API-Schlüssel nicht gefunden",
+
+	// Tasks.
+	"task ID must be 32 lowercase hex characters":                                        "eine Aufgaben-ID besteht aus 32 hexadezimalen Kleinbuchstaben",
+	"task belongs to another user":                                                       "die Aufgabe gehört einem anderen Benutzer",
+	"task is not in the active workspace":                                                "die Aufgabe gehört nicht zum aktiven Arbeitsbereich",
+	"task is not in the trash":                                                           "die Aufgabe ist nicht im Papierkorb",
+	"a task with this title already exists":                                              "es gibt bereits eine Aufgabe mit diesem Titel",
+	"some tasks already share a title; rename them first":                                "einige Aufgaben haben bereits denselben Titel; benennen Sie sie zuerst um",
+	"task is already archived":                                                           "die Aufgabe ist bereits archiviert",
+	"task is not archived":                                                               "die Aufgabe ist nicht archiviert",
+	"task has been modified since it was fetched":                                        "die Aufgabe wurde seit dem Abruf geändert",
+	"a listed task does not exist or is not yours":                                       "eine der Aufgaben existiert nicht oder gehört Ihnen nicht",
+	"a task can have at most %d subtasks":                                                "eine Aufgabe kann höchstens %s Teilaufgaben haben",
+	"a task can have at most %d attachments":                                             "eine Aufgabe kann höchstens %s Anhänge haben",
+	"at most %d tasks can be created at once, got %d":                                    "es können höchstens %s Aufgaben auf einmal angelegt werden, erhalten: %s",
+	"at most %d tasks can be updated at once, got %d":                                    "es können höchstens %s Aufgaben auf einmal geändert werden, erhalten: %s",
+	"request body must be a non-empty array of tasks":                                    "der Anfrageinhalt muss eine nicht leere Liste von Aufgaben sein",
+	"%s must be an RFC3339 timestamp":                                                    "%s muss ein RFC3339-Zeitstempel sein",
+	"priority must be one of low, medium, high, urgent":                                  "priority muss low, medium, high oder urgent sein",
+	"limit must be an integer between 1 and %d":                                          "limit muss eine ganze Zahl zwischen 1 und %s sein",
+	"offset must be a non-negative integer":                                              "offset muss eine nicht negative ganze Zahl sein",
+	"user id must be a positive integer":                                                 "die Benutzer-ID muss eine positive ganze Zahl sein",
+	"from and to are required, as YYYY-MM-DD":                                            "from und to sind erforderlich, als JJJJ-MM-TT",
+	"to must not be before from":                                                         "to darf nicht vor from liegen",
+	"the range can span at most %d days":                                                 "der Zeitraum darf höchstens %s Tage umfassen",
+	"q must not be empty":                                                                "q darf nicht leer sein",
+	"task quota exceeded: a user can own at most %d tasks, including those in the trash": "Aufgabenkontingent erschöpft: ein Benutzer kann höchstens %s Aufgaben besitzen, einschließlich derer im Papierkorb",
+
+	// Workspaces.
+	"not a member of this workspace":                     "kein Mitglied dieses Arbeitsbereichs",
+	"not a member of workspace %d":                       "kein Mitglied des Arbeitsbereichs %s",
+	"user is already a member of this workspace":         "der Benutzer ist bereits Mitglied dieses Arbeitsbereichs",
+	"user is not a member of the task's workspace":       "der Benutzer ist kein Mitglied des Arbeitsbereichs der Aufgabe",
+	"only the workspace's owner can change its settings": "nur der Eigentümer des Arbeitsbereichs kann seine Einstellungen ändern",
+
+	// Validation of fields.
+	"required":                                       "erforderlich",
+	"is required":                                    "ist erforderlich",
+	"must not be null":                               "darf nicht null sein",
+	"duplicate":                                      "doppelt",
+	"must be at most %d characters":                  "darf höchstens %s Zeichen lang sein",
+	"must have at most %d entries":                   "darf höchstens %s Einträge haben",
+	"must be %d to %d characters":                    "muss %s bis %s Zeichen lang sein",
+	"must be a hex color such as #1e90ff":            "muss eine Hex-Farbe wie #1e90ff sein",
+	"must be an absolute http or https URL":          "muss eine absolute http- oder https-URL sein",
+	"must not contain credentials":                   "darf keine Zugangsdaten enthalten",
+	"must have a filename":                           "muss einen Dateinamen haben",
+	"must be non-negative, or null for the default":  "darf nicht negativ sein, oder null für den Standardwert",
+	"must be at least %d characters":                 "muss mindestens %s Zeichen lang sein",
+	"must be at most %d bytes":                       "darf höchstens %s Bytes lang sein",
+	"must contain both upper and lower case letters": "muss Groß- und Kleinbuchstaben enthalten",
+	"must contain a digit":                           "muss eine Ziffer enthalten",
+	"must contain a symbol":                          "muss ein Sonderzeichen enthalten",
+	"is too common":                                  "ist zu verbreitet",
+}
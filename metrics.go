@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metrics holds the Prometheus collectors for the HTTP server. Each router
+// gets its own registry so that building several in tests doesn't panic on
+// duplicate registration.
+type metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "tms_http_requests_total",
+			Help: "Total HTTP requests by method, path and status code.",
+		}, []string{"method", "path", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "tms_http_request_duration_seconds",
+			Help:    "HTTP request latency by method and path.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "path"}),
+	}
+	m.registry.MustRegister(
+		m.requests,
+		m.duration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "tms_active_sessions",
+			Help: "Number of unexpired sessions in the session store (-1 if the store can't be enumerated).",
+		}, countActiveSessions),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "tms_user_cache_hits_total",
+			Help: "User lookups by ID served from the user cache. Both user cache counters stay at 0 while it is disabled.",
+		}, func() float64 { hits, _ := userCacheStats(); return float64(hits) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "tms_user_cache_misses_total",
+			Help: "User lookups by ID the user cache passed on to the user store.",
+		}, func() float64 { _, misses := userCacheStats(); return float64(misses) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// handler serves the registry in the Prometheus exposition format.
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// middleware records the request count and latency of every request except
+// scrapes of /metrics itself.
+func (m *metrics) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/metrics" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		path := metricsPath(r.URL.Path)
+		m.requests.WithLabelValues(r.Method, path, strconv.Itoa(rec.status)).Inc()
+		m.duration.WithLabelValues(r.Method, path).Observe(time.Since(start).Seconds())
+	})
+}
+
+var idSegment = regexp.MustCompile(`^([0-9a-f]{32}|[0-9]+)$`)
+
+// metricsPath replaces ID-like path segments with "{id}" so that the path
+// label has bounded cardinality.
+func metricsPath(path string) string {
+	segs := strings.Split(path, "/")
+	for i, s := range segs {
+		if idSegment.MatchString(s) {
+			segs[i] = "{id}"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+// userCacheStats returns the hit and miss counts of the user cache, or zeros
+// if it is disabled.
+func userCacheStats() (hits, misses uint64) {
+	if c, ok := userStore.(*CachedUserStore); ok {
+		return c.stats()
+	}
+	return 0, 0
+}
+
+func countActiveSessions() float64 {
+	store, ok := sessionManager.Store.(scs.IterableStore)
+	if !ok {
+		return -1
+	}
+	all, err := store.All()
+	if err != nil {
+		return -1
+	}
+	return float64(len(all))
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	userKey
+	apiKeyKey
+	clientIPKey
+	workspaceKey
+	sessionLoadKey
+)
+
+// requestIDFromContext returns the request ID assigned by logRequests, or ""
+// outside of a request.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// statusRecorder captures the status code written by the wrapped handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// LogConfig controls the request log.
+type LogConfig struct {
+	// Bodies adds the request and response bodies to each request line.
+	// Only JSON bodies of at most BodyMaxBytes, after redaction, are logged;
+	// others are logged by size only, and so are compressed responses.
+	Bodies       bool
+	BodyMaxBytes int
+	// RedactFields are the field names, in any log line and at any depth
+	// of a logged body, whose values are replaced with "***".
+	RedactFields []string
+	// SlowRequestThreshold logs a warning for every request taking longer;
+	// 0 disables it.
+	SlowRequestThreshold time.Duration
+}
+
+// bodyCapture keeps the first max bytes written to it and counts the rest.
+type bodyCapture struct {
+	buf bytes.Buffer
+	max int
+	n   int
+}
+
+func (c *bodyCapture) Write(p []byte) (int, error) {
+	if room := c.max - c.buf.Len(); room > 0 {
+		c.buf.Write(p[:min(len(p), room)])
+	}
+	c.n += len(p)
+	return len(p), nil
+}
+
+// attr returns the log attribute for a captured body: the redacted JSON if
+// all of it was captured and it parses, otherwise only its size, so that a
+// body that can't be redacted is never logged.
+func (c *bodyCapture) attr(key string, red redactor) slog.Attr {
+	if c.n == c.buf.Len() {
+		if doc, ok := red.json(c.buf.Bytes()); ok {
+			return slog.Any(key, json.RawMessage(doc))
+		}
+	}
+	return slog.Int(key+"_bytes", c.n)
+}
+
+// isJSONMediaType reports whether a Content-Type header names JSON, such as
+// application/json or application/merge-patch+json.
+func isJSONMediaType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
+
+// bodyRecorder is a statusRecorder that also captures the response body,
+// unless it is compressed or not JSON.
+type bodyRecorder struct {
+	statusRecorder
+	body *bodyCapture
+	skip bool
+}
+
+func (rec *bodyRecorder) WriteHeader(code int) {
+	h := rec.Header()
+	if rec.status == 0 && (h.Get("Content-Encoding") != "" || !isJSONMediaType(h.Get("Content-Type"))) {
+		rec.skip = true
+	}
+	rec.statusRecorder.WriteHeader(code)
+}
+
+func (rec *bodyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.WriteHeader(http.StatusOK)
+	}
+	if !rec.skip {
+		rec.body.Write(b)
+	}
+	return rec.statusRecorder.Write(b)
+}
+
+// loggedBody is a request body that is copied to a capture as it is read.
+type loggedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// logRequests assigns every request a random ID, exposes it in the
+// X-Request-ID response header and logs one JSON line per request once the
+// handler has finished, with the bodies if cfg asks for them. Sensitive
+// fields are redacted here, so no handler can get them into the request log.
+func logRequests(logger *slog.Logger, cfg LogConfig, next http.Handler) http.Handler {
+	red := newRedactor(cfg.RedactFields)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := newRequestID()
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDKey, id))
+
+		var reqBody, respBody *bodyCapture
+		rec := &statusRecorder{ResponseWriter: w}
+		var rw http.ResponseWriter = rec
+		if cfg.Bodies {
+			if r.Body != nil && r.Body != http.NoBody && isJSONMediaType(r.Header.Get("Content-Type")) {
+				reqBody = &bodyCapture{max: cfg.BodyMaxBytes}
+				r.Body = loggedBody{Reader: io.TeeReader(r.Body, reqBody), Closer: r.Body}
+			}
+			respBody = &bodyCapture{max: cfg.BodyMaxBytes}
+			br := &bodyRecorder{statusRecorder: statusRecorder{ResponseWriter: w}, body: respBody}
+			rec, rw = &br.statusRecorder, br
+		}
+		next.ServeHTTP(rw, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Duration("latency", time.Since(start)),
+		}
+		if reqBody != nil {
+			attrs = append(attrs, reqBody.attr("request_body", red))
+		}
+		if respBody != nil && respBody.n > 0 {
+			attrs = append(attrs, respBody.attr("response_body", red))
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request", attrs...)
+	})
+}
+
+// logSlowRequests logs a warning for every request that takes longer than
+// threshold, except those to the paths in exempt, which are slow by design.
+// It must run behind logRequests so that the warning has the request ID. A
+// zero threshold disables the middleware.
+func logSlowRequests(logger *slog.Logger, threshold time.Duration, exempt []string, next http.Handler) http.Handler {
+	if threshold <= 0 {
+		return next
+	}
+	skip := make(map[string]bool, len(exempt))
+	for _, p := range exempt {
+		skip[p] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		next.ServeHTTP(w, r)
+		if d := time.Since(start); d > threshold && !skip[r.URL.Path] {
+			logger.LogAttrs(r.Context(), slog.LevelWarn, "slow request",
+				slog.String("request_id", requestIDFromContext(r.Context())),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.Duration("duration", d),
+				slog.Duration("threshold", threshold),
+			)
+		}
+	})
+}
+
+// recoverPanics turns a panicking handler into a generic 500 and logs the
+// panic value and stack trace with the request ID. The panic message is never
+// sent to the client. http.ErrAbortHandler is re-panicked so that net/http
+// aborts the response silently, as it expects; if the handler had already
+// started the response we abort it the same way, since a 500 can no longer
+// be sent.
+func recoverPanics(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			logger.LogAttrs(r.Context(), slog.LevelError, "panic serving request",
+				slog.String("request_id", requestIDFromContext(r.Context())),
+				slog.String("method", r.Method),
+				slog.String("path", r.URL.Path),
+				slog.String("panic", fmt.Sprint(v)),
+				slog.String("stack", string(debug.Stack())),
+			)
+			if rec.status != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			w.Header().Set("Connection", "close")
+			writeError(w, http.StatusInternalServerError, CodeInternal, "internal server error")
+		}()
+		next.ServeHTTP(rec, r)
+	})
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand never fails on supported platforms; fall back to a timestamp.
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
+
+// limitRequestBody caps every request body at maxBytes. Reads past the limit
+// fail with *http.MaxBytesError, which handlers turn into a 413. Requests for
+// which exempt returns true, file uploads, are left to set their own limit.
+func limitRequestBody(maxBytes int64, exempt func(*http.Request) bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil && !exempt(r) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// timeoutRequests aborts requests that take longer than timeout with a 503.
+// The deadline is set on the request context, so repository calls made with
+// r.Context() are cancelled too. Requests for which exempt returns true,
+// those with streamed responses, which http.TimeoutHandler would buffer
+// whole and can't flush or hijack, are passed through untouched. A zero
+// timeout disables the middleware.
+func timeoutRequests(timeout time.Duration, exempt func(*http.Request) bool, next http.Handler) http.Handler {
+	if timeout <= 0 {
+		return next
+	}
+	th := http.TimeoutHandler(next, timeout, `{"error":{"code":"`+CodeTimeout+`","message":"request timed out"}}`)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		th.ServeHTTP(timeoutResponseWriter{w}, r)
+	})
+}
+
+// timeoutResponseWriter labels the 503 body written by http.TimeoutHandler as
+// JSON. Responses from handlers already carry their own Content-Type by the
+// time WriteHeader is called and are left alone.
+type timeoutResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutResponseWriter) WriteHeader(code int) {
+	if code == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w timeoutResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the pg_advisory_lock key that serialises migration runs
+// when several instances start at once.
+const migrationLockID = 727_001
+
+// migration is one embedded SQL file. Version is the file name without the
+// .sql suffix, e.g. "0001_create_tasks".
+type migration struct {
+	Version string
+	SQL     string
+}
+
+func loadMigrations() ([]migration, error) {
+	names, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	migrations := make([]migration, 0, len(names))
+	for _, name := range names {
+		b, err := migrationFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+		migrations = append(migrations, migration{Version: version, SQL: string(b)})
+	}
+	return migrations, nil
+}
+
+// pendingMigrations returns the embedded migrations not yet recorded in
+// schema_migrations, in the order they must be applied.
+func pendingMigrations(ctx context.Context, q interface {
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+}) ([]migration, error) {
+	all, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[v] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var pending []migration
+	for _, m := range all {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+const createSchemaMigrations = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version    TEXT PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+
+// runMigrations applies every pending migration, each in its own
+// transaction. It stops at the first failure so the schema is never left
+// with a later migration applied on top of a failed one.
+func runMigrations(ctx context.Context, db *sql.DB) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("acquiring migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	if _, err := conn.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	pending, err := pendingMigrations(ctx, conn)
+	if err != nil {
+		return fmt.Errorf("reading applied migrations: %w", err)
+	}
+	if len(pending) == 0 {
+		slog.Info("database schema is up to date")
+		return nil
+	}
+
+	for _, m := range pending {
+		if err := applyMigration(ctx, conn, m); err != nil {
+			return fmt.Errorf("applying migration %s: %w", m.Version, err)
+		}
+		slog.Info("applied migration", "version", m.Version)
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, m.Version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// checkMigrations fails if any embedded migration hasn't been applied. It is
+// used when MIGRATE_ON_START is off so that we never serve requests against
+// an outdated schema.
+func checkMigrations(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, createSchemaMigrations); err != nil {
+		return fmt.Errorf("creating schema_migrations: %w", err)
+	}
+	pending, err := pendingMigrations(ctx, db)
+	if err != nil {
+		return fmt.Errorf("reading applied migrations: %w", err)
+	}
+	if len(pending) > 0 {
+		versions := make([]string, len(pending))
+		for i, m := range pending {
+			versions[i] = m.Version
+		}
+		return fmt.Errorf("database has pending migrations %s; run with MIGRATE_ON_START=true", strings.Join(versions, ", "))
+	}
+	return nil
+}
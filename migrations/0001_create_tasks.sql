@@ -0,0 +1,22 @@
+CREATE TABLE tasks (
+    id           TEXT PRIMARY KEY,
+    owner_id     INTEGER NOT NULL,
+    title        TEXT NOT NULL,
+    description  TEXT NOT NULL DEFAULT '',
+    done         BOOLEAN NOT NULL DEFAULT FALSE,
+    tags         TEXT[] NOT NULL DEFAULT '{}',
+    due_date     TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX tasks_owner_id_idx ON tasks (owner_id);
+
+-- Session table in the layout expected by scs/postgresstore.
+CREATE TABLE sessions (
+    token  TEXT PRIMARY KEY,
+    data   BYTEA NOT NULL,
+    expiry TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX sessions_expiry_idx ON sessions (expiry);
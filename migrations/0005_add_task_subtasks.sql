@@ -0,0 +1,2 @@
+ALTER TABLE tasks ADD COLUMN subtasks JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE tasks ADD COLUMN auto_complete BOOLEAN NOT NULL DEFAULT false;
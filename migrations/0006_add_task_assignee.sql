@@ -0,0 +1,3 @@
+ALTER TABLE tasks ADD COLUMN assignee_id INTEGER;
+
+CREATE INDEX tasks_assignee_id_idx ON tasks (assignee_id) WHERE assignee_id IS NOT NULL;
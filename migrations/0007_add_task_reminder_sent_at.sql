@@ -0,0 +1,5 @@
+ALTER TABLE tasks ADD COLUMN reminder_sent_at TIMESTAMPTZ;
+
+-- The reminder worker scans incomplete live tasks by due date.
+CREATE INDEX tasks_reminder_due_idx ON tasks (due_date)
+	WHERE reminder_sent_at IS NULL AND NOT done AND deleted_at IS NULL;
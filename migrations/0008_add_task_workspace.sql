@@ -0,0 +1,3 @@
+ALTER TABLE tasks ADD COLUMN workspace_id INTEGER;
+
+CREATE INDEX tasks_workspace_id_idx ON tasks (workspace_id) WHERE workspace_id IS NOT NULL;
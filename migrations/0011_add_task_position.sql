@@ -0,0 +1,8 @@
+ALTER TABLE tasks ADD COLUMN position DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- Existing tasks keep their creation order, spaced out like new ones.
+UPDATE tasks SET position = numbered.n * 1024
+FROM (SELECT id, row_number() OVER (PARTITION BY owner_id ORDER BY created_at, id) AS n FROM tasks) AS numbered
+WHERE tasks.id = numbered.id;
+
+CREATE INDEX tasks_owner_id_position_idx ON tasks (owner_id, position);
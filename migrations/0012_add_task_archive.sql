@@ -0,0 +1,5 @@
+ALTER TABLE tasks ADD COLUMN archived BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE tasks ADD COLUMN archived_at TIMESTAMPTZ;
+
+-- Only archived rows are indexed, for listing an owner's archive.
+CREATE INDEX tasks_owner_id_archived_idx ON tasks (owner_id) WHERE archived;
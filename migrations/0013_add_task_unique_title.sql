@@ -0,0 +1,11 @@
+ALTER TABLE tasks ADD COLUMN unique_title BOOLEAN NOT NULL DEFAULT false;
+ALTER TABLE tasks ADD COLUMN succeeded BOOLEAN NOT NULL DEFAULT false;
+
+-- Titles are unique, ignoring case, among the live tasks of a scope that
+-- enforces them: one owner's personal tasks, or a workspace's. Completed
+-- occurrences of a recurring task share their successor's title and don't
+-- count.
+CREATE UNIQUE INDEX tasks_unique_title_personal_idx ON tasks (owner_id, lower(title))
+	WHERE unique_title AND NOT succeeded AND workspace_id IS NULL AND deleted_at IS NULL;
+CREATE UNIQUE INDEX tasks_unique_title_workspace_idx ON tasks (workspace_id, lower(title))
+	WHERE unique_title AND NOT succeeded AND workspace_id IS NOT NULL AND deleted_at IS NULL;
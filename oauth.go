@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/endpoints"
+)
+
+// googleUserinfoURL is Google's OpenID Connect userinfo endpoint.
+const googleUserinfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+
+// googleTimeout bounds each call the callback makes to Google.
+const googleTimeout = 10 * time.Second
+
+// Session keys holding a Google sign-in in progress.
+const (
+	oauthStateKey    = "oauthState"
+	oauthVerifierKey = "oauthVerifier"
+)
+
+// GoogleOAuthConfig holds the OAuth client registered with Google. Sign-in
+// with Google is off unless ClientID is set.
+type GoogleOAuthConfig struct {
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is where Google sends the browser back to; it must point
+	// at GET /auth/google/callback and be registered with the client.
+	RedirectURL string
+}
+
+func (c GoogleOAuthConfig) enabled() bool {
+	return c.ClientID != ""
+}
+
+func (c GoogleOAuthConfig) oauth2() *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     c.ClientID,
+		ClientSecret: c.ClientSecret,
+		RedirectURL:  c.RedirectURL,
+		Endpoint:     endpoints.Google,
+		Scopes:       []string{"openid", "email", "profile"},
+	}
+}
+
+// googleUserinfo is the part of the userinfo response sign-in uses.
+type googleUserinfo struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+}
+
+// googleLoginHandler starts a Google sign-in: it stores a random state and a
+// PKCE verifier in the session and redirects to Google's consent page.
+func googleLoginHandler(conf *oauth2.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			serverError(w, err)
+			return
+		}
+		state := base64.RawURLEncoding.EncodeToString(b)
+		verifier := oauth2.GenerateVerifier()
+		sessionManager.Put(r.Context(), oauthStateKey, state)
+		sessionManager.Put(r.Context(), oauthVerifierKey, verifier)
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, conf.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), http.StatusFound)
+	}
+}
+
+// googleCallbackHandler finishes a Google sign-in. It checks the state
+// against the one stored by googleLoginHandler, exchanges the code, and logs
+// in the user linked to the Google account, creating one on first sign-in,
+// before redirecting to the app. Nothing is put in the session unless every
+// step succeeds.
+//
+// Accounts are linked by Google's subject ID only, never by e-mail address,
+// so that an existing password account can't be taken over by someone who
+// controls a Google account with a matching address.
+func googleCallbackHandler(conf *oauth2.Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// Popped so that each state can only be used once.
+		state := sessionManager.PopString(r.Context(), oauthStateKey)
+		verifier := sessionManager.PopString(r.Context(), oauthVerifierKey)
+		q := r.URL.Query()
+		if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "invalid OAuth state")
+			return
+		}
+		if e := q.Get("error"); e != "" {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Google sign-in failed: %s", e))
+			return
+		}
+		code := q.Get("code")
+		if code == "" {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "code is required")
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), googleTimeout)
+		defer cancel()
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Timeout: googleTimeout})
+		tok, err := conf.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+		if err != nil {
+			slog.Warn("exchanging Google authorization code failed", "error", err)
+			writeError(w, http.StatusBadGateway, CodeBadGateway, "could not complete sign-in with Google")
+			return
+		}
+		info, err := fetchGoogleUserinfo(ctx, conf.Client(ctx, tok))
+		if err != nil {
+			slog.Warn("fetching Google userinfo failed", "error", err)
+			writeError(w, http.StatusBadGateway, CodeBadGateway, "could not complete sign-in with Google")
+			return
+		}
+
+		user, err := findOrCreateGoogleUser(r.Context(), info)
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+
+		if err := sessionManager.RenewToken(r.Context()); err != nil {
+			serverError(w, err)
+			return
+		}
+		putSessionUser(r.Context(), user)
+		recordSessionStart(r.Context(), r)
+		auditLog.recordDetail(r, user.ID, AuditLogin, "", "google")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+	}
+}
+
+func fetchGoogleUserinfo(ctx context.Context, client *http.Client) (googleUserinfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, googleUserinfoURL, nil)
+	if err != nil {
+		return googleUserinfo{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return googleUserinfo{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return googleUserinfo{}, fmt.Errorf("userinfo returned %s", resp.Status)
+	}
+	var info googleUserinfo
+	if err := json.NewDecoder(http.MaxBytesReader(nil, resp.Body, 1<<20)).Decode(&info); err != nil {
+		return googleUserinfo{}, err
+	}
+	if info.Subject == "" {
+		return googleUserinfo{}, errors.New("userinfo has no subject")
+	}
+	return info, nil
+}
+
+// findOrCreateGoogleUser returns the user linked to the Google account, or
+// creates one without a password. The new user is named after their
+// verified e-mail address, or "google-<subject>" if there is none or it is
+// taken.
+func findOrCreateGoogleUser(ctx context.Context, info googleUserinfo) (User, error) {
+	u, err := userStore.GetByGoogleSubject(ctx, info.Subject)
+	if !errors.Is(err, ErrUserNotFound) {
+		return u, err
+	}
+	var names []string
+	if info.EmailVerified && info.Email != "" {
+		names = append(names, normalizeUsername(info.Email))
+	}
+	names = append(names, "google-"+info.Subject)
+	for _, name := range names {
+		u, err = userStore.Create(ctx, User{Username: name, Role: RoleUser, GoogleSubject: info.Subject})
+		switch {
+		case errors.Is(err, ErrUsernameTaken):
+			continue
+		case errors.Is(err, ErrGoogleSubjectTaken):
+			// A concurrent callback for the same account got there first.
+			return userStore.GetByGoogleSubject(ctx, info.Subject)
+		}
+		return u, err
+	}
+	return User{}, fmt.Errorf("no free username for Google subject %s", info.Subject)
+}
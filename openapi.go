@@ -0,0 +1,27 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// openAPISpec is the hand-maintained OpenAPI description of the API. Update
+// it together with any change to routes or request/response shapes.
+//
+//go:embed openapi/openapi.json
+var openAPISpec []byte
+
+//go:embed openapi/docs.html
+var openAPIDocsPage []byte
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPISpec)
+}
+
+// docsHandler serves a Swagger UI page that renders /openapi.json.
+func docsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", docsContentSecurityPolicy)
+	w.Write(openAPIDocsPage)
+}
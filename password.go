@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxPasswordBytes is bcrypt's input limit; longer passwords can't be hashed.
+const maxPasswordBytes = 72
+
+// PasswordPolicy is the set of rules a new password must satisfy. It is
+// independent of HTTP so that Check can be exercised on its own.
+type PasswordPolicy struct {
+	MinLength int
+	// RequireMixedCase requires both an upper and a lower case letter.
+	RequireMixedCase bool
+	RequireDigit     bool
+	// RequireSymbol requires a character that is neither a letter, a digit
+	// nor a space.
+	RequireSymbol bool
+	// RejectCommon rejects passwords on the embedded list of common ones.
+	RejectCommon bool
+}
+
+// Password rules, as reported by PasswordPolicy.Check.
+const (
+	PasswordRuleMinLength = "min_length"
+	PasswordRuleMaxLength = "max_length"
+	PasswordRuleMixedCase = "mixed_case"
+	PasswordRuleDigit     = "digit"
+	PasswordRuleSymbol    = "symbol"
+	PasswordRuleCommon    = "common"
+)
+
+// Check returns a message for every rule password breaks, keyed by rule, or
+// nil if it satisfies the policy. The messages are safe to show to users.
+func (p PasswordPolicy) Check(password string) map[string]string {
+	violations := make(map[string]string)
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations[PasswordRuleMinLength] = fmt.Sprintf("must be at least %d characters", p.MinLength)
+	}
+	if len(password) > maxPasswordBytes {
+		violations[PasswordRuleMaxLength] = fmt.Sprintf("must be at most %d bytes", maxPasswordBytes)
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			symbol = true
+		}
+	}
+	if p.RequireMixedCase && !(upper && lower) {
+		violations[PasswordRuleMixedCase] = "must contain both upper and lower case letters"
+	}
+	if p.RequireDigit && !digit {
+		violations[PasswordRuleDigit] = "must contain a digit"
+	}
+	if p.RequireSymbol && !symbol {
+		violations[PasswordRuleSymbol] = "must contain a symbol"
+	}
+	if p.RejectCommon && isCommonPassword(password) {
+		violations[PasswordRuleCommon] = "is too common"
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	return violations
+}
+
+//go:embed passwords/common.txt
+var commonPasswordsFile []byte
+
+var commonPasswords = func() map[string]bool {
+	set := make(map[string]bool)
+	sc := bufio.NewScanner(bytes.NewReader(commonPasswordsFile))
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			set[line] = true
+		}
+	}
+	return set
+}()
+
+// isCommonPassword reports whether password is on the common list, ignoring
+// case and any trailing digits and punctuation, which users tend to append
+// to satisfy composition rules.
+func isCommonPassword(password string) bool {
+	lower := strings.ToLower(password)
+	if commonPasswords[lower] {
+		return true
+	}
+	base := strings.TrimRightFunc(lower, func(r rune) bool {
+		return unicode.IsDigit(r) || unicode.IsPunct(r) || unicode.IsSymbol(r)
+	})
+	return base != "" && commonPasswords[base]
+}
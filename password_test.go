@@ -0,0 +1,64 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	strict := PasswordPolicy{MinLength: 10, RequireMixedCase: true, RequireDigit: true, RequireSymbol: true, RejectCommon: true}
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		want     []string
+	}{
+		{"satisfies every rule", strict, "Correct-h0rse", nil},
+		{"no rules", PasswordPolicy{}, "", nil},
+
+		// Length counts characters, not bytes, up to bcrypt's 72 bytes.
+		{"one short of the minimum", strict, "Quokkas1!", []string{PasswordRuleMinLength}},
+		{"exactly the minimum", strict, "Quokkas12!", nil},
+		{"multibyte characters count once", strict, "Äöüßéèà1!x", nil},
+		{"72 bytes", strict, "Aa1!" + strings.Repeat("x", 68), nil},
+		{"73 bytes", strict, "Aa1!" + strings.Repeat("x", 69), []string{PasswordRuleMaxLength}},
+		{"72 characters but more bytes", strict, "Aa1!" + strings.Repeat("é", 68), []string{PasswordRuleMaxLength}},
+
+		{"no upper case", strict, "quokkas12!", []string{PasswordRuleMixedCase}},
+		{"no lower case", strict, "QUOKKAS12!", []string{PasswordRuleMixedCase}},
+		{"no digit", strict, "Quokkasxy!", []string{PasswordRuleDigit}},
+		{"no symbol", strict, "Quokkas123", []string{PasswordRuleSymbol}},
+		{"a space isn't a symbol", strict, "Quokkas 12", []string{PasswordRuleSymbol}},
+		{"unicode classes", strict, "Ωmega-λ1xyz", nil},
+		{"rules not required", PasswordPolicy{MinLength: 4}, "abcd", nil},
+		{"every rule broken", strict, "dragon", []string{PasswordRuleMinLength, PasswordRuleMixedCase, PasswordRuleDigit, PasswordRuleSymbol, PasswordRuleCommon}},
+
+		// The common list ignores case and trailing digits and punctuation.
+		{"common", PasswordPolicy{RejectCommon: true}, "letmein", []string{PasswordRuleCommon}},
+		{"common in another case", PasswordPolicy{RejectCommon: true}, "LetMeIn", []string{PasswordRuleCommon}},
+		{"common with a suffix", strict, "Password2024!!", []string{PasswordRuleCommon}},
+		{"common digits", PasswordPolicy{RejectCommon: true}, "123456", []string{PasswordRuleCommon}},
+		{"uncommon digits", PasswordPolicy{RejectCommon: true}, "9081726354", nil},
+		{"common word with a prefix", strict, "1Password!x", nil},
+		{"common but not rejected", PasswordPolicy{}, "password", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := tt.policy.Check(tt.password)
+			var got []string
+			for rule, msg := range violations {
+				if msg == "" {
+					t.Errorf("rule %s has no message", rule)
+				}
+				got = append(got, rule)
+			}
+			slices.Sort(got)
+			want := slices.Clone(tt.want)
+			slices.Sort(want)
+			if !slices.Equal(got, want) {
+				t.Errorf("Check(%q) broke %v, want %v", tt.password, got, want)
+			}
+		})
+	}
+}
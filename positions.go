@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// positionGap is the distance between the positions of consecutive tasks
+// when they are appended or renumbered, leaving room to move tasks between
+// them without touching their neighbours.
+const positionGap = 1024.0
+
+// maxReorderTasks bounds how many tasks one POST /tasks/reorder may list.
+const maxReorderTasks = 1000
+
+// planReorder returns new positions that put the tasks listed in ids, which
+// must be distinct, in that order. current holds the position of every task
+// of their owner, listed or not.
+//
+// As few tasks as possible move: the longest run of listed tasks already in
+// order keeps its positions, and every other listed task is placed right
+// after the listed task before it, or right before the first listed task if
+// it comes first. ok is false when two positions have no room left between
+// them; the owner's tasks must then be renumbered (see renumberPositions)
+// and the reorder planned again.
+func planReorder(ids []string, current map[string]float64) (moved map[string]float64, ok bool) {
+	moved = make(map[string]float64)
+	if len(ids) == 0 {
+		return moved, true
+	}
+	p := make([]float64, len(ids))
+	for i, id := range ids {
+		p[i] = current[id]
+	}
+	keep := longestIncreasing(p)
+
+	moving := make(map[string]bool, len(ids))
+	for i, id := range ids {
+		moving[id] = !keep[i]
+	}
+	// fixed holds the positions of the tasks that stay where they are.
+	fixed := make([]float64, 0, len(current))
+	for id, pos := range current {
+		if !moving[id] {
+			fixed = append(fixed, pos)
+		}
+	}
+	sort.Float64s(fixed)
+
+	for i := 0; i < len(ids); {
+		if keep[i] {
+			i++
+			continue
+		}
+		j := i
+		for j < len(ids) && !keep[j] {
+			j++
+		}
+		n := j - i
+		var lo, hi float64
+		if i > 0 {
+			lo = p[i-1]
+			if k := sort.Search(len(fixed), func(k int) bool { return fixed[k] > lo }); k < len(fixed) {
+				hi = fixed[k]
+			} else {
+				hi = lo + positionGap*float64(n+1)
+			}
+		} else {
+			hi = p[j]
+			if k := sort.Search(len(fixed), func(k int) bool { return fixed[k] >= hi }); k > 0 {
+				lo = fixed[k-1]
+			} else {
+				lo = hi - positionGap*float64(n+1)
+			}
+		}
+		step := (hi - lo) / float64(n+1)
+		prev := lo
+		for k := 0; k < n; k++ {
+			pos := lo + step*float64(k+1)
+			if pos <= prev || pos >= hi {
+				return nil, false
+			}
+			moved[ids[i+k]] = pos
+			prev = pos
+		}
+		i = j
+	}
+	return moved, true
+}
+
+// longestIncreasing marks the elements of one of the longest strictly
+// increasing subsequences of p, which must not be empty.
+func longestIncreasing(p []float64) []bool {
+	// tails[k] is the index of the smallest last element of an increasing
+	// subsequence of length k+1 found so far.
+	var tails []int
+	prev := make([]int, len(p))
+	for i, v := range p {
+		k := sort.Search(len(tails), func(k int) bool { return p[tails[k]] >= v })
+		prev[i] = -1
+		if k > 0 {
+			prev[i] = tails[k-1]
+		}
+		if k == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[k] = i
+		}
+	}
+	keep := make([]bool, len(p))
+	for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+		keep[i] = true
+	}
+	return keep
+}
+
+// renumberPositions spreads out the positions of the tasks with the given
+// IDs, in that order, positionGap apart.
+func renumberPositions(ids []string) map[string]float64 {
+	positions := make(map[string]float64, len(ids))
+	for i, id := range ids {
+		positions[id] = positionGap * float64(i+1)
+	}
+	return positions
+}
+
+// reorderInput is the body of POST /tasks/reorder.
+type reorderInput struct {
+	TaskIDs []string `json:"task_ids"`
+}
+
+func (in reorderInput) Validate() error {
+	switch {
+	case len(in.TaskIDs) == 0:
+		return validationErrors{"task_ids": "required"}
+	case len(in.TaskIDs) > maxReorderTasks:
+		return validationErrors{"task_ids": fmt.Sprintf("must have at most %d entries", maxReorderTasks)}
+	}
+	errs := validationErrors{}
+	seen := make(map[string]bool, len(in.TaskIDs))
+	for i, id := range in.TaskIDs {
+		if seen[id] {
+			errs[fmt.Sprintf("task_ids[%d]", i)] = "duplicate"
+		}
+		seen[id] = true
+	}
+	return errs.orNil()
+}
+
+// reorderTasksHandler puts the current user's tasks listed in the body in
+// that order for ?sort=position. Only the listed tasks that are out of
+// order move, so dragging one task within a full list changes only its
+// position. Every listed task must be a live task the user owns; otherwise
+// nothing changes and the answer is 404.
+func reorderTasksHandler(w http.ResponseWriter, r *http.Request) {
+	var in reorderInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+	tasks, err := taskRepo.Reorder(r.Context(), currentUser(r.Context()).ID, in.TaskIDs)
+	if err != nil {
+		if errors.Is(err, ErrTaskNotFound) {
+			writeError(w, http.StatusNotFound, CodeNotFound, "a listed task does not exist or is not yours")
+			return
+		}
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, map[string][]Task{"tasks": tasksIn(tasks, displayZone(w, r))})
+}
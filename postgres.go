@@ -0,0 +1,716 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// openPostgres opens a connection pool to url, applies the pool limits and
+// checks the connection, retrying as retry says while the database isn't
+// up yet, so that a bad DATABASE_URL fails startup.
+func openPostgres(ctx context.Context, url string, maxOpen, maxIdle int, maxLifetime time.Duration, retry RetryConfig) (*sql.DB, error) {
+	db, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(maxOpen)
+	db.SetMaxIdleConns(maxIdle)
+	db.SetConnMaxLifetime(maxLifetime)
+
+	err = withRetry(ctx, retry, "postgres", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		return db.PingContext(ctx)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// PostgresTaskRepo is a TaskRepository backed by PostgreSQL. Filtering,
+// sorting and pagination are done in SQL.
+type PostgresTaskRepo struct {
+	db *sql.DB
+}
+
+// NewPostgresTaskRepo returns a repository using db. The schema must already
+// have been migrated (see runMigrations).
+func NewPostgresTaskRepo(db *sql.DB) *PostgresTaskRepo {
+	return &PostgresTaskRepo{db: db}
+}
+
+// Ping checks the database connection.
+func (r *PostgresTaskRepo) Ping(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+const taskColumns = `id, owner_id, workspace_id, title, description, done, tags, due_date, reminder_sent_at, assignee_id, priority, recurrence, subtasks, attachments, auto_complete, created_at, completed_at, deleted_at, archived, archived_at, unique_title, succeeded, position`
+
+func scanTask(row interface{ Scan(...any) error }) (Task, error) {
+	var t Task
+	var tags pq.StringArray
+	var subtasks, attachments []byte
+	err := row.Scan(&t.ID, &t.OwnerID, &t.WorkspaceID, &t.Title, &t.Description, &t.Done, &tags, &t.DueDate, &t.ReminderSentAt, &t.AssigneeID, &t.Priority, &t.Recurrence, &subtasks, &attachments, &t.AutoComplete, &t.CreatedAt, &t.CompletedAt, &t.DeletedAt, &t.Archived, &t.ArchivedAt, &t.UniqueTitle, &t.Succeeded, &t.Position)
+	if err != nil {
+		return t, err
+	}
+	t.Tags = []string(tags)
+	if t.Tags == nil {
+		t.Tags = []string{}
+	}
+	if err := json.Unmarshal(subtasks, &t.Subtasks); err != nil {
+		return t, fmt.Errorf("decoding subtasks of task %s: %w", t.ID, err)
+	}
+	if t.Subtasks == nil {
+		t.Subtasks = []Subtask{}
+	}
+	if err := json.Unmarshal(attachments, &t.Attachments); err != nil {
+		return t, fmt.Errorf("decoding attachments of task %s: %w", t.ID, err)
+	}
+	if t.Attachments == nil {
+		t.Attachments = []Attachment{}
+	}
+	return t, nil
+}
+
+func scanTasks(rows *sql.Rows) ([]Task, error) {
+	defer rows.Close()
+	tasks := make([]Task, 0)
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// insertTask places the new task after the other tasks of its owner.
+const insertTask = `INSERT INTO tasks (` + taskColumns + `)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, $22,
+		(SELECT COALESCE(MAX(position), 0) + $23 FROM tasks WHERE owner_id = $2))
+	RETURNING position`
+
+// subtasksJSON encodes a checklist for the JSONB subtasks column.
+func subtasksJSON(subtasks []Subtask) ([]byte, error) {
+	if subtasks == nil {
+		subtasks = []Subtask{}
+	}
+	return json.Marshal(subtasks)
+}
+
+// attachmentsJSON encodes attachment metadata for the JSONB attachments
+// column.
+func attachmentsJSON(attachments []Attachment) ([]byte, error) {
+	if attachments == nil {
+		attachments = []Attachment{}
+	}
+	return json.Marshal(attachments)
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// insertNewTask assigns t an ID and creation time and inserts it using q.
+func insertNewTask(ctx context.Context, q execer, t Task, now time.Time) (Task, error) {
+	id, err := newTaskID()
+	if err != nil {
+		return Task{}, err
+	}
+	t.ID = id
+	t.CreatedAt = now
+	if t.Tags == nil {
+		t.Tags = []string{}
+	}
+
+	subtasks, err := subtasksJSON(t.Subtasks)
+	if err != nil {
+		return Task{}, err
+	}
+	attachments, err := attachmentsJSON(t.Attachments)
+	if err != nil {
+		return Task{}, err
+	}
+	err = q.QueryRowContext(ctx, insertTask,
+		t.ID, t.OwnerID, t.WorkspaceID, t.Title, t.Description, t.Done, pq.Array(t.Tags), t.DueDate, t.ReminderSentAt, t.AssigneeID, t.Priority, t.Recurrence, subtasks, attachments, t.AutoComplete, t.CreatedAt, t.CompletedAt, t.DeletedAt, t.Archived, t.ArchivedAt, t.UniqueTitle, t.Succeeded, positionGap).Scan(&t.Position)
+	if err != nil {
+		return Task{}, duplicateTitle(err)
+	}
+	return t, nil
+}
+
+// quotaLockClass is the first key of the advisory locks taken on an owner's
+// ID while checking their task quota.
+const quotaLockClass = 1
+
+// checkQuota fails with ErrQuotaExceeded if storing tasks would leave an
+// owner with more than quota tasks. It holds an advisory lock on each owner
+// until the transaction ends, so that concurrent creates for the same owner
+// are checked one after the other.
+func checkQuota(ctx context.Context, tx *sql.Tx, tasks []Task, quota int) error {
+	if quota <= 0 {
+		return nil
+	}
+	adding := countByOwner(tasks)
+	owners := make([]int, 0, len(adding))
+	for owner := range adding {
+		owners = append(owners, owner)
+	}
+	sort.Ints(owners)
+	for _, owner := range owners {
+		// Locked in ID order, so that two batches can't deadlock.
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, quotaLockClass, owner); err != nil {
+			return err
+		}
+		var owned int
+		if err := tx.QueryRowContext(ctx, `SELECT count(*) FROM tasks WHERE owner_id = $1`, owner).Scan(&owned); err != nil {
+			return err
+		}
+		if owned+adding[owner] > quota {
+			return quotaError(quota)
+		}
+	}
+	return nil
+}
+
+func (r *PostgresTaskRepo) Create(ctx context.Context, t Task, quota int) (Task, error) {
+	if quota <= 0 {
+		return insertNewTask(ctx, r.db, t, time.Now().UTC())
+	}
+	created, err := r.CreateMany(ctx, []Task{t}, quota)
+	if err != nil {
+		return Task{}, err
+	}
+	return created[0], nil
+}
+
+func (r *PostgresTaskRepo) CreateMany(ctx context.Context, tasks []Task, quota int) ([]Task, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := checkQuota(ctx, tx, tasks, quota); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	created := make([]Task, len(tasks))
+	for i, t := range tasks {
+		if created[i], err = insertNewTask(ctx, tx, t, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *PostgresTaskRepo) ReplaceByOwner(ctx context.Context, ownerID int, tasks []Task, quota int) ([]Task, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if quota > 0 {
+		// Taken before the delete so that no create for ownerID slips in
+		// between it and the count; checkQuota takes it again, which
+		// advisory locks allow.
+		if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, quotaLockClass, ownerID); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM tasks WHERE owner_id = $1 AND workspace_id IS NULL`, ownerID); err != nil {
+		return nil, err
+	}
+	if err := checkQuota(ctx, tx, tasks, quota); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	created := make([]Task, len(tasks))
+	for i, t := range tasks {
+		if created[i], err = insertNewTask(ctx, tx, t, now); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (r *PostgresTaskRepo) Get(ctx context.Context, id string) (Task, error) {
+	t, err := scanTask(r.db.QueryRowContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Task{}, ErrTaskNotFound
+	}
+	return t, err
+}
+
+// taskOrderBy maps ListOptions.Sort values to ORDER BY clauses. Only these
+// fixed strings are ever interpolated into SQL.
+var taskOrderBy = map[string]string{
+	"":                  "created_at ASC, id ASC",
+	SortByCreatedAt:     "created_at ASC, id ASC",
+	SortByCreatedAtDesc: "created_at DESC, id DESC",
+	SortByTitle:         "lower(title) ASC, id ASC",
+	SortByTitleDesc:     "lower(title) DESC, id DESC",
+	SortByPriority:      priorityOrder + " DESC, id ASC",
+	SortByPriorityDesc:  priorityOrder + " ASC, id DESC",
+	SortByPosition:      "position ASC, id ASC",
+	SortByPositionDesc:  "position DESC, id DESC",
+}
+
+// priorityOrder ranks the priority column like priorityRank.
+const priorityOrder = `CASE priority WHEN 'urgent' THEN 4 WHEN 'high' THEN 3 WHEN 'medium' THEN 2 ELSE 1 END`
+
+// listWhere builds the WHERE clause and arguments for opts.
+func listWhere(opts ListOptions) (string, []any) {
+	conds := []string{"deleted_at IS NULL"}
+	if opts.Trashed {
+		conds[0] = "deleted_at IS NOT NULL"
+	}
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+
+	switch {
+	case opts.IncludeArchived:
+	case opts.Archived:
+		conds = append(conds, "archived")
+	default:
+		conds = append(conds, "NOT archived")
+	}
+	if opts.OwnerID != 0 {
+		add("owner_id = $%d", opts.OwnerID)
+	}
+	if opts.AssigneeID != 0 {
+		add("assignee_id = $%d", opts.AssigneeID)
+	}
+	if opts.WorkspaceID != 0 {
+		add("workspace_id = $%d", opts.WorkspaceID)
+	}
+	if opts.Personal {
+		conds = append(conds, "workspace_id IS NULL")
+	}
+	if len(opts.Tags) > 0 {
+		add("tags @> $%d", pq.Array(opts.Tags))
+	}
+	if len(opts.Priorities) > 0 {
+		add("priority = ANY($%d)", pq.Array(opts.Priorities))
+	}
+	if opts.Overdue {
+		add("NOT done AND due_date < $%d", time.Now().UTC())
+	}
+	if opts.DueBefore != nil {
+		add("due_date < $%d", *opts.DueBefore)
+	}
+	if opts.DueAfter != nil {
+		add("due_date > $%d", *opts.DueAfter)
+	}
+	if opts.CreatedAfter != nil {
+		add("created_at > $%d", *opts.CreatedAfter)
+	}
+	return strings.Join(conds, " AND "), args
+}
+
+func (r *PostgresTaskRepo) List(ctx context.Context, opts ListOptions) ([]Task, int, error) {
+	orderBy, ok := taskOrderBy[opts.Sort]
+	if !ok {
+		return nil, 0, fmt.Errorf("unsupported sort %q", opts.Sort)
+	}
+	where, args := listWhere(opts)
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT count(*) FROM tasks WHERE `+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	if opts.After != nil {
+		cmp := ">"
+		if opts.Sort == SortByCreatedAtDesc {
+			cmp = "<"
+		}
+		args = append(args, opts.After.CreatedAt, opts.After.ID)
+		where += fmt.Sprintf(" AND (created_at, id) %s ($%d, $%d)", cmp, len(args)-1, len(args))
+	}
+	query := `SELECT ` + taskColumns + ` FROM tasks WHERE ` + where + ` ORDER BY ` + orderBy
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	args = append(args, opts.Offset)
+	query += fmt.Sprintf(" OFFSET $%d", len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	tasks, err := scanTasks(rows)
+	return tasks, total, err
+}
+
+func (r *PostgresTaskRepo) Count(ctx context.Context, opts ListOptions) (TaskCounts, error) {
+	where, args := listWhere(opts)
+	args = append(args, time.Now().UTC())
+	var c TaskCounts
+	err := r.db.QueryRowContext(ctx, fmt.Sprintf(`SELECT count(*),
+		count(*) FILTER (WHERE done),
+		count(*) FILTER (WHERE NOT done AND due_date < $%d)
+		FROM tasks WHERE `+where, len(args)), args...).Scan(&c.Total, &c.Done, &c.Overdue)
+	c.Pending = c.Total - c.Done
+	return c, err
+}
+
+func (r *PostgresTaskRepo) Search(ctx context.Context, userID, workspaceID int, query string) ([]Task, error) {
+	// Escape LIKE wildcards so the query is matched literally.
+	pattern := "%" + strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(query) + "%"
+	scope, args := scopeWhere(userID, workspaceID)
+	rows, err := r.db.QueryContext(ctx, `SELECT `+taskColumns+` FROM tasks
+		WHERE `+scope+` AND deleted_at IS NULL AND (title ILIKE $2 OR description ILIKE $2)`, append(args, pattern)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanTasks(rows)
+}
+
+// scopeWhere returns a condition on $1 selecting the tasks of workspaceID
+// or, if it is 0, ownerID's personal tasks.
+func scopeWhere(ownerID, workspaceID int) (string, []any) {
+	if workspaceID != 0 {
+		return "workspace_id = $1", []any{workspaceID}
+	}
+	return "owner_id = $1 AND workspace_id IS NULL", []any{ownerID}
+}
+
+func (r *PostgresTaskRepo) Tags(ctx context.Context, ownerID, workspaceID int) ([]string, error) {
+	scope, args := scopeWhere(ownerID, workspaceID)
+	rows, err := r.db.QueryContext(ctx, `SELECT DISTINCT unnest(tags) AS tag FROM tasks
+		WHERE `+scope+` AND deleted_at IS NULL ORDER BY tag`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tags := make([]string, 0)
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, rows.Err()
+}
+
+func (r *PostgresTaskRepo) Update(ctx context.Context, t Task) error {
+	if t.Tags == nil {
+		t.Tags = []string{}
+	}
+	subtasks, err := subtasksJSON(t.Subtasks)
+	if err != nil {
+		return err
+	}
+	attachments, err := attachmentsJSON(t.Attachments)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `UPDATE tasks SET owner_id = $2, workspace_id = $3, title = $4, description = $5,
+		done = $6, tags = $7, due_date = $8, reminder_sent_at = $9, assignee_id = $10, priority = $11, recurrence = $12,
+		subtasks = $13, attachments = $14, auto_complete = $15, completed_at = $16, deleted_at = $17,
+		archived = $18, archived_at = $19, unique_title = $20, succeeded = $21 WHERE id = $1`,
+		t.ID, t.OwnerID, t.WorkspaceID, t.Title, t.Description, t.Done, pq.Array(t.Tags), t.DueDate, t.ReminderSentAt, t.AssigneeID, t.Priority, t.Recurrence, subtasks, attachments, t.AutoComplete, t.CompletedAt, t.DeletedAt, t.Archived, t.ArchivedAt, t.UniqueTitle, t.Succeeded)
+	if err != nil {
+		return duplicateTitle(err)
+	}
+	return requireOneRow(res)
+}
+
+func (r *PostgresTaskRepo) SetUniqueTitles(ctx context.Context, ownerID, workspaceID int, unique bool) error {
+	var err error
+	if workspaceID != 0 {
+		_, err = r.db.ExecContext(ctx, `UPDATE tasks SET unique_title = $2 WHERE workspace_id = $1`, workspaceID, unique)
+	} else {
+		_, err = r.db.ExecContext(ctx, `UPDATE tasks SET unique_title = $2 WHERE owner_id = $1 AND workspace_id IS NULL`, ownerID, unique)
+	}
+	return duplicateTitle(err)
+}
+
+// duplicateTitle turns a violation of the unique title indexes into
+// ErrDuplicateTitle.
+func duplicateTitle(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" && strings.HasPrefix(pqErr.Constraint, "tasks_unique_title_") { // unique_violation
+		return ErrDuplicateTitle
+	}
+	return err
+}
+
+// positionLockClass is the first key of the advisory locks taken on an
+// owner's ID while reordering their tasks.
+const positionLockClass = 2
+
+// Reorder reads the positions of all of the owner's tasks and plans the
+// change in Go. An advisory lock on the owner makes concurrent reorders of
+// the same tasks wait for each other rather than plan against the same
+// positions.
+func (r *PostgresTaskRepo) Reorder(ctx context.Context, ownerID int, ids []string) ([]Task, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2)`, positionLockClass, ownerID); err != nil {
+		return nil, err
+	}
+	rows, err := tx.QueryContext(ctx, `SELECT id, position, deleted_at IS NULL FROM tasks WHERE owner_id = $1
+		ORDER BY position ASC, id ASC`, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	var order []string
+	current := make(map[string]float64)
+	isLive := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		var pos float64
+		var live bool
+		if err := rows.Scan(&id, &pos, &live); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		order = append(order, id)
+		current[id] = pos
+		isLive[id] = live
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if !isLive[id] {
+			return nil, ErrTaskNotFound
+		}
+	}
+
+	moved, ok := planReorder(ids, current)
+	if !ok {
+		current = renumberPositions(order)
+		if err := setPositions(ctx, tx, current); err != nil {
+			return nil, err
+		}
+		moved, _ = planReorder(ids, current)
+	}
+	if err := setPositions(ctx, tx, moved); err != nil {
+		return nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, `SELECT `+taskColumns+` FROM tasks WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	found, err := scanTasks(rows)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	byID := make(map[string]Task, len(found))
+	for _, t := range found {
+		byID[t.ID] = t
+	}
+	tasks := make([]Task, len(ids))
+	for i, id := range ids {
+		tasks[i] = byID[id]
+	}
+	return tasks, nil
+}
+
+func (r *PostgresTaskRepo) SetDone(ctx context.Context, ownerID, workspaceID int, ids []string, done bool, at time.Time) ([]Task, error) {
+	scope, args := scopeWhere(ownerID, workspaceID)
+	rows, err := r.db.QueryContext(ctx, `UPDATE tasks SET done = $3,
+		completed_at = CASE WHEN $3::boolean THEN $4::timestamptz END
+		WHERE `+scope+` AND id = ANY($2) AND deleted_at IS NULL AND done <> $3
+		RETURNING `+taskColumns, append(args, pq.Array(ids), done, at)...)
+	if err != nil {
+		return nil, err
+	}
+	return scanTasks(rows)
+}
+
+// setPositions stores new positions by task ID.
+func setPositions(ctx context.Context, tx *sql.Tx, positions map[string]float64) error {
+	if len(positions) == 0 {
+		return nil
+	}
+	ids := make([]string, 0, len(positions))
+	values := make([]float64, 0, len(positions))
+	for id, pos := range positions {
+		ids = append(ids, id)
+		values = append(values, pos)
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE tasks SET position = v.position
+		FROM unnest($1::text[], $2::double precision[]) AS v (id, position) WHERE tasks.id = v.id`,
+		pq.Array(ids), pq.Array(values))
+	return err
+}
+
+func (r *PostgresTaskRepo) Delete(ctx context.Context, id string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM tasks WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	return requireOneRow(res)
+}
+
+func (r *PostgresTaskRepo) DeleteByOwner(ctx context.Context, ownerID int) (int, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM tasks WHERE owner_id = $1 AND workspace_id IS NULL`, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *PostgresTaskRepo) DeleteByWorkspace(ctx context.Context, workspaceID int) (int, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM tasks WHERE workspace_id = $1`, workspaceID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *PostgresTaskRepo) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM tasks WHERE deleted_at < $1`, cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *PostgresTaskRepo) DueForReminder(ctx context.Context, now, before time.Time) ([]Task, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+taskColumns+` FROM tasks
+		WHERE deleted_at IS NULL AND NOT done AND reminder_sent_at IS NULL AND due_date > $1 AND due_date <= $2
+		ORDER BY due_date`, now, before)
+	if err != nil {
+		return nil, err
+	}
+	return scanTasks(rows)
+}
+
+func (r *PostgresTaskRepo) MarkReminded(ctx context.Context, id string, at time.Time) (bool, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE tasks SET reminder_sent_at = $2 WHERE id = $1 AND reminder_sent_at IS NULL`, id, at)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (r *PostgresTaskRepo) Unassign(ctx context.Context, assigneeID int) (int, error) {
+	res, err := r.db.ExecContext(ctx, `UPDATE tasks SET assignee_id = NULL WHERE assignee_id = $1`, assigneeID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+func (r *PostgresTaskRepo) AddComment(ctx context.Context, c Comment) (Comment, error) {
+	id, err := newTaskID()
+	if err != nil {
+		return Comment{}, err
+	}
+	c.ID = id
+	c.CreatedAt = time.Now().UTC()
+	_, err = r.db.ExecContext(ctx, `INSERT INTO task_comments (id, task_id, author_id, body, created_at) VALUES ($1, $2, $3, $4, $5)`,
+		c.ID, c.TaskID, c.AuthorID, c.Body, c.CreatedAt)
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23503" { // foreign_key_violation
+		return Comment{}, ErrTaskNotFound
+	}
+	if err != nil {
+		return Comment{}, err
+	}
+	return c, nil
+}
+
+func (r *PostgresTaskRepo) Comments(ctx context.Context, taskID string, limit, offset int) ([]Comment, int, error) {
+	var total int
+	if err := r.db.QueryRowContext(ctx, `SELECT count(*) FROM task_comments WHERE task_id = $1`, taskID).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	rows, err := r.db.QueryContext(ctx, `SELECT id, task_id, author_id, body, created_at FROM task_comments
+		WHERE task_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2 OFFSET $3`, taskID, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	comments := make([]Comment, 0)
+	for rows.Next() {
+		var c Comment
+		if err := rows.Scan(&c.ID, &c.TaskID, &c.AuthorID, &c.Body, &c.CreatedAt); err != nil {
+			return nil, 0, err
+		}
+		comments = append(comments, c)
+	}
+	return comments, total, rows.Err()
+}
+
+func (r *PostgresTaskRepo) GetComment(ctx context.Context, taskID, commentID string) (Comment, error) {
+	var c Comment
+	err := r.db.QueryRowContext(ctx, `SELECT id, task_id, author_id, body, created_at FROM task_comments WHERE task_id = $1 AND id = $2`,
+		taskID, commentID).Scan(&c.ID, &c.TaskID, &c.AuthorID, &c.Body, &c.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Comment{}, ErrCommentNotFound
+	}
+	return c, err
+}
+
+func (r *PostgresTaskRepo) DeleteComment(ctx context.Context, taskID, commentID string) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM task_comments WHERE task_id = $1 AND id = $2`, taskID, commentID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrCommentNotFound
+	}
+	return nil
+}
+
+// requireOneRow turns an UPDATE/DELETE that matched nothing into ErrTaskNotFound.
+func requireOneRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTaskNotFound
+	}
+	return nil
+}
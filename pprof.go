@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerPprof mounts the net/http/pprof handlers under /debug/pprof/ on
+// m, for admins only. m must sit in front of the session middleware, so
+// that profiling a busy server doesn't touch the session store; admins
+// authenticate with an API key instead of a session cookie.
+func registerPprof(m routeMux) {
+	routes := map[string]http.HandlerFunc{
+		"GET /debug/pprof/":        pprof.Index,
+		"GET /debug/pprof/cmdline": pprof.Cmdline,
+		"GET /debug/pprof/profile": pprof.Profile,
+		"GET /debug/pprof/symbol":  pprof.Symbol,
+		"POST /debug/pprof/symbol": pprof.Symbol,
+		"GET /debug/pprof/trace":   pprof.Trace,
+	}
+	for pattern, h := range routes {
+		m.Handle(pattern, requireAPIKey(requireAuth(requireRole(RoleAdmin, h))))
+	}
+}
+
+// requireAPIKey rejects requests without an Authorization header with 401,
+// so that requireAuth never falls back to a session on routes that have
+// none.
+func requireAPIKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := bearerToken(r); !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="tms"`)
+			writeError(w, http.StatusUnauthorized, CodeUnauthorized, "an admin API key is required")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
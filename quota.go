@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// ErrQuotaExceeded is returned by TaskRepository creates that would leave an
+// owner with more tasks than the quota allows.
+var ErrQuotaExceeded = errors.New("task quota exceeded")
+
+// defaultTaskQuota is the number of tasks, trash included, a user may own
+// unless an admin set their quota; 0 means no limit. It is set from
+// TASK_QUOTA at boot.
+var defaultTaskQuota int
+
+// taskQuota returns the quota of u; 0 means no limit.
+func taskQuota(u User) int {
+	if u.TaskQuota != nil {
+		return *u.TaskQuota
+	}
+	return defaultTaskQuota
+}
+
+// quotaError reports that a quota of n tasks was reached.
+func quotaError(n int) error {
+	return fmt.Errorf("%w: a user can own at most %d tasks, including those in the trash", ErrQuotaExceeded, n)
+}
+
+// countByOwner returns how many of tasks each owner has.
+func countByOwner(tasks []Task) map[int]int {
+	n := make(map[int]int)
+	for _, t := range tasks {
+		n[t.OwnerID]++
+	}
+	return n
+}
+
+// taskQuotaInfo is the body of GET and PUT /admin/users/{id}/quota.
+type taskQuotaInfo struct {
+	UserID int `json:"user_id"`
+	// TaskQuota is the effective quota; 0 means no limit.
+	TaskQuota int `json:"task_quota"`
+	// Default is true unless an admin set the quota of this user.
+	Default bool `json:"default"`
+	// Used counts the tasks the user owns, in the trash or not.
+	Used int `json:"used"`
+}
+
+// taskQuotaInput is the body of PUT /admin/users/{id}/quota. A null
+// task_quota reverts the user to the default.
+type taskQuotaInput struct {
+	TaskQuota *int `json:"task_quota"`
+}
+
+// adminGetTaskQuotaHandler returns the task quota of a user and how much of
+// it is used.
+func adminGetTaskQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := loadQuotaUser(w, r)
+	if !ok {
+		return
+	}
+	writeTaskQuota(w, r, u)
+}
+
+// adminSetTaskQuotaHandler overrides the task quota of a user. Lowering it
+// below what the user already owns removes nothing; they just can't create
+// more until they are below it again.
+func adminSetTaskQuotaHandler(w http.ResponseWriter, r *http.Request) {
+	u, ok := loadQuotaUser(w, r)
+	if !ok {
+		return
+	}
+	var in taskQuotaInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.TaskQuota != nil && *in.TaskQuota < 0 {
+		writeValidationErrors(w, validationErrors{"task_quota": "must be non-negative, or null for the default"}, -1)
+		return
+	}
+	u.TaskQuota = in.TaskQuota
+	if err := userStore.Update(r.Context(), u); err != nil {
+		serverError(w, err)
+		return
+	}
+	auditLog.recordDetail(r, currentUser(r.Context()).ID, AuditAdminAction, strconv.Itoa(u.ID), "set_task_quota")
+	writeTaskQuota(w, r, u)
+}
+
+// loadQuotaUser loads the user named by the {id} path segment. If it
+// returns false a response has already been written.
+func loadQuotaUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "user id must be a positive integer")
+		return User{}, false
+	}
+	u, err := userStore.Get(r.Context(), id)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			writeError(w, http.StatusNotFound, CodeNotFound, "user not found")
+			return User{}, false
+		}
+		serverError(w, err)
+		return User{}, false
+	}
+	return u, true
+}
+
+func writeTaskQuota(w http.ResponseWriter, r *http.Request, u User) {
+	used, err := countWithTrash(r.Context(), ListOptions{OwnerID: u.ID})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, taskQuotaInfo{UserID: u.ID, TaskQuota: taskQuota(u), Default: u.TaskQuota == nil, Used: used})
+}
+
+// ownedTaskCount returns how many tasks count against the quota of a user.
+func ownedTaskCount(ctx context.Context, userID int) (int, error) {
+	return countWithTrash(ctx, ListOptions{OwnerID: userID})
+}
+
+// countWithTrash counts the tasks matching opts, in the trash or not,
+// archived or not.
+func countWithTrash(ctx context.Context, opts ListOptions) (int, error) {
+	opts.IncludeArchived = true
+	n := 0
+	for _, trashed := range []bool{false, true} {
+		opts.Trashed = trashed
+		c, err := taskRepo.Count(ctx, opts)
+		if err != nil {
+			return 0, err
+		}
+		n += c.Total
+	}
+	return n, nil
+}
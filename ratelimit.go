@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// ipRateLimiter keeps one token bucket per client IP.
+type ipRateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	visitors map[string]*visitor
+}
+
+type visitor struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newIPRateLimiter returns a limiter allowing cfg.RPS requests per second
+// with burst cfg.Burst per client IP. Buckets idle for longer than idleTTL
+// are garbage-collected in the background.
+func newIPRateLimiter(cfg RateLimitConfig, idleTTL time.Duration) *ipRateLimiter {
+	l := &ipRateLimiter{
+		limit:    rate.Limit(cfg.RPS),
+		burst:    cfg.Burst,
+		visitors: make(map[string]*visitor),
+	}
+	go l.collectGarbage(idleTTL)
+	return l
+}
+
+func (l *ipRateLimiter) limiter(ip string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.visitors[ip]
+	if !ok {
+		v = &visitor{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.visitors[ip] = v
+	}
+	v.lastSeen = time.Now()
+	return v.limiter
+}
+
+func (l *ipRateLimiter) collectGarbage(idleTTL time.Duration) {
+	ticker := time.NewTicker(idleTTL)
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-idleTTL)
+		l.mu.Lock()
+		for ip, v := range l.visitors {
+			if v.lastSeen.Before(cutoff) {
+				delete(l.visitors, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+// middleware rejects requests over the limit with 429 and a Retry-After
+// header telling the client when a token will be available again.
+func (l *ipRateLimiter) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := l.limiter(clientIP(r)).Reserve()
+		if delay := res.Delay(); !res.OK() || delay > 0 {
+			res.Cancel()
+			secs := int(math.Ceil(delay.Seconds()))
+			if secs < 1 {
+				secs = 1
+			}
+			w.Header().Set("Retry-After", strconv.Itoa(secs))
+			writeError(w, http.StatusTooManyRequests, CodeRateLimited, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
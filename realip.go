@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseTrustedProxies parses a comma-separated TRUSTED_PROXIES value of CIDR
+// ranges. A bare IP trusts just that address.
+func parseTrustedProxies(v string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not a valid IP or CIDR range", s)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("TRUSTED_PROXIES: %q is not a valid IP or CIDR range", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// resolveClientIP stores the IP of the client that sent each request in its
+// context, for clientIP. If the direct peer is one of the trusted proxies,
+// X-Forwarded-For is walked from the right and the first hop that isn't a
+// trusted proxy wins, since only our own proxies' entries can be believed;
+// X-Real-IP is used when there is no X-Forwarded-For. Requests from any
+// other peer have these headers ignored so that clients can't spoof them.
+func resolveClientIP(trusted []netip.Prefix, next http.Handler) http.Handler {
+	isTrusted := func(addr netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := remoteIP(r)
+		if peer, err := netip.ParseAddr(ip); err == nil && len(trusted) > 0 && isTrusted(peer.Unmap()) {
+			ip = forwardedClientIP(r, isTrusted, ip)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), clientIPKey, ip)))
+	})
+}
+
+// forwardedClientIP picks the client IP from the proxy headers of r, falling
+// back to peer if they name no usable address.
+func forwardedClientIP(r *http.Request, isTrusted func(netip.Addr) bool, peer string) string {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+	if len(hops) == 0 {
+		if addr, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+			return addr.Unmap().String()
+		}
+		return peer
+	}
+
+	client := peer
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Anything left of a garbled entry can't be trusted either.
+			break
+		}
+		client = addr.Unmap().String()
+		if !isTrusted(addr.Unmap()) {
+			break
+		}
+	}
+	return client
+}
+
+// remoteIP returns the address of the direct peer of r.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP returns the client IP resolved by resolveClientIP, or the direct
+// peer's address for requests that didn't pass through it.
+func clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPKey).(string); ok {
+		return ip
+	}
+	return remoteIP(r)
+}
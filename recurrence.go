@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recurrenceRule is the subset of an RFC 5545 RRULE that tasks support:
+// FREQ=DAILY|WEEKLY|MONTHLY with optional INTERVAL and one of COUNT or UNTIL.
+type recurrenceRule struct {
+	Freq     string
+	Interval int
+	// Count is the number of occurrences left, including the current one;
+	// 0 means unlimited.
+	Count int
+	Until *time.Time
+}
+
+const untilLayout = "20060102T150405Z"
+
+// parseRecurrence parses and validates an RRULE value such as
+// "FREQ=WEEKLY;INTERVAL=2;COUNT=5". Errors are suitable for a 400 response.
+func parseRecurrence(s string) (recurrenceRule, error) {
+	rule := recurrenceRule{Interval: 1}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimSpace(s), "RRULE:"), ";") {
+		name, value, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		value = strings.ToUpper(strings.TrimSpace(value))
+		if !ok || name == "" || value == "" {
+			return rule, fmt.Errorf("recurrence: malformed clause %q (expected NAME=VALUE)", part)
+		}
+		if seen[name] {
+			return rule, fmt.Errorf("recurrence: duplicate clause %s", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "FREQ":
+			switch value {
+			case "DAILY", "WEEKLY", "MONTHLY":
+				rule.Freq = value
+			default:
+				return rule, fmt.Errorf("recurrence: unsupported FREQ %s (supported: DAILY, WEEKLY, MONTHLY)", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("recurrence: INTERVAL must be a positive integer")
+			}
+			rule.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return rule, fmt.Errorf("recurrence: COUNT must be a positive integer")
+			}
+			rule.Count = n
+		case "UNTIL":
+			until, err := parseUntil(value)
+			if err != nil {
+				return rule, err
+			}
+			rule.Until = &until
+		default:
+			return rule, fmt.Errorf("recurrence: unsupported clause %s (supported: FREQ, INTERVAL, COUNT, UNTIL)", name)
+		}
+	}
+	if rule.Freq == "" {
+		return rule, fmt.Errorf("recurrence: FREQ is required")
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return rule, fmt.Errorf("recurrence: COUNT and UNTIL cannot be combined")
+	}
+	return rule, nil
+}
+
+// parseUntil accepts an UNTIL value as a UTC date-time (20261231T170000Z) or
+// a date (20261231), which includes the whole day.
+func parseUntil(v string) (time.Time, error) {
+	if t, err := time.Parse(untilLayout, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse("20060102", v); err == nil {
+		return t.Add(24*time.Hour - time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("recurrence: UNTIL must be YYYYMMDD or YYYYMMDDTHHMMSSZ")
+}
+
+// String formats rule in canonical clause order.
+func (rule recurrenceRule) String() string {
+	parts := []string{"FREQ=" + rule.Freq}
+	if rule.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(rule.Interval))
+	}
+	if rule.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(rule.Count))
+	}
+	if rule.Until != nil {
+		parts = append(parts, "UNTIL="+rule.Until.UTC().Format(untilLayout))
+	}
+	return strings.Join(parts, ";")
+}
+
+// normalizeRecurrence validates a recurrence from a request body and returns
+// its canonical form. The empty string means the task doesn't repeat.
+func normalizeRecurrence(s string) (string, error) {
+	if strings.TrimSpace(s) == "" {
+		return "", nil
+	}
+	rule, err := parseRecurrence(s)
+	if err != nil {
+		return "", err
+	}
+	return rule.String(), nil
+}
+
+// advance returns the occurrence after t. Monthly rules keep the day of the
+// month, clamped to the last day of shorter months.
+func (rule recurrenceRule) advance(t time.Time) time.Time {
+	switch rule.Freq {
+	case "DAILY":
+		return t.AddDate(0, 0, rule.Interval)
+	case "WEEKLY":
+		return t.AddDate(0, 0, 7*rule.Interval)
+	default:
+		y, m, d := t.Date()
+		first := time.Date(y, m+time.Month(rule.Interval), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+		if last := first.AddDate(0, 1, -1).Day(); d > last {
+			d = last
+		}
+		return first.AddDate(0, 0, d-1)
+	}
+}
+
+// nextOccurrence builds the task that follows t, a completed recurring task.
+// It reports false when the rule is exhausted. The recurrence moves to the
+// new task, so each occurrence spawns at most one successor.
+func nextOccurrence(t Task) (Task, bool, error) {
+	rule, err := parseRecurrence(t.Recurrence)
+	if err != nil {
+		return Task{}, false, err
+	}
+	if rule.Count == 1 {
+		return Task{}, false, nil
+	}
+	if rule.Count > 1 {
+		rule.Count--
+	}
+
+	// Tasks without a due date repeat relative to when they were completed.
+	base := time.Now().UTC()
+	if t.DueDate != nil {
+		base = *t.DueDate
+	} else if t.CompletedAt != nil {
+		base = *t.CompletedAt
+	}
+	due := rule.advance(base)
+	if rule.Until != nil && due.After(*rule.Until) {
+		return Task{}, false, nil
+	}
+
+	// The checklist repeats too, with every step open again.
+	subtasks := make([]Subtask, len(t.Subtasks))
+	for i, s := range t.Subtasks {
+		subtasks[i] = Subtask{ID: s.ID, Title: s.Title}
+	}
+
+	return Task{
+		OwnerID:      t.OwnerID,
+		WorkspaceID:  t.WorkspaceID,
+		Title:        t.Title,
+		Description:  t.Description,
+		Tags:         append([]string{}, t.Tags...),
+		DueDate:      &due,
+		Priority:     t.Priority,
+		Recurrence:   rule.String(),
+		Subtasks:     subtasks,
+		AutoComplete: t.AutoComplete,
+	}, true, nil
+}
+
+// recurrenceWorker spawns the next occurrence of recurring tasks once they
+// are marked done. Handlers hand it task IDs; the work happens on a single
+// background goroutine so requests don't wait for it.
+type recurrenceWorker struct {
+	repo  TaskRepository
+	queue chan string
+	quit  chan struct{}
+	done  chan struct{}
+}
+
+func startRecurrenceWorker(repo TaskRepository) *recurrenceWorker {
+	w := &recurrenceWorker{
+		repo:  repo,
+		queue: make(chan string, 256),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// enqueue schedules t for a recurrence check if it is a completed recurring
+// task. It never blocks; if the queue is full the spawn is skipped and logged.
+func (w *recurrenceWorker) enqueue(t Task) {
+	if !t.Done || t.Recurrence == "" || t.DeletedAt != nil {
+		return
+	}
+	select {
+	case w.queue <- t.ID:
+	default:
+		slog.Warn("recurrence queue full, next occurrence not created", "task_id", t.ID)
+	}
+}
+
+// stop waits for the task being processed, if any, and stops the worker.
+// The queue is left open so that late enqueues from handlers still running
+// after a timed-out shutdown can't panic.
+func (w *recurrenceWorker) stop() {
+	close(w.quit)
+	<-w.done
+}
+
+func (w *recurrenceWorker) run() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.quit:
+			return
+		case id := <-w.queue:
+			if err := w.spawn(context.Background(), id); err != nil {
+				slog.Error("creating next occurrence failed", "task_id", id, "error", err)
+			}
+		}
+	}
+}
+
+func (w *recurrenceWorker) spawn(ctx context.Context, id string) error {
+	// Re-read the task: it may have changed since it was queued, and a task
+	// that already spawned its successor no longer carries the rule.
+	t, err := w.repo.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !t.Done || t.Recurrence == "" || t.DeletedAt != nil {
+		return nil
+	}
+
+	next, ok, err := nextOccurrence(t)
+	if err != nil {
+		return err
+	}
+
+	// The rule moves off t before its successor exists, so that the two
+	// never both carry it, and t steps out of the unique title check the
+	// successor is about to take its place in.
+	rule := t.Recurrence
+	t.Recurrence = ""
+	t.Succeeded = ok
+	if err := w.repo.Update(ctx, t); err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	// Not held to the owner's task quota: the series was accepted when the
+	// task was created, and a failure here would only be logged.
+	if _, err := w.repo.Create(ctx, next, 0); err != nil {
+		// Give t its rule back so that completing it again retries.
+		t.Recurrence, t.Succeeded = rule, false
+		if undoErr := w.repo.Update(ctx, t); undoErr != nil {
+			slog.Error("restoring recurrence failed", "task_id", t.ID, "error", undoErr)
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+)
+
+// defaultRedactFields are the field names whose values never reach the logs.
+// key carries a new API key in the response to POST /me/api-keys and
+// csrf_token the token of GET /csrf-token.
+const defaultRedactFields = "password,new_password,current_password,api_key,token,key,csrf_token"
+
+// redactedValue replaces the value of a redacted field.
+const redactedValue = "***"
+
+// redactor replaces the values of sensitive fields, matched by name without
+// regard to case, with redactedValue.
+type redactor struct {
+	fields map[string]bool
+}
+
+func newRedactor(fields []string) redactor {
+	r := redactor{fields: make(map[string]bool, len(fields))}
+	for _, f := range fields {
+		r.fields[strings.ToLower(f)] = true
+	}
+	return r
+}
+
+func (r redactor) sensitive(name string) bool {
+	return r.fields[strings.ToLower(name)]
+}
+
+// attr redacts a log attribute: its whole value if its key is sensitive,
+// the sensitive members of a group, or the sensitive fields of a JSON
+// document carried as a string or byte slice.
+func (r redactor) attr(a slog.Attr) slog.Attr {
+	if r.sensitive(a.Key) {
+		return slog.String(a.Key, redactedValue)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := v.Group()
+		attrs := make([]slog.Attr, len(group))
+		for i, ga := range group {
+			attrs[i] = r.attr(ga)
+		}
+		return slog.Attr{Key: a.Key, Value: slog.GroupValue(attrs...)}
+	case slog.KindString:
+		if doc, ok := r.json([]byte(v.String())); ok {
+			return slog.String(a.Key, string(doc))
+		}
+	case slog.KindAny:
+		switch b := v.Any().(type) {
+		case []byte:
+			if doc, ok := r.json(b); ok {
+				return slog.String(a.Key, string(doc))
+			}
+		case json.RawMessage:
+			if doc, ok := r.json(b); ok {
+				return slog.Any(a.Key, json.RawMessage(doc))
+			}
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
+
+// json redacts the sensitive fields, at any depth, of b if it is a JSON
+// object or array, and reports whether it was one.
+func (r redactor) json(b []byte) ([]byte, bool) {
+	trimmed := bytes.TrimSpace(b)
+	if len(trimmed) == 0 || (trimmed[0] != '{' && trimmed[0] != '[') {
+		return nil, false
+	}
+	dec := json.NewDecoder(bytes.NewReader(trimmed))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, false
+	}
+	out, err := json.Marshal(r.value(doc))
+	if err != nil {
+		return nil, false
+	}
+	return out, true
+}
+
+func (r redactor) value(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, member := range v {
+			if r.sensitive(k) {
+				v[k] = redactedValue
+			} else {
+				v[k] = r.value(member)
+			}
+		}
+	case []any:
+		for i, el := range v {
+			v[i] = r.value(el)
+		}
+	}
+	return v
+}
+
+// redactingHandler is a slog.Handler that redacts every attribute before
+// passing records on, so that no log line, whichever code writes it, can
+// carry the value of a sensitive field.
+type redactingHandler struct {
+	next     slog.Handler
+	redactor redactor
+}
+
+func newRedactingHandler(next slog.Handler, fields []string) *redactingHandler {
+	return &redactingHandler{next: next, redactor: newRedactor(fields)}
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, rec slog.Record) error {
+	out := slog.NewRecord(rec.Time, rec.Level, rec.Message, rec.PC)
+	rec.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.redactor.attr(a))
+		return true
+	})
+	return h.next.Handle(ctx, out)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	redacted := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		redacted[i] = h.redactor.attr(a)
+	}
+	return &redactingHandler{next: h.next.WithAttrs(redacted), redactor: h.redactor}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name), redactor: h.redactor}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// newRedisPool builds a connection pool for the Redis session store and
+// checks that the server is reachable with a PING, retrying as retry says
+// while it isn't up yet, so a bad address or password fails startup instead
+// of the first request.
+func newRedisPool(ctx context.Context, addr, password string, db int, retry RetryConfig) (*redis.Pool, error) {
+	pool := &redis.Pool{
+		MaxIdle:     10,
+		IdleTimeout: 240 * time.Second,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", addr,
+				redis.DialPassword(password),
+				redis.DialDatabase(db),
+				redis.DialConnectTimeout(5*time.Second),
+			)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+
+	err := withRetry(ctx, retry, "redis", func(ctx context.Context) error {
+		conn, err := pool.GetContext(ctx)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.Do("PING")
+		return err
+	})
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("connecting to redis at %s: %w", addr, err)
+	}
+	return pool, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// ReminderConfig controls due-date reminders.
+type ReminderConfig struct {
+	// Notifier is "log", "smtp" or "none", which disables reminders.
+	Notifier string
+	// LeadTime is how long before its due date a task is reminded of.
+	LeadTime time.Duration
+	// Interval is how often due tasks are looked for.
+	Interval time.Duration
+	SMTP     SMTPConfig
+}
+
+// SMTPConfig is the mail server reminders are sent through. Username and
+// Password are optional; when set, PLAIN authentication is used, which
+// net/smtp only allows over TLS or to localhost.
+type SMTPConfig struct {
+	// Addr is the server's host:port.
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+// Notifier delivers a reminder that task is due soon to user. Notify is only
+// ever called from the reminder worker's goroutine.
+type Notifier interface {
+	Notify(ctx context.Context, user User, task Task) error
+}
+
+// newNotifier returns the Notifier selected by cfg, or nil for "none".
+func newNotifier(cfg ReminderConfig) Notifier {
+	switch cfg.Notifier {
+	case "log":
+		return LogNotifier{}
+	case "smtp":
+		return NewSMTPNotifier(cfg.SMTP)
+	}
+	return nil
+}
+
+// LogNotifier writes reminders to the server log. It is meant for
+// development and for deployments that forward logs to something else.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, user User, task Task) error {
+	slog.Info("task due soon", "user_id", user.ID, "username", user.Username, "task_id", task.ID, "title", task.Title, "due_date", task.DueDate)
+	return nil
+}
+
+// SMTPNotifier emails reminders. Accounts have no separate email address,
+// so users whose username isn't one are skipped.
+type SMTPNotifier struct {
+	cfg  SMTPConfig
+	auth smtp.Auth
+}
+
+func NewSMTPNotifier(cfg SMTPConfig) *SMTPNotifier {
+	n := &SMTPNotifier{cfg: cfg}
+	if cfg.Username != "" {
+		host, _, _ := net.SplitHostPort(cfg.Addr)
+		n.auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+	return n
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, user User, task Task) error {
+	to, err := mail.ParseAddress(user.Username)
+	if err != nil {
+		slog.Debug("not emailing reminder: username is not an address", "user_id", user.ID, "task_id", task.ID)
+		return nil
+	}
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", n.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", to.String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mimeHeader("Reminder: "+task.Title))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Your task %q is due %s.\r\n", task.Title, task.DueDate.UTC().Format(time.RFC1123))
+	if task.Description != "" {
+		fmt.Fprintf(&msg, "\r\n%s\r\n", strings.ReplaceAll(task.Description, "\n", "\r\n"))
+	}
+	return smtp.SendMail(n.cfg.Addr, n.auth, n.cfg.From, []string{to.Address}, []byte(msg.String()))
+}
+
+// mimeHeader encodes s for a header if it isn't plain ASCII, and strips
+// line breaks either way so that a task title can't inject headers.
+func mimeHeader(s string) string {
+	s = strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+	for _, r := range s {
+		if r >= 0x80 {
+			return mime.QEncoding.Encode("utf-8", s)
+		}
+	}
+	return s
+}
+
+// startReminderWorker notifies the owner and any assignee of each task due
+// within lead, checking every interval. A reminder is claimed with
+// MarkReminded before it is sent, so a failed send is logged and not retried
+// rather than risking a duplicate. The returned function stops the worker
+// and waits for a running scan to finish.
+func startReminderWorker(repo TaskRepository, users UserStore, notifier Notifier, lead, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			now := time.Now()
+			tasks, err := repo.DueForReminder(ctx, now, now.Add(lead))
+			if err != nil {
+				slog.Error("finding tasks to remind of failed", "error", err)
+				continue
+			}
+			for _, t := range tasks {
+				if ctx.Err() != nil {
+					return
+				}
+				sendReminder(ctx, repo, users, notifier, t, now)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func sendReminder(ctx context.Context, repo TaskRepository, users UserStore, notifier Notifier, t Task, now time.Time) {
+	claimed, err := repo.MarkReminded(ctx, t.ID, now.UTC())
+	if err != nil {
+		if !errors.Is(err, ErrTaskNotFound) {
+			slog.Error("marking reminder sent failed", "task_id", t.ID, "error", err)
+		}
+		return
+	}
+	if !claimed {
+		return
+	}
+	recipients := []int{t.OwnerID}
+	if t.AssigneeID != nil && *t.AssigneeID != t.OwnerID {
+		recipients = append(recipients, *t.AssigneeID)
+	}
+	for _, id := range recipients {
+		user, err := users.Get(ctx, id)
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			slog.Error("loading user to remind failed", "user_id", id, "task_id", t.ID, "error", err)
+			continue
+		}
+		if err := notifier.Notify(ctx, user, t); err != nil {
+			slog.Error("sending reminder failed", "user_id", id, "task_id", t.ID, "error", err)
+		}
+	}
+}
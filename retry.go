@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// maxRetryDelay caps the wait between two attempts of withRetry.
+const maxRetryDelay = 30 * time.Second
+
+// RetryConfig is how often, and how patiently, boot tries to reach an
+// external store before giving up.
+type RetryConfig struct {
+	// MaxAttempts counts the first attempt; 1 means no retries.
+	MaxAttempts int
+	// BaseDelay is the wait after the first failure. It doubles after each
+	// further one, up to maxRetryDelay.
+	BaseDelay time.Duration
+}
+
+// withRetry calls fn until it succeeds, it has been called MaxAttempts
+// times, or ctx is done, backing off exponentially between attempts. Each
+// failure is logged with what is being attempted. It returns the last error
+// of fn, or ctx's error once ctx is done.
+func withRetry(ctx context.Context, cfg RetryConfig, what string, fn func(ctx context.Context) error) error {
+	delay := cfg.BaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			if attempt > 1 {
+				slog.Info("connected after retrying", "target", what, "attempt", attempt)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt >= cfg.MaxAttempts {
+			slog.Error("connecting failed, giving up", "target", what, "attempt", attempt, "max_attempts", cfg.MaxAttempts, "error", err)
+			return err
+		}
+		slog.Warn("connecting failed, retrying", "target", what, "attempt", attempt, "max_attempts", cfg.MaxAttempts, "retry_in", delay.String(), "error", err)
+
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		delay = min(2*delay, maxRetryDelay)
+	}
+}
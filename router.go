@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// routeTable records the routes registered on a router's muxes. The muxes
+// are nested behind middleware and each ends in a catch-all mount, so none of
+// them can tell on its own that a path exists with other methods; check
+// answers for all of them from the table.
+type routeTable struct {
+	// methods lists the methods registered for each path pattern; "" means
+	// any method.
+	methods map[string][]string
+}
+
+func newRouteTable() *routeTable {
+	return &routeTable{methods: make(map[string][]string)}
+}
+
+// routeMux is a ServeMux whose routes are recorded in a routeTable.
+type routeMux struct {
+	*http.ServeMux
+	table *routeTable
+}
+
+// newMux returns an empty mux recording its routes in t.
+func (t *routeTable) newMux() routeMux {
+	return routeMux{ServeMux: http.NewServeMux(), table: t}
+}
+
+// Handle registers a route. Patterns are "[METHOD ]PATH" as for ServeMux.
+func (m routeMux) Handle(pattern string, h http.Handler) {
+	m.ServeMux.Handle(pattern, h)
+	method, path, ok := strings.Cut(pattern, " ")
+	if !ok {
+		method, path = "", pattern
+	}
+	m.table.methods[path] = append(m.table.methods[path], method)
+}
+
+func (m routeMux) HandleFunc(pattern string, f func(http.ResponseWriter, *http.Request)) {
+	m.Handle(pattern, http.HandlerFunc(f))
+}
+
+// mount passes every request under prefix to h, a handler with routes of
+// its own, without recording prefix as a route.
+func (m routeMux) mount(prefix string, h http.Handler) {
+	m.ServeMux.Handle(prefix, h)
+}
+
+// check answers requests for unknown paths with a 404 and requests whose path
+// is known but whose method isn't with a 405 listing the allowed methods in
+// Allow, both before next runs, so that neither depends on which middleware
+// the route sits behind. All routes must be registered first.
+func (t *routeTable) check(next http.Handler) http.Handler {
+	// A mux of the bare paths finds the pattern a path belongs to with the
+	// same precedence rules the real muxes apply.
+	paths := http.NewServeMux()
+	probe := new(routeProbe)
+	for path := range t.methods {
+		paths.Handle(path, probe)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h, path := paths.Handler(r)
+		if path == "" {
+			writeError(w, http.StatusNotFound, CodeNotFound, "no such endpoint")
+			return
+		}
+		if h != probe {
+			// A redirect to the canonical path, which the real mux sends too.
+			next.ServeHTTP(w, r)
+			return
+		}
+		methods := t.methods[path]
+		if allowed := allowedMethods(methods); allowed != nil && !slices.Contains(allowed, r.Method) {
+			methodNotAllowed(w, r, allowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// methodNotAllowed answers a request whose path exists with other methods.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed []string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method "+r.Method+" not allowed")
+}
+
+// subRoutes serves the routes under one pattern ending in {sub}, such as
+// /tasks/{id}/{sub}, by the value of that segment and the method, with the
+// 404 and 405 check gives for the others. A pattern with a literal in
+// place of a wildcard can then share the mux: ServeMux rejects
+// /tasks/from-template/{templateID} beside /tasks/{id}/restore, as neither
+// is more specific, but not beside /tasks/{id}/{sub}.
+type subRoutes map[string]map[string]http.Handler
+
+// subRoutes registers pattern for any method and returns the routes to
+// serve under it.
+func (m routeMux) subRoutes(pattern string) subRoutes {
+	s := make(subRoutes)
+	m.Handle(pattern, s)
+	return s
+}
+
+// Handle registers a route for method where {sub} is sub.
+func (s subRoutes) Handle(method, sub string, h http.Handler) {
+	if s[sub] == nil {
+		s[sub] = make(map[string]http.Handler)
+	}
+	s[sub][method] = h
+}
+
+func (s subRoutes) HandleFunc(method, sub string, f func(http.ResponseWriter, *http.Request)) {
+	s.Handle(method, sub, http.HandlerFunc(f))
+}
+
+func (s subRoutes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handlers, ok := s[r.PathValue("sub")]
+	if !ok {
+		writeError(w, http.StatusNotFound, CodeNotFound, "no such endpoint")
+		return
+	}
+	h, ok := handlers[r.Method]
+	if !ok && r.Method == http.MethodHead {
+		h, ok = handlers[http.MethodGet]
+	}
+	if !ok {
+		methods := make([]string, 0, len(handlers))
+		for method := range handlers {
+			methods = append(methods, method)
+		}
+		methodNotAllowed(w, r, allowedMethods(methods))
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// routeProbe marks the routes of check's path mux.
+type routeProbe struct{}
+
+func (*routeProbe) ServeHTTP(http.ResponseWriter, *http.Request) {}
+
+// allowedMethods returns the sorted methods a path accepts, including HEAD
+// wherever GET is, as ServeMux does, or nil if it accepts any method.
+func allowedMethods(methods []string) []string {
+	if slices.Contains(methods, "") {
+		return nil
+	}
+	allowed := slices.Clone(methods)
+	if slices.Contains(allowed, http.MethodGet) && !slices.Contains(allowed, http.MethodHead) {
+		allowed = append(allowed, http.MethodHead)
+	}
+	slices.Sort(allowed)
+	return slices.Compact(allowed)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+)
+
+// selfCheckTimeout bounds the whole startup self-check.
+const selfCheckTimeout = 10 * time.Second
+
+// selfCheck writes, reads back and deletes a throwaway record in the session
+// store, the task repository and the attachment blob store, so that a
+// read-only path, wrong credentials or a missing table fail the boot instead
+// of the first request that needs them.
+func selfCheck(store scs.Store, repo TaskRepository, blobs BlobStore) error {
+	ctx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+	defer cancel()
+	var errs []error
+	if err := checkSessionStore(store); err != nil {
+		errs = append(errs, fmt.Errorf("session store: %w", err))
+	}
+	if err := checkTaskRepo(ctx, repo); err != nil {
+		errs = append(errs, fmt.Errorf("task repository: %w", err))
+	}
+	if err := checkBlobStore(ctx, blobs); err != nil {
+		errs = append(errs, fmt.Errorf("attachment store: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+func checkSessionStore(store scs.Store) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	token, payload := "selfcheck-"+hex.EncodeToString(b), []byte("selfcheck")
+	if err := store.Commit(token, payload, time.Now().Add(time.Minute)); err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+	got, found, err := store.Find(token)
+	if err != nil {
+		return fmt.Errorf("reading: %w", err)
+	}
+	if !found || !bytes.Equal(got, payload) {
+		return errors.New("reading: the record just written was not found")
+	}
+	if err := store.Delete(token); err != nil {
+		return fmt.Errorf("deleting: %w", err)
+	}
+	return nil
+}
+
+func checkTaskRepo(ctx context.Context, repo TaskRepository) error {
+	t, err := repo.Create(ctx, Task{Title: "startup self-check", Tags: []string{}, Subtasks: []Subtask{}, Attachments: []Attachment{}, Priority: PriorityMedium}, 0)
+	if err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+	if _, err := repo.Get(ctx, t.ID); err != nil {
+		return fmt.Errorf("reading: %w", err)
+	}
+	if err := repo.Delete(ctx, t.ID); err != nil {
+		return fmt.Errorf("deleting: %w", err)
+	}
+	return nil
+}
+
+func checkBlobStore(ctx context.Context, blobs BlobStore) error {
+	id, err := newTaskID()
+	if err != nil {
+		return err
+	}
+	key, payload := "selfcheck/"+id, []byte("selfcheck")
+	if _, err := blobs.Put(ctx, key, bytes.NewReader(payload)); err != nil {
+		return fmt.Errorf("writing: %w", err)
+	}
+	rc, err := blobs.Open(ctx, key)
+	if err != nil {
+		return fmt.Errorf("reading: %w", err)
+	}
+	got, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return fmt.Errorf("reading: %w", err)
+	}
+	if !bytes.Equal(got, payload) {
+		return errors.New("reading: the blob read back differs from the one written")
+	}
+	if err := blobs.Delete(ctx, key); err != nil {
+		return fmt.Errorf("deleting: %w", err)
+	}
+	return nil
+}
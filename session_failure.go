@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+)
+
+// Session store failure modes, set by SESSION_STORE_FAILURE_MODE.
+const (
+	// sessionFailStrict answers a request whose session can't be loaded with
+	// a 500.
+	sessionFailStrict = "strict"
+	// sessionFailDegrade serves such a request as if it carried no session,
+	// so that public endpoints keep working while the store is down.
+	sessionFailDegrade = "degrade"
+)
+
+// sessionLoad tracks how far LoadAndSave got with one request.
+type sessionLoad struct {
+	// loaded is set once the session is loaded and the request is being
+	// served; store errors after that are from committing it.
+	loaded bool
+	// failed is set when loading failed in degrade mode and the request is
+	// to be served again without its session cookie.
+	failed bool
+	// degraded is set while it is.
+	degraded bool
+}
+
+// loadSessions is sessionManager.LoadAndSave with mode applied to session
+// store failures.
+func loadSessions(mode string, next http.Handler) http.Handler {
+	if mode != sessionFailDegrade {
+		return sessionManager.LoadAndSave(next)
+	}
+	withSession := sessionManager.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l, ok := r.Context().Value(sessionLoadKey).(*sessionLoad); ok {
+			l.loaded = true
+		}
+		next.ServeHTTP(w, r)
+	}))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := &sessionLoad{}
+		r = r.WithContext(context.WithValue(r.Context(), sessionLoadKey, l))
+		withSession.ServeHTTP(w, r)
+		if l.failed {
+			// Without a token scs starts a new session without asking
+			// the store. Anything that then changes the session, such
+			// as logging in, still fails when it is committed.
+			l.degraded = true
+			withSession.ServeHTTP(w, withoutCookie(r, sessionManager.Cookie.Name))
+		}
+	})
+}
+
+// sessionStoreError is the sessionManager's ErrorFunc. It logs every store
+// error, and in degrade mode hands a failed load back to loadSessions
+// instead of answering.
+func sessionStoreError(w http.ResponseWriter, r *http.Request, err error) {
+	l, _ := r.Context().Value(sessionLoadKey).(*sessionLoad)
+	degrade := l != nil && !l.loaded && !l.degraded
+	slog.Warn("session store failed",
+		"request_id", requestIDFromContext(r.Context()),
+		"error", err,
+		"degraded", degrade)
+	if degrade {
+		l.failed = true
+		return
+	}
+	writeError(w, http.StatusInternalServerError, CodeInternal, "internal server error")
+}
+
+// withoutCookie returns a copy of r without the cookie called name.
+func withoutCookie(r *http.Request, name string) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.Header.Del("Cookie")
+	for _, c := range r.Cookies() {
+		if c.Name != name {
+			r2.AddCookie(c)
+		}
+	}
+	return r2
+}
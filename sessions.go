@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// lastSeenResolution limits how often requireAuth rewrites a session just to
+// bump its last-seen time.
+const lastSeenResolution = time.Minute
+
+// Session keys of the logged-in user.
+const (
+	sessionUserIDKey = "userID"
+	sessionRoleKey   = "role"
+)
+
+// putSessionUser logs u in on the session of ctx.
+func putSessionUser(ctx context.Context, u User) {
+	sessionManager.Put(ctx, sessionUserIDKey, u.ID)
+	sessionManager.Put(ctx, sessionRoleKey, u.Role)
+}
+
+// sessionUserID returns the ID of the user logged in on the session of ctx,
+// or 0 if there is none.
+func sessionUserID(ctx context.Context) int {
+	return int(sessionInt64(ctx, sessionUserIDKey))
+}
+
+// sessionInt64 returns the integer stored in the session under key, or 0.
+// scs's gob codec hands values back with the type they were Put with, but
+// a store or codec going through JSON turns numbers into float64 or
+// json.Number, which GetInt and GetInt64 treat as missing.
+func sessionInt64(ctx context.Context, key string) int64 {
+	switch v := sessionManager.Get(ctx, key).(type) {
+	case int:
+		return int64(v)
+	case int64:
+		return v
+	case int32:
+		return int64(v)
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) <= 1<<53 {
+			return int64(v)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+	}
+	return 0
+}
+
+// recordSessionStart stores the metadata shown by GET /me/sessions. It is
+// called at login.
+func recordSessionStart(ctx context.Context, r *http.Request) {
+	now := time.Now().Unix()
+	sessionManager.Put(ctx, "createdAt", now)
+	sessionManager.Put(ctx, "lastSeen", now)
+	sessionManager.Put(ctx, "userAgent", coarseUserAgent(r.UserAgent()))
+}
+
+// touchSession updates the session's last-seen time, at most once per
+// lastSeenResolution so that most requests don't rewrite the session.
+func touchSession(ctx context.Context) {
+	now := time.Now()
+	if last := sessionInt64(ctx, "lastSeen"); now.Sub(time.Unix(last, 0)) >= lastSeenResolution {
+		sessionManager.Put(ctx, "lastSeen", now.Unix())
+	}
+}
+
+// sessionID identifies a session in the API without exposing its token,
+// which would be as good as the session itself.
+func sessionID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}
+
+// sessionInfo describes one of the user's sessions.
+type sessionInfo struct {
+	ID        string     `json:"id"`
+	Current   bool       `json:"current"`
+	UserAgent string     `json:"user_agent"`
+	CreatedAt *time.Time `json:"created_at"`
+	LastSeen  *time.Time `json:"last_seen"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+// errStopIteration ends a sessionManager.Iterate loop early.
+var errStopIteration = errors.New("stop iteration")
+
+// forEachUserSession calls fn with the context of every stored session that
+// belongs to userID; fn may return errStopIteration to stop early. It fails
+// if the session store can't be enumerated.
+func forEachUserSession(ctx context.Context, userID int, fn func(ctx context.Context, token string) error) error {
+	err := sessionManager.Iterate(ctx, func(sctx context.Context) error {
+		if sessionUserID(sctx) != userID {
+			return nil
+		}
+		return fn(sctx, sessionManager.Token(sctx))
+	})
+	if errors.Is(err, errStopIteration) {
+		return nil
+	}
+	return err
+}
+
+func unixTime(v int64) *time.Time {
+	if v == 0 {
+		return nil
+	}
+	t := time.Unix(v, 0).UTC()
+	return &t
+}
+
+// listSessionsHandler lists the logged-in user's active sessions, most
+// recently used first.
+func listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current := sessionManager.Token(r.Context())
+	sessions := make([]sessionInfo, 0)
+	err := forEachUserSession(r.Context(), currentUser(r.Context()).ID, func(ctx context.Context, token string) error {
+		sessions = append(sessions, sessionInfo{
+			ID:        sessionID(token),
+			Current:   token == current,
+			UserAgent: sessionManager.GetString(ctx, "userAgent"),
+			CreatedAt: unixTime(sessionInt64(ctx, "createdAt")),
+			LastSeen:  unixTime(sessionInt64(ctx, "lastSeen")),
+			ExpiresAt: sessionManager.Deadline(ctx).UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := sessions[i].LastSeen, sessions[j].LastSeen
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.After(*b)
+	})
+	encode(w, r, http.StatusOK, map[string][]sessionInfo{"sessions": sessions})
+}
+
+// revokeSessionHandler destroys one of the logged-in user's other sessions.
+// The current session is ended with POST /logout instead.
+func revokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if id == sessionID(sessionManager.Token(r.Context())) {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "use POST /logout to end the current session")
+		return
+	}
+
+	found := false
+	err := forEachUserSession(r.Context(), currentUser(r.Context()).ID, func(ctx context.Context, token string) error {
+		if sessionID(token) != id {
+			return nil
+		}
+		found = true
+		if err := sessionManager.Destroy(ctx); err != nil {
+			return err
+		}
+		return errStopIteration
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, CodeNotFound, "session not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// coarseUserAgent reduces a User-Agent header to "<browser> on <os>", which
+// is enough for users to recognise their devices.
+func coarseUserAgent(ua string) string {
+	browser := "Unknown browser"
+	for _, b := range []struct{ token, name string }{
+		// Order matters: Edge and Opera also claim to be Chrome, and
+		// Chrome claims to be Safari.
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	} {
+		if strings.Contains(ua, b.token) {
+			browser = b.name
+			break
+		}
+	}
+
+	os := "unknown OS"
+	for _, o := range []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iOS"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	} {
+		if strings.Contains(ua, o.token) {
+			os = o.name
+			break
+		}
+	}
+	return browser + " on " + os
+}
+
+// warnForeignHost logs a warning, once, when a request arrives on a host
+// that the session cookie, scoped to domain, isn't sent to: logins from
+// there seem to work but the browser drops the cookie. An empty domain,
+// which keeps the cookie to whatever host set it, disables the check.
+func warnForeignHost(domain string, next http.Handler) http.Handler {
+	if domain == "" {
+		return next
+	}
+	var warned atomic.Bool
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if !cookieDomainMatches(domain, host) && warned.CompareAndSwap(false, true) {
+			slog.Warn("request host is outside SESSION_COOKIE_DOMAIN, browsers will not keep its session cookie; further requests are not reported",
+				"host", host, "cookie_domain", domain)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
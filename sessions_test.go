@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/postgresstore"
+	"github.com/alexedwards/scs/redisstore"
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+)
+
+// jsonCodec encodes sessions as JSON, which hands numbers back as float64.
+type jsonCodec struct{}
+
+type jsonSession struct {
+	Deadline time.Time              `json:"deadline"`
+	Values   map[string]interface{} `json:"values"`
+}
+
+func (jsonCodec) Encode(deadline time.Time, values map[string]interface{}) ([]byte, error) {
+	return json.Marshal(jsonSession{Deadline: deadline, Values: values})
+}
+
+func (jsonCodec) Decode(b []byte) (time.Time, map[string]interface{}, error) {
+	var s jsonSession
+	err := json.Unmarshal(b, &s)
+	return s.Deadline, s.Values, err
+}
+
+// sessionStores returns a constructor for the session store of each
+// backend. Redis and PostgreSQL skip the test unless TEST_REDIS_ADDR or
+// TEST_DATABASE_URL name a server to use; the PostgreSQL database gets
+// migrated.
+func sessionStores() map[string]func(t *testing.T) scs.Store {
+	return map[string]func(t *testing.T) scs.Store{
+		"memory": func(t *testing.T) scs.Store {
+			store := memstore.NewWithCleanupInterval(0)
+			t.Cleanup(store.StopCleanup)
+			return store
+		},
+		"sqlite": func(t *testing.T) scs.Store {
+			store, err := NewSQLiteStore(filepath.Join(t.TempDir(), "sessions.db"), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+		"redis": func(t *testing.T) scs.Store {
+			addr := os.Getenv("TEST_REDIS_ADDR")
+			if addr == "" {
+				t.Skip("TEST_REDIS_ADDR is not set")
+			}
+			pool, err := newRedisPool(context.Background(), addr, os.Getenv("TEST_REDIS_PASSWORD"), 0, RetryConfig{MaxAttempts: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { pool.Close() })
+			return redisstore.New(pool)
+		},
+		"postgres": func(t *testing.T) scs.Store {
+			url := os.Getenv("TEST_DATABASE_URL")
+			if url == "" {
+				t.Skip("TEST_DATABASE_URL is not set")
+			}
+			ctx := context.Background()
+			db, err := openPostgres(ctx, url, 2, 2, time.Minute, RetryConfig{MaxAttempts: 1})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { db.Close() })
+			if err := runMigrations(ctx, db); err != nil {
+				t.Fatal(err)
+			}
+			return postgresstore.NewWithCleanupInterval(db, 0)
+		},
+	}
+}
+
+func TestSessionUserRoundTrip(t *testing.T) {
+	saved := sessionManager
+	t.Cleanup(func() { sessionManager = saved })
+
+	codecs := map[string]scs.Codec{"gob": scs.GobCodec{}, "json": jsonCodec{}}
+	for backend, newStore := range sessionStores() {
+		for codecName, codec := range codecs {
+			t.Run(backend+"/"+codecName, func(t *testing.T) {
+				sessionManager = scs.New()
+				sessionManager.Store = newStore(t)
+				sessionManager.Codec = codec
+
+				ctx, err := sessionManager.Load(context.Background(), "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				putSessionUser(ctx, User{ID: 42, Role: RoleAdmin})
+				sessionManager.Put(ctx, "workspaceID", 7)
+				token, _, err := sessionManager.Commit(ctx)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				ctx, err = sessionManager.Load(context.Background(), token)
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { sessionManager.Store.Delete(token) })
+				if got := sessionUserID(ctx); got != 42 {
+					t.Errorf("sessionUserID = %d, want 42", got)
+				}
+				if got := sessionManager.GetString(ctx, sessionRoleKey); got != RoleAdmin {
+					t.Errorf("role = %q, want %q", got, RoleAdmin)
+				}
+				if got := sessionInt64(ctx, "workspaceID"); got != 7 {
+					t.Errorf("workspaceID = %d, want 7", got)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore is an scs.Store backed by a local SQLite file. It lets sessions
+// survive restarts without running a separate database server.
+type SQLiteStore struct {
+	db          *sql.DB
+	stopCleanup chan struct{}
+}
+
+// NewSQLiteStore opens (or creates) the SQLite database at path, makes sure the
+// sessions table exists and starts a background goroutine that removes expired
+// sessions every cleanupInterval. A zero interval disables the cleanup.
+func NewSQLiteStore(path string, cleanupInterval time.Duration) (*SQLiteStore, error) {
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)")
+	if err != nil {
+		return nil, err
+	}
+	// SQLite only allows a single writer; serialising access through one
+	// connection avoids "database is locked" errors under concurrent requests.
+	db.SetMaxOpenConns(1)
+
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS sessions (
+		token  TEXT PRIMARY KEY,
+		data   BLOB NOT NULL,
+		expiry INTEGER NOT NULL
+	)`)
+	if err == nil {
+		_, err = db.Exec(`CREATE INDEX IF NOT EXISTS sessions_expiry_idx ON sessions (expiry)`)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	s := &SQLiteStore{db: db}
+	if cleanupInterval > 0 {
+		s.stopCleanup = make(chan struct{})
+		go s.startCleanup(cleanupInterval)
+	}
+	return s, nil
+}
+
+// Find returns the data for a given session token. If the token is not found
+// or has expired, found is false.
+func (s *SQLiteStore) Find(token string) ([]byte, bool, error) {
+	var b []byte
+	err := s.db.QueryRow(`SELECT data FROM sessions WHERE token = ? AND expiry > ?`,
+		token, time.Now().UnixNano()).Scan(&b)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit adds or replaces the session data for the given token.
+func (s *SQLiteStore) Commit(token string, b []byte, expiry time.Time) error {
+	_, err := s.db.Exec(`INSERT INTO sessions (token, data, expiry) VALUES (?, ?, ?)
+		ON CONFLICT (token) DO UPDATE SET data = excluded.data, expiry = excluded.expiry`,
+		token, b, expiry.UnixNano())
+	return err
+}
+
+// Delete removes the session for the given token. Deleting a token that does
+// not exist is not an error.
+func (s *SQLiteStore) Delete(token string) error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE token = ?`, token)
+	return err
+}
+
+// All returns the data of every unexpired session keyed by token. It
+// implements scs.IterableStore.
+func (s *SQLiteStore) All() (map[string][]byte, error) {
+	rows, err := s.db.Query(`SELECT token, data FROM sessions WHERE expiry > ?`, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := make(map[string][]byte)
+	for rows.Next() {
+		var token string
+		var data []byte
+		if err := rows.Scan(&token, &data); err != nil {
+			return nil, err
+		}
+		sessions[token] = data
+	}
+	return sessions, rows.Err()
+}
+
+// Ping checks that the database file is still usable.
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	return s.db.PingContext(ctx)
+}
+
+// Close stops the cleanup goroutine and closes the underlying database.
+func (s *SQLiteStore) Close() error {
+	if s.stopCleanup != nil {
+		close(s.stopCleanup)
+	}
+	return s.db.Close()
+}
+
+func (s *SQLiteStore) startCleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.deleteExpired(); err != nil {
+				log.Printf("sqlite session store: cleanup failed: %v", err)
+			}
+		case <-s.stopCleanup:
+			return
+		}
+	}
+}
+
+func (s *SQLiteStore) deleteExpired() error {
+	_, err := s.db.Exec(`DELETE FROM sessions WHERE expiry <= ?`, time.Now().UnixNano())
+	return err
+}
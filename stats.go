@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// adminStats is the body of GET /admin/stats.
+type adminStats struct {
+	Users int           `json:"users"`
+	Tasks adminTaskStat `json:"tasks"`
+	// ActiveSessions counts the stored sessions someone is logged in with.
+	ActiveSessions int    `json:"active_sessions"`
+	StoreBackend   string `json:"store_backend"`
+}
+
+type adminTaskStat struct {
+	// Total counts every task, Trashed the ones in the trash among them.
+	Total   int `json:"total"`
+	Trashed int `json:"trashed"`
+	// CreatedLast24h and CreatedLast7d count the tasks created in the last
+	// day and week, in the trash or not.
+	CreatedLast24h int `json:"created_last_24h"`
+	CreatedLast7d  int `json:"created_last_7d"`
+}
+
+// adminStatsHandler returns an overview of the instance. The task numbers
+// are counted by the task repository, with aggregate queries on PostgreSQL,
+// without loading any task.
+func adminStatsHandler(storeBackend string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		stats := adminStats{StoreBackend: storeBackend}
+		var err error
+		if stats.Users, err = userStore.Count(ctx); err != nil {
+			serverError(w, err)
+			return
+		}
+
+		trashed, err := taskRepo.Count(ctx, ListOptions{Trashed: true, IncludeArchived: true})
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		if stats.Tasks.Total, err = countWithTrash(ctx, ListOptions{}); err != nil {
+			serverError(w, err)
+			return
+		}
+		stats.Tasks.Trashed = trashed.Total
+		now := time.Now().UTC()
+		for _, window := range []struct {
+			n   *int
+			age time.Duration
+		}{
+			{&stats.Tasks.CreatedLast24h, 24 * time.Hour},
+			{&stats.Tasks.CreatedLast7d, 7 * 24 * time.Hour},
+		} {
+			since := now.Add(-window.age)
+			if *window.n, err = countWithTrash(ctx, ListOptions{CreatedAfter: &since}); err != nil {
+				serverError(w, err)
+				return
+			}
+		}
+
+		if stats.ActiveSessions, err = countLoggedInSessions(ctx); err != nil {
+			serverError(w, err)
+			return
+		}
+		encode(w, r, http.StatusOK, stats)
+	}
+}
+
+// countLoggedInSessions counts the stored sessions with a logged-in user, as
+// opposed to those only holding a CSRF token, unlike the sessions gauge of
+// /metrics.
+func countLoggedInSessions(ctx context.Context) (int, error) {
+	n := 0
+	err := sessionManager.Iterate(ctx, func(sctx context.Context) error {
+		if sessionUserID(sctx) != 0 {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// maxSubtasks bounds the checklist of a single task.
+const maxSubtasks = 100
+
+// Subtask is one step of a task's checklist.
+type Subtask struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+}
+
+// completion returns the percentage of t's subtasks that are done, rounded
+// down, or nil if t has none.
+func (t Task) completion() *int {
+	if len(t.Subtasks) == 0 {
+		return nil
+	}
+	done := 0
+	for _, s := range t.Subtasks {
+		if s.Done {
+			done++
+		}
+	}
+	pct := done * 100 / len(t.Subtasks)
+	return &pct
+}
+
+// MarshalJSON adds the computed completion percentage to the task's fields
+// and writes its timestamps in taskTimeFormat.
+func (t Task) MarshalJSON() ([]byte, error) {
+	return t.marshalJSON(taskTimeFormat)
+}
+
+func (t Task) marshalJSON(format string) ([]byte, error) {
+	// taskFields and attachmentFields have the fields of Task and
+	// Attachment but not their methods, so this doesn't recurse; the fields
+	// declared below replace their timestamps.
+	type taskFields Task
+	type attachmentFields Attachment
+	type attachment struct {
+		attachmentFields
+		CreatedAt taskTime `json:"created_at"`
+	}
+	var attachments []attachment
+	if t.Attachments != nil {
+		attachments = make([]attachment, len(t.Attachments))
+		for i, a := range t.Attachments {
+			attachments[i] = attachment{attachmentFields(a), taskTime{a.CreatedAt, format}}
+		}
+	}
+	return json.Marshal(struct {
+		taskFields
+		DueDate        *taskTime    `json:"due_date"`
+		ReminderSentAt *taskTime    `json:"reminder_sent_at"`
+		Attachments    []attachment `json:"attachments"`
+		CreatedAt      taskTime     `json:"created_at"`
+		CompletedAt    *taskTime    `json:"completed_at"`
+		DeletedAt      *taskTime    `json:"deleted_at"`
+		ArchivedAt     *taskTime    `json:"archived_at"`
+		Completion     *int         `json:"completion"`
+	}{
+		taskFields:     taskFields(t),
+		DueDate:        optionalTaskTime(t.DueDate, format),
+		ReminderSentAt: optionalTaskTime(t.ReminderSentAt, format),
+		Attachments:    attachments,
+		CreatedAt:      taskTime{t.CreatedAt, format},
+		CompletedAt:    optionalTaskTime(t.CompletedAt, format),
+		DeletedAt:      optionalTaskTime(t.DeletedAt, format),
+		ArchivedAt:     optionalTaskTime(t.ArchivedAt, format),
+		Completion:     t.completion(),
+	})
+}
+
+// subtaskIndex returns the position of the subtask with the given ID, or -1.
+func (t Task) subtaskIndex(id string) int {
+	for i, s := range t.Subtasks {
+		if s.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// autoComplete marks t done if it opted in with AutoComplete and every
+// subtask is done. It runs after checklist changes only, so a task can still
+// be reopened by hand, and it never reopens a task.
+func (t *Task) autoComplete() {
+	if !t.AutoComplete || t.Done || len(t.Subtasks) == 0 {
+		return
+	}
+	for _, s := range t.Subtasks {
+		if !s.Done {
+			return
+		}
+	}
+	t.setDone(true)
+}
+
+// subtaskInput is the body of POST /tasks/{id}/subtasks.
+type subtaskInput struct {
+	Title string `json:"title"`
+	Done  bool   `json:"done"`
+}
+
+// subtaskPatch is the body of PATCH /tasks/{id}/subtasks/{subID}. Nil fields
+// are left unchanged.
+type subtaskPatch struct {
+	Title *string `json:"title"`
+	Done  *bool   `json:"done"`
+}
+
+// createSubtaskHandler appends a subtask to the checklist of one of the
+// current user's tasks and returns the updated task.
+func createSubtaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadOwnedTask(w, r)
+	if !ok || !checkIfMatch(w, r, task) {
+		return
+	}
+
+	var in subtaskInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	errs := validationErrors{}
+	validateTitle(errs, in.Title)
+	if err := errs.orNil(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+	if len(task.Subtasks) >= maxSubtasks {
+		writeError(w, http.StatusConflict, CodeConflict, fmt.Sprintf("a task can have at most %d subtasks", maxSubtasks))
+		return
+	}
+
+	id, err := newTaskID()
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	task.Subtasks = append(task.Subtasks, Subtask{ID: id, Title: in.Title, Done: in.Done})
+	saveSubtasks(w, r, task, http.StatusCreated)
+}
+
+// patchSubtaskHandler renames a subtask or checks it off.
+func patchSubtaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadOwnedTask(w, r)
+	if !ok || !checkIfMatch(w, r, task) {
+		return
+	}
+	i := task.subtaskIndex(r.PathValue("subID"))
+	if i < 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, "subtask not found")
+		return
+	}
+
+	var in subtaskPatch
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.Title != nil {
+		errs := validationErrors{}
+		validateTitle(errs, *in.Title)
+		if err := errs.orNil(); err != nil {
+			writeValidationErrors(w, err, -1)
+			return
+		}
+		task.Subtasks[i].Title = *in.Title
+	}
+	if in.Done != nil {
+		task.Subtasks[i].Done = *in.Done
+	}
+	saveSubtasks(w, r, task, http.StatusOK)
+}
+
+// deleteSubtaskHandler removes a subtask from the checklist.
+func deleteSubtaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadOwnedTask(w, r)
+	if !ok || !checkIfMatch(w, r, task) {
+		return
+	}
+	i := task.subtaskIndex(r.PathValue("subID"))
+	if i < 0 {
+		writeError(w, http.StatusNotFound, CodeNotFound, "subtask not found")
+		return
+	}
+	task.Subtasks = append(task.Subtasks[:i], task.Subtasks[i+1:]...)
+	saveSubtasks(w, r, task, http.StatusOK)
+}
+
+// saveSubtasks stores task after a checklist change, completing it first if
+// that was the last open subtask, and writes it with the given status.
+func saveSubtasks(w http.ResponseWriter, r *http.Request, task Task, status int) {
+	task.autoComplete()
+	if err := taskRepo.Update(r.Context(), task); err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	recurringTasks.enqueue(task)
+	writeTask(w, r, status, task.in(displayZone(w, r)))
+}
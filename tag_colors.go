@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// tagPalette is the set of colors tags get by default, picked by a hash of
+// the tag name so that every client shows a tag in the same color.
+var tagPalette = []string{
+	"#e6194b", "#3cb44b", "#ffe119", "#4363d8", "#f58231", "#911eb4",
+	"#46f0f0", "#f032e6", "#bcf60c", "#fabebe", "#008080", "#9a6324",
+}
+
+// hexColor matches a color as #rrggbb.
+var hexColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// defaultTagColor returns the palette color of a normalized tag.
+func defaultTagColor(tag string) string {
+	h := fnv.New32a()
+	h.Write([]byte(tag))
+	return tagPalette[h.Sum32()%uint32(len(tagPalette))]
+}
+
+// TagColorStore stores the colors users chose for their tags. A tag may have
+// a color before any task carries it. Implementations must be safe for
+// concurrent use.
+type TagColorStore interface {
+	// SetColor sets the color ownerID shows the normalized tag in.
+	SetColor(ctx context.Context, ownerID int, tag, color string) error
+	// Colors returns the colors ownerID chose, by tag.
+	Colors(ctx context.Context, ownerID int) (map[string]string, error)
+	// DeleteByOwner removes all of ownerID's colors.
+	DeleteByOwner(ctx context.Context, ownerID int) error
+}
+
+// MemoryTagColorStore is an in-memory TagColorStore. Data is lost on
+// restart.
+type MemoryTagColorStore struct {
+	mu     sync.RWMutex
+	colors map[int]map[string]string
+}
+
+// NewMemoryTagColorStore returns an empty MemoryTagColorStore.
+func NewMemoryTagColorStore() *MemoryTagColorStore {
+	return &MemoryTagColorStore{colors: make(map[int]map[string]string)}
+}
+
+func (s *MemoryTagColorStore) SetColor(ctx context.Context, ownerID int, tag, color string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.colors[ownerID] == nil {
+		s.colors[ownerID] = make(map[string]string)
+	}
+	s.colors[ownerID][tag] = color
+	return nil
+}
+
+func (s *MemoryTagColorStore) Colors(ctx context.Context, ownerID int) (map[string]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make(map[string]string, len(s.colors[ownerID]))
+	for tag, color := range s.colors[ownerID] {
+		out[tag] = color
+	}
+	return out, nil
+}
+
+func (s *MemoryTagColorStore) DeleteByOwner(ctx context.Context, ownerID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.colors, ownerID)
+	return nil
+}
+
+// tagColors returns the color of each of tags for ownerID: the one they
+// chose, or the default.
+func tagColors(ctx context.Context, ownerID int, tags []string) (map[string]string, error) {
+	chosen, err := tagColorStore.Colors(ctx, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]string, len(tags))
+	for _, tag := range tags {
+		if color, ok := chosen[tag]; ok {
+			out[tag] = color
+		} else {
+			out[tag] = defaultTagColor(tag)
+		}
+	}
+	return out, nil
+}
+
+// tagColorInput is the body of PUT /tags/{name}/color.
+type tagColorInput struct {
+	Color string `json:"color"`
+}
+
+// tagColor is the response of PUT /tags/{name}/color.
+type tagColor struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// setTagColorHandler sets the color the current user shows a tag in. The
+// color is lowercased.
+func setTagColorHandler(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(strings.TrimSpace(r.PathValue("name")))
+	if tag == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "tag name is required")
+		return
+	}
+	var in tagColorInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	errs := validationErrors{}
+	if utf8.RuneCountInString(tag) > maxTagLen {
+		errs["name"] = fmt.Sprintf("must be at most %d characters", maxTagLen)
+	}
+	if !hexColor.MatchString(in.Color) {
+		errs["color"] = "must be a hex color such as #1e90ff"
+	}
+	if err := errs.orNil(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+	color := strings.ToLower(in.Color)
+	if err := tagColorStore.SetColor(r.Context(), currentUser(r.Context()).ID, tag, color); err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, tagColor{Name: tag, Color: color})
+}
+
+// tagPaletteHandler returns the colors tags get by default.
+func tagPaletteHandler(w http.ResponseWriter, r *http.Request) {
+	encode(w, r, http.StatusOK, map[string][]string{"palette": tagPalette})
+}
@@ -0,0 +1,1043 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// taskInput is the JSON body accepted by the create and update endpoints.
+// Subtasks are managed through their own endpoints and are left alone by
+// updates.
+type taskInput struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Done        bool     `json:"done"`
+	Tags        []string `json:"tags"`
+	// DueDate is an RFC3339 timestamp; it is parsed by hand so that a bad
+	// value can be reported against the field name.
+	DueDate *string `json:"due_date"`
+	// Priority defaults to medium when omitted.
+	Priority     string `json:"priority"`
+	Recurrence   string `json:"recurrence"`
+	AutoComplete bool   `json:"auto_complete"`
+}
+
+func createTaskHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r.Context()).ID
+
+	var in taskInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+
+	t, err := taskFromInput(userID, activeWorkspaceRef(r.Context()), in)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	// A client retrying with the same Idempotency-Key gets the task created
+	// by its first attempt instead of a duplicate.
+	key := r.Header.Get("Idempotency-Key")
+	var entry *idempotencyEntry
+	if key != "" {
+		var replayed bool
+		if entry, replayed = replayIdempotentCreate(w, r, userID, key, in); replayed {
+			return
+		}
+	}
+
+	task, err := taskRepo.Create(r.Context(), t, taskQuota(currentUser(r.Context())))
+	if err != nil {
+		if entry != nil {
+			idempotencyKeys.release(userID, key, entry)
+		}
+		taskRepoError(w, err)
+		return
+	}
+	if entry != nil {
+		idempotencyKeys.complete(entry, task.ID)
+	}
+	recurringTasks.enqueue(task)
+	auditLog.record(r, userID, AuditTaskCreate, task.ID)
+	encode(w, r, http.StatusCreated, task.in(displayZone(w, r)))
+}
+
+// taskFromInput validates in and builds the new task it describes for userID
+// in workspaceID, or as a personal task if it is nil.
+func taskFromInput(userID int, workspaceID *int, in taskInput) (Task, error) {
+	due, err := parseOptionalTime("due_date", in.DueDate)
+	if err != nil {
+		return Task{}, err
+	}
+	priority, err := normalizePriority(in.Priority)
+	if err != nil {
+		return Task{}, err
+	}
+	recurrence, err := normalizeRecurrence(in.Recurrence)
+	if err != nil {
+		return Task{}, err
+	}
+
+	t := Task{
+		OwnerID:      userID,
+		WorkspaceID:  workspaceID,
+		Title:        in.Title,
+		Description:  in.Description,
+		Tags:         normalizeTags(in.Tags),
+		DueDate:      due,
+		Priority:     priority,
+		Recurrence:   recurrence,
+		Subtasks:     []Subtask{},
+		Attachments:  []Attachment{},
+		AutoComplete: in.AutoComplete,
+	}
+	t.setDone(in.Done)
+	return t, nil
+}
+
+// bulkCreateTasksHandler creates up to maxTasks tasks from a JSON array in a
+// single all-or-nothing operation. The created tasks are returned in request
+// order. With ?dry_run=true nothing is created; the response lists every
+// rejected task and the tasks that would be created, without IDs.
+func bulkCreateTasksHandler(maxTasks int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := currentUser(r.Context()).ID
+		workspaceID := activeWorkspaceRef(r.Context())
+
+		dryRun, err := parseDryRun(r.URL.Query())
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		var in []taskInput
+		if !decodeJSON(w, r, &in) {
+			return
+		}
+		if len(in) == 0 {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "request body must be a non-empty array of tasks")
+			return
+		}
+		if len(in) > maxTasks {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("at most %d tasks can be created at once, got %d", maxTasks, len(in)))
+			return
+		}
+
+		tasks, rejected := buildTasks(in, dryRun, func(ti taskInput) (Task, error) {
+			return taskFromInput(userID, workspaceID, ti)
+		})
+		if dryRun {
+			writeBulkCreateDryRun(w, r, tasks, rejected)
+			return
+		}
+		if len(rejected) > 0 {
+			writeRejection(w, rejected[0])
+			return
+		}
+
+		created, err := taskRepo.CreateMany(r.Context(), tasks, taskQuota(currentUser(r.Context())))
+		if err != nil {
+			taskRepoError(w, err)
+			return
+		}
+		for _, t := range created {
+			recurringTasks.enqueue(t)
+			auditLog.record(r, userID, AuditTaskCreate, t.ID)
+		}
+		encode(w, r, http.StatusCreated, tasksIn(created, displayZone(w, r)))
+	}
+}
+
+// bulkCreateDryRun is the response to POST /tasks/bulk?dry_run=true. Items
+// are the valid tasks as they would be created, and Created how many would
+// be: all of them, or none if anything would fail.
+type bulkCreateDryRun struct {
+	dryRunResult
+	Items   []Task `json:"items"`
+	Created int    `json:"created"`
+}
+
+func writeBulkCreateDryRun(w http.ResponseWriter, r *http.Request, tasks []Task, rejected []apiError) {
+	quotaErr, err := previewQuota(r.Context(), currentUser(r.Context()), len(tasks), 0)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	for i := range tasks {
+		tasks[i].CreatedAt = now
+	}
+	res := bulkCreateDryRun{dryRunResult: newDryRunResult(rejected, quotaErr), Items: tasksIn(tasks, displayZone(w, r))}
+	if res.WouldSucceed {
+		res.Created = len(tasks)
+	}
+	encode(w, r, http.StatusOK, res)
+}
+
+// replayIdempotentCreate reserves key for userID. If the key was already used
+// it writes the response instead (the original task, a 422 for a different
+// body, or an error) and reports replayed=true. Otherwise the caller owns the
+// returned entry and must complete or release it.
+func replayIdempotentCreate(w http.ResponseWriter, r *http.Request, userID int, key string, in taskInput) (e *idempotencyEntry, replayed bool) {
+	if len(key) > maxIdempotencyKeyLen {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLen))
+		return nil, true
+	}
+	body, err := json.Marshal(in)
+	if err != nil {
+		serverError(w, err)
+		return nil, true
+	}
+	fingerprint := sha256.Sum256(body)
+
+	for {
+		e, owner := idempotencyKeys.reserve(userID, key, fingerprint)
+		if owner {
+			return e, false
+		}
+		if e.fingerprint != fingerprint {
+			writeError(w, http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "Idempotency-Key was already used with a different request body")
+			return nil, true
+		}
+		// Wait for a concurrent request with the same key to finish.
+		select {
+		case <-e.ready:
+		case <-r.Context().Done():
+			return nil, true
+		}
+		id := idempotencyKeys.result(e)
+		if id == "" {
+			// The first attempt failed and released the key; try to take it.
+			continue
+		}
+		task, err := taskRepo.Get(r.Context(), id)
+		if err != nil {
+			taskRepoError(w, err)
+			return nil, true
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		encode(w, r, http.StatusCreated, task.in(displayZone(w, r)))
+		return nil, true
+	}
+}
+
+// Pagination defaults for list endpoints.
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+// taskPage is the envelope returned by list endpoints.
+type taskPage struct {
+	Items  []Task `json:"items"`
+	Total  int    `json:"total"`
+	Limit  int    `json:"limit"`
+	Offset int    `json:"offset"`
+}
+
+// taskCursorPage is the envelope returned by GET /tasks when paginating by
+// cursor. NextCursor is nil on the last page.
+type taskCursorPage struct {
+	Items      []Task  `json:"items"`
+	Total      int     `json:"total"`
+	Limit      int     `json:"limit"`
+	NextCursor *string `json:"next_cursor"`
+}
+
+// listTasksHandler lists the tasks of the active workspace, or the current
+// user's personal tasks, as a JSON page or, when negotiated, as a CSV export.
+// The CSV export includes every matching task unless limit is given
+// explicitly. Archived tasks are left out unless include_archived=true.
+//
+// Pages are selected by offset, or, if the cursor parameter is present, by
+// cursor: an empty cursor starts at the first task and each page carries the
+// cursor of the next. Cursor pages don't shift when tasks are added or
+// removed in between.
+func listTasksHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r.Context()).ID
+
+	format, err := negotiateFormat(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	opts, err := parseListOptions(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if err := scopeListOptions(&opts, q, userID, activeWorkspaceID(r.Context())); err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	byCursor, err := parseCursor(q, &opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	if format == formatCSV && q.Get("limit") == "" {
+		opts.Limit = 0
+	}
+	limit := opts.Limit
+	if byCursor && limit > 0 {
+		// One more than asked for tells whether there is a next page.
+		opts.Limit++
+	}
+
+	tasks, total, err := taskRepo.List(r.Context(), opts)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	var next *string
+	if byCursor && limit > 0 && len(tasks) > limit {
+		tasks = tasks[:limit]
+		last := tasks[limit-1]
+		token := encodeCursor(ListCursor{CreatedAt: last.CreatedAt, ID: last.ID}, opts.Sort)
+		next = &token
+	}
+	w.Header().Add("Vary", "Accept")
+	if format == formatCSV {
+		if err := writeTasksCSV(w, tasks); err != nil {
+			// Headers are already sent; all we can do is record it.
+			slog.Warn("writing CSV export failed", "request_id", requestIDFromContext(r.Context()), "error", err)
+		}
+		return
+	}
+	tasks = tasksIn(tasks, displayZone(w, r))
+	if byCursor {
+		encode(w, r, http.StatusOK, taskCursorPage{Items: tasks, Total: total, Limit: limit, NextCursor: next})
+		return
+	}
+	encode(w, r, http.StatusOK, taskPage{Items: tasks, Total: total, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+// parseCursor reads the cursor query parameter into opts.After and reports
+// whether the request paginates by cursor. Cursors only apply to the
+// created_at orders and can't be combined with an offset.
+func parseCursor(q url.Values, opts *ListOptions) (bool, error) {
+	if !q.Has("cursor") {
+		return false, nil
+	}
+	if q.Has("offset") {
+		return true, errors.New("cursor and offset cannot be combined")
+	}
+	if opts.Sort != SortByCreatedAt && opts.Sort != SortByCreatedAtDesc {
+		return true, errors.New("cursor requires sort created_at or -created_at")
+	}
+	if token := q.Get("cursor"); token != "" {
+		c, err := decodeCursor(token, opts.Sort)
+		if err != nil {
+			return true, err
+		}
+		opts.After = &c
+	}
+	return true, nil
+}
+
+// countTasksHandler returns how many of the tasks GET /tasks would list match
+// its filters, split into done, pending and overdue, for badges and
+// dashboards that don't need the tasks themselves.
+func countTasksHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts, err := parseListOptions(q)
+	if err == nil {
+		err = scopeListOptions(&opts, q, currentUser(r.Context()).ID, activeWorkspaceID(r.Context()))
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	counts, err := taskRepo.Count(r.Context(), opts)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, counts)
+}
+
+// scopeListOptions restricts opts to the tasks of workspaceID, or to userID's
+// personal tasks if it is 0. With ?assigned_to=me it instead selects the
+// tasks there assigned to userID, whoever created them.
+func scopeListOptions(opts *ListOptions, q url.Values, userID, workspaceID int) error {
+	switch q.Get("assigned_to") {
+	case "":
+		scopeToWorkspace(opts, userID, workspaceID)
+	case "me":
+		if workspaceID != 0 {
+			opts.WorkspaceID = workspaceID
+		} else {
+			opts.Personal = true
+		}
+		opts.AssigneeID = userID
+	default:
+		return errors.New("assigned_to must be me")
+	}
+	return nil
+}
+
+// scopeToWorkspace restricts opts to the tasks of workspaceID or, if it is 0,
+// to userID's personal tasks.
+func scopeToWorkspace(opts *ListOptions, userID, workspaceID int) {
+	if workspaceID != 0 {
+		opts.WorkspaceID = workspaceID
+		return
+	}
+	opts.OwnerID = userID
+	opts.Personal = true
+}
+
+func searchTasksHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r.Context()).ID
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "q must not be empty")
+		return
+	}
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	tasks, err := taskRepo.Search(r.Context(), userID, activeWorkspaceID(r.Context()), query)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	sortTasks(tasks, opts.Sort)
+	encode(w, r, http.StatusOK, taskPage{
+		Items:  tasksIn(paginate(tasks, opts.Limit, opts.Offset), displayZone(w, r)),
+		Total:  len(tasks),
+		Limit:  opts.Limit,
+		Offset: opts.Offset,
+	})
+}
+
+// parsePage reads the limit and offset query parameters of a list endpoint.
+func parsePage(q url.Values) (limit, offset int, err error) {
+	limit = defaultPageLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return 0, 0, fmt.Errorf("limit must be an integer between 1 and %d", maxPageLimit)
+		}
+		limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, 0, fmt.Errorf("offset must be a non-negative integer")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
+
+// parseListOptions reads the pagination, sort and filter query parameters.
+func parseListOptions(q url.Values) (ListOptions, error) {
+	opts := ListOptions{Sort: SortByCreatedAt}
+	var err error
+	opts.Limit, opts.Offset, err = parsePage(q)
+	if err != nil {
+		return opts, err
+	}
+	opts.Tags = normalizeTags(q["tag"])
+	for _, v := range q["priority"] {
+		if v == "" {
+			continue
+		}
+		p, err := normalizePriority(v)
+		if err != nil {
+			return opts, err
+		}
+		opts.Priorities = append(opts.Priorities, p)
+	}
+	if v := q.Get("overdue"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("overdue must be true or false")
+		}
+		opts.Overdue = b
+	}
+	if v := q.Get("include_archived"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return opts, fmt.Errorf("include_archived must be true or false")
+		}
+		opts.IncludeArchived = b
+	}
+	if opts.DueBefore, err = parseTimeParam(q, "due_before"); err != nil {
+		return opts, err
+	}
+	if opts.DueAfter, err = parseTimeParam(q, "due_after"); err != nil {
+		return opts, err
+	}
+	if v := q.Get("sort"); v != "" {
+		if !validSort(v) {
+			return opts, fmt.Errorf("sort must be one of created_at, -created_at, title, -title, priority, -priority, position, -position")
+		}
+		opts.Sort = v
+	}
+	return opts, nil
+}
+
+// parseOptionalTime parses an RFC3339 timestamp from a JSON body field. A nil
+// or empty value yields a nil time.
+func parseOptionalTime(field string, v *string) (*time.Time, error) {
+	if v == nil || *v == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, *v)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an RFC3339 timestamp", field)
+	}
+	t = t.UTC()
+	return &t, nil
+}
+
+// parseTimeParam parses an optional RFC3339 query parameter.
+func parseTimeParam(q url.Values, name string) (*time.Time, error) {
+	v := q.Get(name)
+	if v == "" {
+		return nil, nil
+	}
+	return parseOptionalTime(name, &v)
+}
+
+// listTagsHandler returns the distinct tags used in the active workspace, or
+// by the current user's personal tasks, with the color the current user
+// shows each in.
+func listTagsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r.Context()).ID
+
+	tags, err := taskRepo.Tags(r.Context(), userID, activeWorkspaceID(r.Context()))
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	colors, err := tagColors(r.Context(), userID, tags)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, tagList{Tags: tags, Colors: colors})
+}
+
+// tagList is the body of GET /tags.
+type tagList struct {
+	Tags []string `json:"tags"`
+	// Colors maps each of Tags to its color.
+	Colors map[string]string `json:"colors"`
+}
+
+// getTaskHandler returns a task with its ETag and answers 304 Not Modified
+// when If-None-Match names the current one.
+func getTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadVisibleTask(w, r)
+	if !ok {
+		return
+	}
+	etag := taskETag(task)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeTask(w, r, http.StatusOK, task.in(displayZone(w, r)))
+}
+
+func updateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadOwnedTask(w, r)
+	if !ok || !checkIfMatch(w, r, task) {
+		return
+	}
+
+	var in taskInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+
+	task.Title = in.Title
+	task.Description = in.Description
+	due, err := parseOptionalTime("due_date", in.DueDate)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	priority, err := normalizePriority(in.Priority)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	recurrence, err := normalizeRecurrence(in.Recurrence)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+
+	task.Tags = normalizeTags(in.Tags)
+	task.setDueDate(due)
+	task.Priority = priority
+	task.AutoComplete = in.AutoComplete
+	task.Recurrence = recurrence
+	task.setDone(in.Done)
+	if err := taskRepo.Update(r.Context(), task); err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	recurringTasks.enqueue(task)
+	writeTask(w, r, http.StatusOK, task.in(displayZone(w, r)))
+}
+
+// taskPatch is the JSON body accepted by PATCH /tasks/{id}. Nil fields were
+// absent from the request and are left unchanged.
+type taskPatch struct {
+	Title        *string   `json:"title"`
+	Description  *string   `json:"description"`
+	Done         *bool     `json:"done"`
+	Tags         *[]string `json:"tags"`
+	DueDate      *string   `json:"due_date"`
+	Priority     *string   `json:"priority"`
+	Recurrence   *string   `json:"recurrence"`
+	AutoComplete *bool     `json:"auto_complete"`
+}
+
+// mergePatchType is the media type of RFC 7386 JSON Merge Patch documents.
+const mergePatchType = "application/merge-patch+json"
+
+// decodeMergePatch decodes an RFC 7386 merge patch of a task into in. Plain
+// JSON bodies can't tell a null from an absent field, but here a null resets
+// the field: the due date is cleared, the description, tags and recurrence
+// are emptied, the priority goes back to medium and done and auto_complete
+// to false. The title can't be null. If it returns false a response has
+// already been written.
+func decodeMergePatch(w http.ResponseWriter, r *http.Request, in *taskPatch) bool {
+	var members map[string]json.RawMessage
+	if !decodeJSON(w, r, &members) {
+		return false
+	}
+	if members == nil {
+		writeError(w, http.StatusBadRequest, CodeInvalidJSON, "merge patch must be a JSON object")
+		return false
+	}
+	var nulls []string
+	for name, v := range members {
+		if string(v) == "null" {
+			nulls = append(nulls, name)
+			delete(members, name)
+		}
+	}
+	// The remaining members are decoded as strictly as any other body.
+	rest, err := json.Marshal(members)
+	if err != nil {
+		serverError(w, err)
+		return false
+	}
+	if !decodeJSONFrom(w, bytes.NewReader(rest), in) {
+		return false
+	}
+
+	empty := ""
+	for _, name := range nulls {
+		switch name {
+		case "title":
+			writeValidationErrors(w, validationErrors{"title": "must not be null"}, -1)
+			return false
+		case "description":
+			in.Description = &empty
+		case "tags":
+			in.Tags = &[]string{}
+		case "due_date":
+			in.DueDate = &empty
+		case "priority":
+			in.Priority = &empty
+		case "recurrence":
+			in.Recurrence = &empty
+		case "done":
+			in.Done = new(bool)
+		case "auto_complete":
+			in.AutoComplete = new(bool)
+		default:
+			writeError(w, http.StatusBadRequest, CodeInvalidJSON, fmt.Sprintf("unknown field %q", name))
+			return false
+		}
+	}
+	return true
+}
+
+// patchTaskHandler changes the fields present in the body. Bodies sent as
+// application/merge-patch+json follow RFC 7386 (see decodeMergePatch); any
+// other JSON body is a partial update in which null means "unchanged".
+func patchTaskHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", "application/json, "+mergePatchType)
+	task, ok := loadOwnedTask(w, r)
+	if !ok || !checkIfMatch(w, r, task) {
+		return
+	}
+
+	var in taskPatch
+	decode := func() bool { return decodeJSON(w, r, &in) }
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == mergePatchType {
+		decode = func() bool { return decodeMergePatch(w, r, &in) }
+	}
+	if !decode() {
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+
+	if in.Title != nil {
+		task.Title = *in.Title
+	}
+	if in.Description != nil {
+		task.Description = *in.Description
+	}
+	if in.Done != nil {
+		task.setDone(*in.Done)
+	}
+	if in.Tags != nil {
+		task.Tags = normalizeTags(*in.Tags)
+	}
+	if in.DueDate != nil {
+		due, err := parseOptionalTime("due_date", in.DueDate)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		task.setDueDate(due)
+	}
+	if in.Priority != nil {
+		priority, err := normalizePriority(*in.Priority)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		task.Priority = priority
+	}
+	if in.Recurrence != nil {
+		recurrence, err := normalizeRecurrence(*in.Recurrence)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+			return
+		}
+		task.Recurrence = recurrence
+	}
+	if in.AutoComplete != nil {
+		task.AutoComplete = *in.AutoComplete
+	}
+	if err := taskRepo.Update(r.Context(), task); err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	recurringTasks.enqueue(task)
+	writeTask(w, r, http.StatusOK, task.in(displayZone(w, r)))
+}
+
+// deleteTaskHandler moves a task to the trash, or with ?hard=true removes it
+// (whether or not it is in the trash) permanently.
+func deleteTaskHandler(w http.ResponseWriter, r *http.Request) {
+	hard := false
+	if v := r.URL.Query().Get("hard"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, CodeBadRequest, "hard must be true or false")
+			return
+		}
+		hard = b
+	}
+
+	actorID := currentUser(r.Context()).ID
+	if hard {
+		task, ok := loadOwnedTaskOrTrashed(w, r)
+		if !ok {
+			return
+		}
+		if err := taskRepo.Delete(r.Context(), task.ID); err != nil {
+			taskRepoError(w, err)
+			return
+		}
+		auditLog.recordDetail(r, actorID, AuditTaskDelete, task.ID, deleteDetail("hard", actorID, task))
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	task, ok := loadOwnedTask(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now().UTC()
+	task.DeletedAt = &now
+	if err := taskRepo.Update(r.Context(), task); err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	auditLog.recordDetail(r, actorID, AuditTaskDelete, task.ID, deleteDetail("", actorID, task))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// deleteDetail qualifies the audit entry of a task deletion: kind, "hard"
+// or "", followed by the task's owner when someone else in its workspace
+// deleted it, as in "hard owner=7".
+func deleteDetail(kind string, actorID int, t Task) string {
+	if t.OwnerID == actorID {
+		return kind
+	}
+	return strings.TrimSpace(kind + " owner=" + strconv.Itoa(t.OwnerID))
+}
+
+// listTrashHandler lists the tasks in the trash of the active workspace, or
+// the current user's, archived or not. It accepts the same filters as GET
+// /tasks.
+func listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r.Context()).ID
+
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	scopeToWorkspace(&opts, userID, activeWorkspaceID(r.Context()))
+	opts.Trashed = true
+	opts.IncludeArchived = true
+
+	tasks, total, err := taskRepo.List(r.Context(), opts)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, taskPage{Items: tasksIn(tasks, displayZone(w, r)), Total: total, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+// restoreTaskHandler takes a task back out of the trash.
+func restoreTaskHandler(w http.ResponseWriter, r *http.Request) {
+	task, ok := loadOwnedTaskOrTrashed(w, r)
+	if !ok {
+		return
+	}
+	if task.DeletedAt == nil {
+		writeError(w, http.StatusConflict, CodeConflict, "task is not in the trash")
+		return
+	}
+	task.DeletedAt = nil
+	if err := taskRepo.Update(r.Context(), task); err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, task.in(displayZone(w, r)))
+}
+
+// listArchivedHandler lists the archived tasks of the active workspace, or
+// the current user's, leaving out those in the trash. It accepts the same
+// filters as GET /tasks.
+func listArchivedHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUser(r.Context()).ID
+
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	scopeToWorkspace(&opts, userID, activeWorkspaceID(r.Context()))
+	opts.Archived = true
+	opts.IncludeArchived = false
+
+	tasks, total, err := taskRepo.List(r.Context(), opts)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, taskPage{Items: tasksIn(tasks, displayZone(w, r)), Total: total, Limit: opts.Limit, Offset: opts.Offset})
+}
+
+// archiveTaskHandler archives a task, done or not. Archived tasks stay out
+// of GET /tasks unless ?include_archived=true is given.
+func archiveTaskHandler(w http.ResponseWriter, r *http.Request) {
+	setArchived(w, r, true, "task is already archived")
+}
+
+// unarchiveTaskHandler takes a task back out of the archive.
+func unarchiveTaskHandler(w http.ResponseWriter, r *http.Request) {
+	setArchived(w, r, false, "task is not archived")
+}
+
+// setArchived archives or unarchives the task of the request, answering 409
+// Conflict with conflict if it already is in that state.
+func setArchived(w http.ResponseWriter, r *http.Request, archived bool, conflict string) {
+	task, ok := loadOwnedTask(w, r)
+	if !ok {
+		return
+	}
+	if task.Archived == archived {
+		writeError(w, http.StatusConflict, CodeConflict, conflict)
+		return
+	}
+	task.setArchived(archived)
+	if err := taskRepo.Update(r.Context(), task); err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, task.in(displayZone(w, r)))
+}
+
+// copySuffix is appended to the title of a duplicated task.
+const copySuffix = " (copy)"
+
+// duplicateTaskHandler creates a copy of a task for the current user, in the
+// same workspace: its title with copySuffix, description, tags, priority and
+// subtasks, all not done. The copy has no due date and is not done itself.
+func duplicateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r.Context())
+	src, ok := loadOwnedTask(w, r)
+	if !ok {
+		return
+	}
+
+	// The title is shortened, if need be, to leave room for the suffix.
+	title := []rune(src.Title)
+	if n := maxTitleLen - utf8.RuneCountInString(copySuffix); len(title) > n {
+		title = title[:n]
+	}
+	t := Task{
+		OwnerID:     user.ID,
+		WorkspaceID: src.WorkspaceID,
+		Title:       strings.TrimSpace(string(title)) + copySuffix,
+		Description: src.Description,
+		Tags:        append([]string{}, src.Tags...),
+		Priority:    src.Priority,
+		Subtasks:    make([]Subtask, 0, len(src.Subtasks)),
+		Attachments: []Attachment{},
+	}
+	for _, s := range src.Subtasks {
+		id, err := newTaskID()
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		t.Subtasks = append(t.Subtasks, Subtask{ID: id, Title: s.Title})
+	}
+
+	task, err := taskRepo.Create(r.Context(), t, taskQuota(user))
+	if err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	auditLog.record(r, user.ID, AuditTaskCreate, task.ID)
+	encode(w, r, http.StatusCreated, task.in(displayZone(w, r)))
+}
+
+// loadOwnedTask fetches the task named by the {id} path segment and checks
+// that it belongs to the active workspace, whose members all share its
+// tasks, or with none active that it is a personal task of the current user.
+// Tasks in the trash are reported as not found. If it returns false a
+// response has already been written.
+func loadOwnedTask(w http.ResponseWriter, r *http.Request) (Task, bool) {
+	task, ok := loadOwnedTaskOrTrashed(w, r)
+	if ok && task.DeletedAt != nil {
+		taskRepoError(w, ErrTaskNotFound)
+		return Task{}, false
+	}
+	return task, ok
+}
+
+// loadVisibleTask is loadOwnedTask for read-only endpoints, which the task's
+// assignee may use too.
+func loadVisibleTask(w http.ResponseWriter, r *http.Request) (Task, bool) {
+	userID := currentUser(r.Context()).ID
+
+	id, ok := taskIDParam(w, r)
+	if !ok {
+		return Task{}, false
+	}
+	task, err := taskRepo.Get(r.Context(), id)
+	if err == nil && task.DeletedAt != nil {
+		err = ErrTaskNotFound
+	}
+	if err != nil {
+		taskRepoError(w, err)
+		return Task{}, false
+	}
+	if !inActiveWorkspace(r.Context(), task) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "task is not in the active workspace")
+		return Task{}, false
+	}
+	if task.WorkspaceID == nil && task.OwnerID != userID && (task.AssigneeID == nil || *task.AssigneeID != userID) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "task belongs to another user")
+		return Task{}, false
+	}
+	return task, true
+}
+
+// loadOwnedTaskOrTrashed is loadOwnedTask for endpoints that also act on
+// tasks in the trash.
+func loadOwnedTaskOrTrashed(w http.ResponseWriter, r *http.Request) (Task, bool) {
+	userID := currentUser(r.Context()).ID
+
+	id, ok := taskIDParam(w, r)
+	if !ok {
+		return Task{}, false
+	}
+	task, err := taskRepo.Get(r.Context(), id)
+	if err != nil {
+		taskRepoError(w, err)
+		return Task{}, false
+	}
+	if !inActiveWorkspace(r.Context(), task) {
+		writeError(w, http.StatusForbidden, CodeForbidden, "task is not in the active workspace")
+		return Task{}, false
+	}
+	if task.WorkspaceID == nil && task.OwnerID != userID {
+		writeError(w, http.StatusForbidden, CodeForbidden, "task belongs to another user")
+		return Task{}, false
+	}
+	return task, true
+}
+
+// taskIDParam returns the {id} path segment of a single-task route. If it
+// returns false a 400 has already been written.
+func taskIDParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, err := parseTaskID(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return "", false
+	}
+	return id, true
+}
+
+// taskRepoError maps repository errors to HTTP responses.
+func taskRepoError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrTaskNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "task not found")
+		return
+	}
+	if errors.Is(err, ErrQuotaExceeded) || errors.Is(err, ErrDuplicateTitle) {
+		writeError(w, http.StatusConflict, CodeConflict, err.Error())
+		return
+	}
+	serverError(w, err)
+}
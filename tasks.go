@@ -0,0 +1,1046 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Task is a single unit of work tracked by the TMS.
+type Task struct {
+	ID          string     `json:"id"`
+	OwnerID     int        `json:"owner_id"`
+	Title       string     `json:"title"`
+	Description string     `json:"description"`
+	Done        bool       `json:"done"`
+	Tags        []string   `json:"tags"`
+	DueDate     *time.Time `json:"due_date"`
+	// ReminderSentAt is when the due-date reminder went out; it is cleared
+	// whenever the due date changes so that the new one is reminded of too.
+	ReminderSentAt *time.Time `json:"reminder_sent_at"`
+	// AssigneeID is the user the task is assigned to, if any. Assignees can
+	// see the task but only its owner, or for a workspace task any member of
+	// the workspace, can change it.
+	AssigneeID *int `json:"assignee_id"`
+	// WorkspaceID is the workspace the task belongs to; nil means it is a
+	// personal task of its owner.
+	WorkspaceID *int `json:"workspace_id"`
+	// Priority is one of the Priority* values.
+	Priority string `json:"priority"`
+	// Recurrence is an RRULE subset (see parseRecurrence); "" means the task
+	// doesn't repeat.
+	Recurrence string    `json:"recurrence"`
+	Subtasks   []Subtask `json:"subtasks"`
+	// Attachments describe the files attached to the task, oldest first.
+	Attachments []Attachment `json:"attachments"`
+	// AutoComplete marks the task done once all of its subtasks are done.
+	AutoComplete bool       `json:"auto_complete"`
+	CreatedAt    time.Time  `json:"created_at"`
+	CompletedAt  *time.Time `json:"completed_at"`
+	// DeletedAt is set while the task is in the trash.
+	DeletedAt *time.Time `json:"deleted_at"`
+	// Archived tasks are kept out of the default lists, whether they are
+	// done or not; ArchivedAt is when the task was archived. Archiving is
+	// independent of the trash.
+	Archived   bool       `json:"archived"`
+	ArchivedAt *time.Time `json:"archived_at"`
+	// UniqueTitle is set while the owner, or the workspace, of the task
+	// enforces unique titles (see UniqueTitleTaskRepo). No two live tasks
+	// of the same owner's personal tasks, or of the same workspace, that
+	// both have it set can share a title, ignoring case.
+	UniqueTitle bool `json:"-"`
+	// Succeeded is set on an occurrence of a recurring task once its
+	// successor has been spawned. It shares the successor's title, so it is
+	// left out of the unique title check.
+	Succeeded bool `json:"-"`
+	// Position orders the tasks of an owner for SortByPosition. New tasks go
+	// after the owner's others; only Reorder changes it afterwards.
+	Position float64 `json:"position"`
+}
+
+// clone returns a copy of t that shares no mutable state with it.
+func (t Task) clone() Task {
+	t.Tags = append([]string{}, t.Tags...)
+	t.Subtasks = append([]Subtask{}, t.Subtasks...)
+	t.Attachments = append([]Attachment{}, t.Attachments...)
+	if t.WorkspaceID != nil {
+		workspace := *t.WorkspaceID
+		t.WorkspaceID = &workspace
+	}
+	if t.AssigneeID != nil {
+		assignee := *t.AssigneeID
+		t.AssigneeID = &assignee
+	}
+	if t.DueDate != nil {
+		due := *t.DueDate
+		t.DueDate = &due
+	}
+	if t.ReminderSentAt != nil {
+		reminded := *t.ReminderSentAt
+		t.ReminderSentAt = &reminded
+	}
+	if t.CompletedAt != nil {
+		completed := *t.CompletedAt
+		t.CompletedAt = &completed
+	}
+	if t.DeletedAt != nil {
+		deleted := *t.DeletedAt
+		t.DeletedAt = &deleted
+	}
+	if t.ArchivedAt != nil {
+		archived := *t.ArchivedAt
+		t.ArchivedAt = &archived
+	}
+	return t
+}
+
+// inWorkspace reports whether t belongs to the workspace with the given ID.
+// Personal tasks belong to none.
+func (t Task) inWorkspace(workspaceID int) bool {
+	return t.WorkspaceID != nil && *t.WorkspaceID == workspaceID
+}
+
+// inScope reports whether t is one of the tasks of workspaceID or, if it is
+// 0, one of ownerID's personal tasks.
+func (t Task) inScope(ownerID, workspaceID int) bool {
+	if workspaceID != 0 {
+		return t.inWorkspace(workspaceID)
+	}
+	return t.WorkspaceID == nil && t.OwnerID == ownerID
+}
+
+// hasAllTags reports whether t carries every tag in tags. Both sides are
+// expected to be normalized.
+func (t Task) hasAllTags(tags []string) bool {
+	for _, want := range tags {
+		if !slices.Contains(t.Tags, want) {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeTags lowercases and trims tags, dropping empty entries and
+// duplicates while keeping the first-seen order.
+func normalizeTags(tags []string) []string {
+	out := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		out = append(out, tag)
+	}
+	return out
+}
+
+// setDone updates Done and keeps CompletedAt in sync: it is set when the task
+// transitions to done and cleared when it is reopened.
+func (t *Task) setDone(done bool) {
+	if done && !t.Done {
+		now := time.Now().UTC()
+		t.CompletedAt = &now
+	} else if !done {
+		t.CompletedAt = nil
+	}
+	t.Done = done
+}
+
+// setArchived updates Archived and keeps ArchivedAt in sync, like setDone.
+func (t *Task) setArchived(archived bool) {
+	if archived && !t.Archived {
+		now := time.Now().UTC()
+		t.ArchivedAt = &now
+	} else if !archived {
+		t.ArchivedAt = nil
+	}
+	t.Archived = archived
+}
+
+// setDueDate updates DueDate, clearing ReminderSentAt if it changed.
+func (t *Task) setDueDate(due *time.Time) {
+	if (due == nil) != (t.DueDate == nil) || (due != nil && !due.Equal(*t.DueDate)) {
+		t.ReminderSentAt = nil
+	}
+	t.DueDate = due
+}
+
+// Task priorities, from least to most pressing.
+const (
+	PriorityLow    = "low"
+	PriorityMedium = "medium"
+	PriorityHigh   = "high"
+	PriorityUrgent = "urgent"
+)
+
+// priorityRank orders the priorities for sorting; higher is more pressing.
+var priorityRank = map[string]int{
+	PriorityLow:    1,
+	PriorityMedium: 2,
+	PriorityHigh:   3,
+	PriorityUrgent: 4,
+}
+
+// normalizePriority validates a priority from a request body. The empty
+// string means PriorityMedium.
+func normalizePriority(p string) (string, error) {
+	if p == "" {
+		return PriorityMedium, nil
+	}
+	if _, ok := priorityRank[p]; !ok {
+		return "", fmt.Errorf("priority must be one of low, medium, high, urgent")
+	}
+	return p, nil
+}
+
+// ErrTaskNotFound is returned by a TaskRepository when no task has the given ID.
+var ErrTaskNotFound = errors.New("task not found")
+
+// ErrDuplicateTitle is returned by TaskRepository writes that would give two
+// live tasks with UniqueTitle set the same title in one scope.
+var ErrDuplicateTitle = errors.New("a task with this title already exists")
+
+// ErrCommentNotFound is returned for comments that don't exist on the task.
+var ErrCommentNotFound = errors.New("comment not found")
+
+// TaskRepository stores tasks. Implementations must be safe for concurrent use.
+type TaskRepository interface {
+	// Create assigns a new ID and creation time to t, stores it and returns
+	// the stored task. If quota is positive it fails with ErrQuotaExceeded
+	// when the owner would own more than quota tasks, counting the trash;
+	// the count and the insert are atomic.
+	Create(ctx context.Context, t Task, quota int) (Task, error)
+	// CreateMany stores all of tasks or none of them, assigning IDs and
+	// creation times and applying quota to each owner as Create does, and
+	// returns them in the same order.
+	CreateMany(ctx context.Context, tasks []Task, quota int) ([]Task, error)
+	// ReplaceByOwner atomically removes every personal task owned by
+	// ownerID, including those in the trash, and stores tasks as CreateMany
+	// does. The removed tasks don't count against quota.
+	ReplaceByOwner(ctx context.Context, ownerID int, tasks []Task, quota int) ([]Task, error)
+	Get(ctx context.Context, id string) (Task, error)
+	// List returns one page of tasks matching opts together with the total
+	// number of matching tasks.
+	List(ctx context.Context, opts ListOptions) ([]Task, int, error)
+	// Count tallies the tasks matching opts; Limit, Offset and Sort are
+	// ignored.
+	Count(ctx context.Context, opts ListOptions) (TaskCounts, error)
+	// Search returns the tasks of workspaceID, or userID's personal tasks
+	// if it is 0, whose title or description contains query, ignoring case.
+	// Tasks in the trash are skipped.
+	Search(ctx context.Context, userID, workspaceID int, query string) ([]Task, error)
+	// Tags returns the distinct tags used by the tasks of workspaceID, or by
+	// ownerID's personal tasks if it is 0, outside the trash, sorted.
+	Tags(ctx context.Context, ownerID, workspaceID int) ([]string, error)
+	// Update replaces the stored task with the same ID as t. Its Position
+	// is left alone.
+	Update(ctx context.Context, t Task) error
+	// SetUniqueTitles sets UniqueTitle on every task of workspaceID, or of
+	// ownerID's personal tasks if it is 0, the trash included. Setting it
+	// fails with ErrDuplicateTitle, changing nothing, if two of the live
+	// ones share a title. Create, CreateMany, ReplaceByOwner and Update fail
+	// with ErrDuplicateTitle too, atomically with the write.
+	SetUniqueTitles(ctx context.Context, ownerID, workspaceID int, unique bool) error
+	// Reorder atomically sets the positions of ownerID's tasks with the
+	// given distinct IDs so that SortByPosition puts them in that order (see
+	// planReorder), renumbering all of the owner's tasks first if there is
+	// no room left between two positions. It fails with ErrTaskNotFound,
+	// changing nothing, unless every ID is a live task of ownerID, and
+	// returns the tasks in the given order.
+	Reorder(ctx context.Context, ownerID int, ids []string) ([]Task, error)
+	// SetDone marks the live tasks with the given IDs done, with
+	// CompletedAt at, or not done, in one operation. Only the tasks of
+	// workspaceID or, if it is 0, ownerID's personal tasks are changed
+	// (see Task.inScope); others, and tasks already in that state, in the
+	// trash or missing, are skipped. It returns the tasks it changed.
+	SetDone(ctx context.Context, ownerID, workspaceID int, ids []string, done bool, at time.Time) ([]Task, error)
+	// Delete permanently removes a task; moving it to the trash is an Update
+	// of DeletedAt.
+	Delete(ctx context.Context, id string) error
+	// DeleteByOwner permanently removes every personal task owned by
+	// ownerID, including those in the trash, and returns how many were
+	// removed. Tasks they created in workspaces stay with the workspace.
+	DeleteByOwner(ctx context.Context, ownerID int) (int, error)
+	// DeleteByWorkspace permanently removes every task of workspaceID,
+	// including those in the trash, and returns how many were removed.
+	DeleteByWorkspace(ctx context.Context, workspaceID int) (int, error)
+	// PurgeDeleted permanently removes tasks moved to the trash before
+	// cutoff and returns how many were removed.
+	PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error)
+	// DueForReminder returns the live, incomplete tasks due after now and no
+	// later than before whose reminder hasn't been sent.
+	DueForReminder(ctx context.Context, now, before time.Time) ([]Task, error)
+	// MarkReminded sets the ReminderSentAt of the task with the given ID to
+	// at unless it is already set, and reports whether it was. The worker
+	// claims a reminder this way before sending it, so that it goes out once
+	// even with several instances scanning.
+	MarkReminded(ctx context.Context, id string, at time.Time) (bool, error)
+	// Unassign clears the assignee of every task assigned to assigneeID and
+	// returns how many tasks changed.
+	Unassign(ctx context.Context, assigneeID int) (int, error)
+
+	// AddComment assigns a new ID and creation time to c, stores it on the
+	// task c.TaskID and returns the stored comment. Comments are removed
+	// along with their task.
+	AddComment(ctx context.Context, c Comment) (Comment, error)
+	// Comments returns one page of the comments on a task, newest first,
+	// together with the total number of them.
+	Comments(ctx context.Context, taskID string, limit, offset int) ([]Comment, int, error)
+	GetComment(ctx context.Context, taskID, commentID string) (Comment, error)
+	DeleteComment(ctx context.Context, taskID, commentID string) error
+}
+
+// ListOptions selects and orders the tasks returned by TaskRepository.List.
+type ListOptions struct {
+	// OwnerID restricts the result to one user's tasks; 0 means all users.
+	OwnerID int
+	// WorkspaceID restricts the result to one workspace's tasks and Personal
+	// to tasks outside any workspace; with neither, tasks are selected
+	// wherever they are.
+	WorkspaceID int
+	Personal    bool
+	// AssigneeID restricts the result to tasks assigned to one user; 0
+	// means any assignee or none.
+	AssigneeID int
+	// Limit is the maximum number of tasks to return; 0 means no limit.
+	Limit  int
+	Offset int
+	// After, if set, skips the tasks up to and including this position,
+	// before Offset is applied; Sort must then be SortByCreatedAt or
+	// SortByCreatedAtDesc. The total still counts every matching task.
+	After *ListCursor
+	// Sort is one of the SortBy* values; "" means SortByCreatedAt.
+	Sort string
+	// Tags restricts the result to tasks carrying all of these normalized tags.
+	Tags []string
+	// Priorities restricts the result to tasks with any of these priorities.
+	Priorities []string
+	// Overdue restricts the result to incomplete tasks whose due date has passed.
+	Overdue bool
+	// DueBefore and DueAfter restrict the result to tasks with a due date in
+	// the given range. Tasks without a due date never match.
+	DueBefore *time.Time
+	DueAfter  *time.Time
+	// CreatedAfter restricts the result to tasks created after this time.
+	CreatedAfter *time.Time
+	// Trashed selects tasks in the trash instead of live ones.
+	Trashed bool
+	// Archived selects archived tasks instead of the others, and
+	// IncludeArchived both. Either way Trashed applies too.
+	Archived        bool
+	IncludeArchived bool
+}
+
+// matches reports whether t passes every filter in opts at time now.
+func (opts ListOptions) matches(t Task, now time.Time) bool {
+	if (opts.OwnerID != 0 && t.OwnerID != opts.OwnerID) || (t.DeletedAt != nil) != opts.Trashed || !t.hasAllTags(opts.Tags) {
+		return false
+	}
+	if !opts.IncludeArchived && t.Archived != opts.Archived {
+		return false
+	}
+	if opts.AssigneeID != 0 && (t.AssigneeID == nil || *t.AssigneeID != opts.AssigneeID) {
+		return false
+	}
+	if (opts.WorkspaceID != 0 && !t.inWorkspace(opts.WorkspaceID)) || (opts.Personal && t.WorkspaceID != nil) {
+		return false
+	}
+	if len(opts.Priorities) > 0 && !slices.Contains(opts.Priorities, t.Priority) {
+		return false
+	}
+	if opts.Overdue && (t.Done || t.DueDate == nil || !t.DueDate.Before(now)) {
+		return false
+	}
+	if opts.DueBefore != nil && (t.DueDate == nil || !t.DueDate.Before(*opts.DueBefore)) {
+		return false
+	}
+	if opts.DueAfter != nil && (t.DueDate == nil || !t.DueDate.After(*opts.DueAfter)) {
+		return false
+	}
+	if opts.CreatedAfter != nil && !t.CreatedAt.After(*opts.CreatedAfter) {
+		return false
+	}
+	return true
+}
+
+// TaskCounts summarizes a set of tasks. Pending is Total minus Done;
+// Overdue counts the pending tasks whose due date has passed.
+type TaskCounts struct {
+	Total   int `json:"total"`
+	Done    int `json:"done"`
+	Pending int `json:"pending"`
+	Overdue int `json:"overdue"`
+}
+
+// Sort orders accepted by ListOptions. A leading "-" reverses the order.
+// SortByPriority puts the most pressing tasks first, urgent to low, and
+// SortByPosition follows the order set with Reorder.
+const (
+	SortByCreatedAt     = "created_at"
+	SortByCreatedAtDesc = "-created_at"
+	SortByTitle         = "title"
+	SortByTitleDesc     = "-title"
+	SortByPriority      = "priority"
+	SortByPriorityDesc  = "-priority"
+	SortByPosition      = "position"
+	SortByPositionDesc  = "-position"
+)
+
+// validSort reports whether s is a supported ListOptions.Sort value.
+func validSort(s string) bool {
+	switch s {
+	case SortByCreatedAt, SortByCreatedAtDesc, SortByTitle, SortByTitleDesc, SortByPriority, SortByPriorityDesc, SortByPosition, SortByPositionDesc:
+		return true
+	}
+	return false
+}
+
+// MemoryTaskRepo is an in-memory TaskRepository. Data is lost on restart.
+type MemoryTaskRepo struct {
+	// maxTasks, if positive, is how many tasks are kept; creating more
+	// evicts the oldest, trash included. Evicted tasks go without events or
+	// history entries, and their attachment files are left behind.
+	maxTasks int
+	warning  evictionWarning
+
+	mu    sync.RWMutex
+	tasks map[string]Task
+	// comments holds the comments of each task, oldest first.
+	comments map[string][]Comment
+}
+
+// NewMemoryTaskRepo returns an empty MemoryTaskRepo keeping at most maxTasks
+// tasks, or any number if it is 0.
+func NewMemoryTaskRepo(maxTasks int) *MemoryTaskRepo {
+	return &MemoryTaskRepo{maxTasks: maxTasks, tasks: make(map[string]Task), comments: make(map[string][]Comment)}
+}
+
+// remove deletes a task and its comments. The caller must hold r.mu.
+func (r *MemoryTaskRepo) remove(id string) {
+	delete(r.tasks, id)
+	delete(r.comments, id)
+}
+
+// evict removes the oldest tasks beyond r.maxTasks, by creation time, other
+// than those in keep. The caller must hold r.mu.
+func (r *MemoryTaskRepo) evict(keep []Task) {
+	if r.maxTasks <= 0 {
+		return
+	}
+	excess := len(r.tasks) - r.maxTasks
+	if excess <= 0 {
+		return
+	}
+	kept := make(map[string]bool, len(keep))
+	for _, t := range keep {
+		kept[t.ID] = true
+	}
+	// Sorted once, so that evicting many tasks at a time stays cheap.
+	type candidate struct {
+		id      string
+		created time.Time
+	}
+	candidates := make([]candidate, 0, len(r.tasks))
+	for id, t := range r.tasks {
+		if !kept[id] {
+			candidates = append(candidates, candidate{id, t.CreatedAt})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].created.Before(candidates[j].created) })
+	for _, c := range candidates[:min(excess, len(candidates))] {
+		r.remove(c.id)
+		r.warning.warn("tasks", r.maxTasks)
+	}
+}
+
+// checkQuota fails with ErrQuotaExceeded if storing tasks would leave an
+// owner with more than quota tasks. Stored tasks for which replaced returns
+// true aren't counted. The caller must hold r.mu.
+func (r *MemoryTaskRepo) checkQuota(tasks []Task, quota int, replaced func(Task) bool) error {
+	if quota <= 0 {
+		return nil
+	}
+	owned := countByOwner(tasks)
+	for _, t := range r.tasks {
+		if _, ok := owned[t.OwnerID]; ok && (replaced == nil || !replaced(t)) {
+			owned[t.OwnerID]++
+		}
+	}
+	for _, n := range owned {
+		if n > quota {
+			return quotaError(quota)
+		}
+	}
+	return nil
+}
+
+// titleKey identifies a title within the scope it must be unique in: a
+// workspace, or one owner's personal tasks.
+type titleKey struct {
+	ownerID, workspaceID int
+	title                string
+}
+
+// titleKey returns the key t's title must be unique under, and whether it
+// must be.
+func (t Task) titleKey() (titleKey, bool) {
+	if !t.UniqueTitle || t.Succeeded || t.DeletedAt != nil {
+		return titleKey{}, false
+	}
+	k := titleKey{title: strings.ToLower(t.Title)}
+	if t.WorkspaceID != nil {
+		k.workspaceID = *t.WorkspaceID
+	} else {
+		k.ownerID = t.OwnerID
+	}
+	return k, true
+}
+
+// checkTitles fails with ErrDuplicateTitle if storing tasks would leave two
+// tasks with the same titleKey. Stored tasks with the ID of one of tasks, or
+// for which replaced returns true, aren't counted. The caller must hold r.mu.
+func (r *MemoryTaskRepo) checkTitles(tasks []Task, replaced func(Task) bool) error {
+	keys := make(map[titleKey]bool)
+	ids := make(map[string]bool, len(tasks))
+	for _, t := range tasks {
+		ids[t.ID] = true
+		if k, ok := t.titleKey(); ok {
+			if keys[k] {
+				return ErrDuplicateTitle
+			}
+			keys[k] = true
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	for _, t := range r.tasks {
+		if ids[t.ID] || (replaced != nil && replaced(t)) {
+			continue
+		}
+		if k, ok := t.titleKey(); ok && keys[k] {
+			return ErrDuplicateTitle
+		}
+	}
+	return nil
+}
+
+func (r *MemoryTaskRepo) Create(ctx context.Context, t Task, quota int) (Task, error) {
+	id, err := newTaskID()
+	if err != nil {
+		return Task{}, err
+	}
+	t.ID = id
+	t.CreatedAt = time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkQuota([]Task{t}, quota, nil); err != nil {
+		return Task{}, err
+	}
+	if err := r.checkTitles([]Task{t}, nil); err != nil {
+		return Task{}, err
+	}
+	t.Position = r.lastPositions()[t.OwnerID] + positionGap
+	r.tasks[t.ID] = t.clone()
+	r.evict([]Task{t})
+	return t, nil
+}
+
+func (r *MemoryTaskRepo) CreateMany(ctx context.Context, tasks []Task, quota int) ([]Task, error) {
+	// Assign every ID before taking the lock so that a failure leaves the
+	// repository untouched.
+	created := make([]Task, len(tasks))
+	now := time.Now().UTC()
+	for i, t := range tasks {
+		id, err := newTaskID()
+		if err != nil {
+			return nil, err
+		}
+		t.ID = id
+		t.CreatedAt = now
+		created[i] = t
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkQuota(created, quota, nil); err != nil {
+		return nil, err
+	}
+	if err := r.checkTitles(created, nil); err != nil {
+		return nil, err
+	}
+	r.appendPositions(created)
+	for _, t := range created {
+		r.tasks[t.ID] = t.clone()
+	}
+	r.evict(created)
+	return created, nil
+}
+
+func (r *MemoryTaskRepo) ReplaceByOwner(ctx context.Context, ownerID int, tasks []Task, quota int) ([]Task, error) {
+	created := make([]Task, len(tasks))
+	now := time.Now().UTC()
+	for i, t := range tasks {
+		id, err := newTaskID()
+		if err != nil {
+			return nil, err
+		}
+		t.ID = id
+		t.CreatedAt = now
+		created[i] = t
+	}
+
+	personal := func(t Task) bool { return t.OwnerID == ownerID && t.WorkspaceID == nil }
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.checkQuota(created, quota, personal); err != nil {
+		return nil, err
+	}
+	if err := r.checkTitles(created, personal); err != nil {
+		return nil, err
+	}
+	for id, t := range r.tasks {
+		if personal(t) {
+			r.remove(id)
+		}
+	}
+	r.appendPositions(created)
+	for _, t := range created {
+		r.tasks[t.ID] = t.clone()
+	}
+	r.evict(created)
+	return created, nil
+}
+
+// lastPositions returns the highest position of each owner's tasks, the
+// trash included. r.mu must be held.
+func (r *MemoryTaskRepo) lastPositions() map[int]float64 {
+	last := make(map[int]float64)
+	for _, t := range r.tasks {
+		if p, ok := last[t.OwnerID]; !ok || t.Position > p {
+			last[t.OwnerID] = t.Position
+		}
+	}
+	return last
+}
+
+// appendPositions places tasks, in order, after the other tasks of their
+// owners. r.mu must be held.
+func (r *MemoryTaskRepo) appendPositions(tasks []Task) {
+	last := r.lastPositions()
+	for i := range tasks {
+		last[tasks[i].OwnerID] += positionGap
+		tasks[i].Position = last[tasks[i].OwnerID]
+	}
+}
+
+func (r *MemoryTaskRepo) Get(ctx context.Context, id string) (Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.tasks[id]
+	if !ok {
+		return Task{}, ErrTaskNotFound
+	}
+	return t.clone(), nil
+}
+
+func (r *MemoryTaskRepo) List(ctx context.Context, opts ListOptions) ([]Task, int, error) {
+	now := time.Now()
+	r.mu.RLock()
+	tasks := make([]Task, 0)
+	for _, t := range r.tasks {
+		if opts.matches(t, now) {
+			tasks = append(tasks, t.clone())
+		}
+	}
+	r.mu.RUnlock()
+
+	sortTasks(tasks, opts.Sort)
+	page := tasks
+	if opts.After != nil {
+		desc := opts.Sort == SortByCreatedAtDesc
+		i := sort.Search(len(page), func(i int) bool { return opts.After.after(page[i], desc) })
+		page = page[i:]
+	}
+	return paginate(page, opts.Limit, opts.Offset), len(tasks), nil
+}
+
+func (r *MemoryTaskRepo) Count(ctx context.Context, opts ListOptions) (TaskCounts, error) {
+	now := time.Now()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var c TaskCounts
+	for _, t := range r.tasks {
+		if !opts.matches(t, now) {
+			continue
+		}
+		c.Total++
+		switch {
+		case t.Done:
+			c.Done++
+		case t.DueDate != nil && t.DueDate.Before(now):
+			c.Overdue++
+		}
+	}
+	c.Pending = c.Total - c.Done
+	return c, nil
+}
+
+func (r *MemoryTaskRepo) Search(ctx context.Context, userID, workspaceID int, query string) ([]Task, error) {
+	q := strings.ToLower(query)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tasks := make([]Task, 0)
+	for _, t := range r.tasks {
+		if !t.inScope(userID, workspaceID) || t.DeletedAt != nil {
+			continue
+		}
+		if strings.Contains(strings.ToLower(t.Title), q) || strings.Contains(strings.ToLower(t.Description), q) {
+			tasks = append(tasks, t.clone())
+		}
+	}
+	return tasks, nil
+}
+
+func (r *MemoryTaskRepo) Tags(ctx context.Context, ownerID, workspaceID int) ([]string, error) {
+	r.mu.RLock()
+	seen := make(map[string]bool)
+	for _, t := range r.tasks {
+		if !t.inScope(ownerID, workspaceID) || t.DeletedAt != nil {
+			continue
+		}
+		for _, tag := range t.Tags {
+			seen[tag] = true
+		}
+	}
+	r.mu.RUnlock()
+
+	tags := make([]string, 0, len(seen))
+	for tag := range seen {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags, nil
+}
+
+// sortTasks orders tasks by the given ListOptions.Sort value. Ties are broken
+// by ID so that pagination is stable.
+func sortTasks(tasks []Task, order string) {
+	desc := strings.HasPrefix(order, "-")
+	key := strings.TrimPrefix(order, "-")
+	sort.Slice(tasks, func(i, j int) bool {
+		a, b := tasks[i], tasks[j]
+		if desc {
+			a, b = b, a
+		}
+		switch key {
+		case SortByTitle:
+			if ta, tb := strings.ToLower(a.Title), strings.ToLower(b.Title); ta != tb {
+				return ta < tb
+			}
+		case SortByPriority:
+			if pa, pb := priorityRank[a.Priority], priorityRank[b.Priority]; pa != pb {
+				return pa > pb
+			}
+		case SortByPosition:
+			if a.Position != b.Position {
+				return a.Position < b.Position
+			}
+		default:
+			if !a.CreatedAt.Equal(b.CreatedAt) {
+				return a.CreatedAt.Before(b.CreatedAt)
+			}
+		}
+		return a.ID < b.ID
+	})
+}
+
+// paginate returns the window of tasks selected by limit and offset.
+func paginate(tasks []Task, limit, offset int) []Task {
+	if offset >= len(tasks) {
+		return []Task{}
+	}
+	tasks = tasks[offset:]
+	if limit > 0 && limit < len(tasks) {
+		tasks = tasks[:limit]
+	}
+	return tasks
+}
+
+func (r *MemoryTaskRepo) Update(ctx context.Context, t Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	old, ok := r.tasks[t.ID]
+	if !ok {
+		return ErrTaskNotFound
+	}
+	if err := r.checkTitles([]Task{t}, nil); err != nil {
+		return err
+	}
+	t.Position = old.Position
+	r.tasks[t.ID] = t.clone()
+	return nil
+}
+
+func (r *MemoryTaskRepo) SetUniqueTitles(ctx context.Context, ownerID, workspaceID int, unique bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var scoped []Task
+	for _, t := range r.tasks {
+		if t.inScope(ownerID, workspaceID) {
+			t.UniqueTitle = unique
+			scoped = append(scoped, t)
+		}
+	}
+	if err := r.checkTitles(scoped, nil); err != nil {
+		return err
+	}
+	for _, t := range scoped {
+		r.tasks[t.ID] = t
+	}
+	return nil
+}
+
+func (r *MemoryTaskRepo) Reorder(ctx context.Context, ownerID int, ids []string) ([]Task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, id := range ids {
+		if t, ok := r.tasks[id]; !ok || t.OwnerID != ownerID || t.DeletedAt != nil {
+			return nil, ErrTaskNotFound
+		}
+	}
+	var owned []Task
+	current := make(map[string]float64)
+	for _, t := range r.tasks {
+		if t.OwnerID == ownerID {
+			owned = append(owned, t)
+			current[t.ID] = t.Position
+		}
+	}
+	moved, ok := planReorder(ids, current)
+	if !ok {
+		sortTasks(owned, SortByPosition)
+		order := make([]string, len(owned))
+		for i, t := range owned {
+			order[i] = t.ID
+		}
+		current = renumberPositions(order)
+		r.setPositions(current)
+		moved, _ = planReorder(ids, current)
+	}
+	r.setPositions(moved)
+	tasks := make([]Task, len(ids))
+	for i, id := range ids {
+		tasks[i] = r.tasks[id].clone()
+	}
+	return tasks, nil
+}
+
+func (r *MemoryTaskRepo) SetDone(ctx context.Context, ownerID, workspaceID int, ids []string, done bool, at time.Time) ([]Task, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	changed := make([]Task, 0)
+	for _, id := range ids {
+		t, ok := r.tasks[id]
+		if !ok || !t.inScope(ownerID, workspaceID) || t.DeletedAt != nil || t.Done == done {
+			continue
+		}
+		t.Done = done
+		t.CompletedAt = nil
+		if done {
+			t.CompletedAt = &at
+		}
+		r.tasks[id] = t
+		changed = append(changed, t.clone())
+	}
+	return changed, nil
+}
+
+// setPositions stores new positions by task ID. r.mu must be held.
+func (r *MemoryTaskRepo) setPositions(positions map[string]float64) {
+	for id, pos := range positions {
+		t := r.tasks[id]
+		t.Position = pos
+		r.tasks[id] = t
+	}
+}
+
+func (r *MemoryTaskRepo) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tasks[id]; !ok {
+		return ErrTaskNotFound
+	}
+	r.remove(id)
+	return nil
+}
+
+func (r *MemoryTaskRepo) DeleteByOwner(ctx context.Context, ownerID int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for id, t := range r.tasks {
+		if t.OwnerID == ownerID && t.WorkspaceID == nil {
+			r.remove(id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryTaskRepo) DeleteByWorkspace(ctx context.Context, workspaceID int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for id, t := range r.tasks {
+		if t.inWorkspace(workspaceID) {
+			r.remove(id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryTaskRepo) PurgeDeleted(ctx context.Context, cutoff time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for id, t := range r.tasks {
+		if t.DeletedAt != nil && t.DeletedAt.Before(cutoff) {
+			r.remove(id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryTaskRepo) DueForReminder(ctx context.Context, now, before time.Time) ([]Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tasks := make([]Task, 0)
+	for _, t := range r.tasks {
+		if t.DeletedAt != nil || t.Done || t.ReminderSentAt != nil || t.DueDate == nil {
+			continue
+		}
+		if t.DueDate.After(now) && !t.DueDate.After(before) {
+			tasks = append(tasks, t.clone())
+		}
+	}
+	return tasks, nil
+}
+
+func (r *MemoryTaskRepo) MarkReminded(ctx context.Context, id string, at time.Time) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	t, ok := r.tasks[id]
+	if !ok {
+		return false, ErrTaskNotFound
+	}
+	if t.ReminderSentAt != nil {
+		return false, nil
+	}
+	t.ReminderSentAt = &at
+	r.tasks[id] = t
+	return true, nil
+}
+
+func (r *MemoryTaskRepo) Unassign(ctx context.Context, assigneeID int) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for id, t := range r.tasks {
+		if t.AssigneeID != nil && *t.AssigneeID == assigneeID {
+			t.AssigneeID = nil
+			r.tasks[id] = t
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (r *MemoryTaskRepo) AddComment(ctx context.Context, c Comment) (Comment, error) {
+	id, err := newTaskID()
+	if err != nil {
+		return Comment{}, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.tasks[c.TaskID]; !ok {
+		return Comment{}, ErrTaskNotFound
+	}
+	c.ID = id
+	c.CreatedAt = time.Now().UTC()
+	r.comments[c.TaskID] = append(r.comments[c.TaskID], c)
+	return c, nil
+}
+
+func (r *MemoryTaskRepo) Comments(ctx context.Context, taskID string, limit, offset int) ([]Comment, int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	all := r.comments[taskID]
+	page := make([]Comment, 0, min(limit, len(all)))
+	for i := len(all) - 1 - offset; i >= 0 && len(page) < limit; i-- {
+		page = append(page, all[i])
+	}
+	return page, len(all), nil
+}
+
+func (r *MemoryTaskRepo) GetComment(ctx context.Context, taskID, commentID string) (Comment, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, c := range r.comments[taskID] {
+		if c.ID == commentID {
+			return c, nil
+		}
+	}
+	return Comment{}, ErrCommentNotFound
+}
+
+func (r *MemoryTaskRepo) DeleteComment(ctx context.Context, taskID, commentID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	comments := r.comments[taskID]
+	for i, c := range comments {
+		if c.ID == commentID {
+			r.comments[taskID] = slices.Delete(comments, i, i+1)
+			return nil
+		}
+	}
+	return ErrCommentNotFound
+}
+
+// newTaskID returns a random 128-bit identifier encoded as 32 hex characters.
+func newTaskID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+var errInvalidTaskID = errors.New("task ID must be 32 lowercase hex characters")
+
+// parseTaskID checks that s has the form newTaskID produces, so that
+// malformed path segments are rejected without a repository lookup.
+func parseTaskID(s string) (string, error) {
+	if len(s) != 32 {
+		return "", errInvalidTaskID
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return "", errInvalidTaskID
+		}
+	}
+	return s, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxTemplateNameLen bounds the name a user gives a template.
+const maxTemplateNameLen = 100
+
+// Template is a reusable starting point for new tasks. Templates belong to
+// the user who created them and are only visible to them.
+type Template struct {
+	ID      string `json:"id"`
+	OwnerID int    `json:"owner_id"`
+	Name    string `json:"name"`
+	// TitlePattern is the title of the tasks made from the template, with
+	// its placeholders (see expandTitle) filled in.
+	TitlePattern string   `json:"title_pattern"`
+	Description  string   `json:"description"`
+	Tags         []string `json:"tags"`
+	Priority     string   `json:"priority"`
+	// Subtasks are the titles of the checklist each new task starts with.
+	Subtasks  []string  `json:"subtasks"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrTemplateNotFound is returned by a TemplateStore when no template
+// matches.
+var ErrTemplateNotFound = errors.New("template not found")
+
+// TemplateStore stores task templates. Implementations must be safe for
+// concurrent use.
+type TemplateStore interface {
+	// Create assigns a new ID and creation time to t, stores it and returns
+	// the stored template.
+	Create(ctx context.Context, t Template) (Template, error)
+	// Get returns ownerID's template with the given ID.
+	Get(ctx context.Context, ownerID int, id string) (Template, error)
+	// ListByOwner returns ownerID's templates, oldest first.
+	ListByOwner(ctx context.Context, ownerID int) ([]Template, error)
+	// Delete removes ownerID's template with the given ID.
+	Delete(ctx context.Context, ownerID int, id string) error
+	// DeleteByOwner removes all of ownerID's templates.
+	DeleteByOwner(ctx context.Context, ownerID int) error
+}
+
+// MemoryTemplateStore is an in-memory TemplateStore. Data is lost on
+// restart.
+type MemoryTemplateStore struct {
+	mu        sync.RWMutex
+	templates map[string]Template
+}
+
+// NewMemoryTemplateStore returns an empty MemoryTemplateStore.
+func NewMemoryTemplateStore() *MemoryTemplateStore {
+	return &MemoryTemplateStore{templates: make(map[string]Template)}
+}
+
+func (s *MemoryTemplateStore) Create(ctx context.Context, t Template) (Template, error) {
+	id, err := newTaskID()
+	if err != nil {
+		return Template{}, err
+	}
+	t.ID = id
+	t.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates[t.ID] = t
+	return t, nil
+}
+
+func (s *MemoryTemplateStore) Get(ctx context.Context, ownerID int, id string) (Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.templates[id]
+	if !ok || t.OwnerID != ownerID {
+		return Template{}, ErrTemplateNotFound
+	}
+	return t, nil
+}
+
+func (s *MemoryTemplateStore) ListByOwner(ctx context.Context, ownerID int) ([]Template, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Template, 0)
+	for _, t := range s.templates {
+		if t.OwnerID == ownerID {
+			list = append(list, t)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (s *MemoryTemplateStore) Delete(ctx context.Context, ownerID int, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.templates[id]
+	if !ok || t.OwnerID != ownerID {
+		return ErrTemplateNotFound
+	}
+	delete(s.templates, id)
+	return nil
+}
+
+func (s *MemoryTemplateStore) DeleteByOwner(ctx context.Context, ownerID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, t := range s.templates {
+		if t.OwnerID == ownerID {
+			delete(s.templates, id)
+		}
+	}
+	return nil
+}
+
+// placeholder matches a {{name}} placeholder of a title pattern.
+var placeholder = regexp.MustCompile(`\{\{\s*([a-z_]*)\s*\}\}`)
+
+// titlePlaceholders are the placeholders a title pattern may contain,
+// each returning its value at time now.
+var titlePlaceholders = map[string]func(now time.Time) string{
+	// date is the day the task is made, as YYYY-MM-DD in UTC.
+	"date": func(now time.Time) string { return now.UTC().Format(time.DateOnly) },
+}
+
+// expandTitle fills in the placeholders of pattern, which must have passed
+// validateTitlePattern.
+func expandTitle(pattern string, now time.Time) string {
+	return placeholder.ReplaceAllStringFunc(pattern, func(m string) string {
+		return titlePlaceholders[placeholder.FindStringSubmatch(m)[1]](now)
+	})
+}
+
+// templateInput is the body of POST /templates.
+type templateInput struct {
+	Name         string   `json:"name"`
+	TitlePattern string   `json:"title_pattern"`
+	Description  string   `json:"description"`
+	Tags         []string `json:"tags"`
+	// Priority defaults to medium when omitted.
+	Priority string   `json:"priority"`
+	Subtasks []string `json:"subtasks"`
+}
+
+// Validate applies the taskInput limits to the fields a task takes from the
+// template, and the subtask limits to its checklist.
+func (in templateInput) Validate() error {
+	errs := validationErrors{}
+	switch name := strings.TrimSpace(in.Name); {
+	case name == "":
+		errs["name"] = "required"
+	case utf8.RuneCountInString(name) > maxTemplateNameLen:
+		errs["name"] = fmt.Sprintf("must be at most %d characters", maxTemplateNameLen)
+	}
+	validateTitlePattern(errs, in.TitlePattern)
+	validateDescription(errs, in.Description)
+	validateTags(errs, in.Tags)
+	if len(in.Subtasks) > maxSubtasks {
+		errs["subtasks"] = fmt.Sprintf("must have at most %d entries", maxSubtasks)
+	} else {
+		for i, title := range in.Subtasks {
+			sub := validationErrors{}
+			validateTitle(sub, title)
+			if msg, ok := sub["title"]; ok {
+				errs[fmt.Sprintf("subtasks[%d]", i)] = msg
+			}
+		}
+	}
+	return errs.orNil()
+}
+
+// validateTitlePattern checks a title pattern as a title, and that it uses
+// no unknown placeholders.
+func validateTitlePattern(errs validationErrors, pattern string) {
+	sub := validationErrors{}
+	validateTitle(sub, pattern)
+	if msg, ok := sub["title"]; ok {
+		errs["title_pattern"] = msg
+		return
+	}
+	for _, m := range placeholder.FindAllStringSubmatch(pattern, -1) {
+		if titlePlaceholders[m[1]] == nil {
+			errs["title_pattern"] = fmt.Sprintf("unknown placeholder %s (expected {{date}})", m[0])
+			return
+		}
+	}
+}
+
+// createTemplateHandler stores a template for the current user.
+func createTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	var in templateInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+	priority, err := normalizePriority(in.Priority)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	subtasks := make([]string, len(in.Subtasks))
+	for i, title := range in.Subtasks {
+		subtasks[i] = strings.TrimSpace(title)
+	}
+	t, err := templates.Create(r.Context(), Template{
+		OwnerID:      currentUser(r.Context()).ID,
+		Name:         strings.TrimSpace(in.Name),
+		TitlePattern: in.TitlePattern,
+		Description:  in.Description,
+		Tags:         normalizeTags(in.Tags),
+		Priority:     priority,
+		Subtasks:     subtasks,
+	})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusCreated, t)
+}
+
+// listTemplatesHandler returns the current user's templates, oldest first.
+func listTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := templates.ListByOwner(r.Context(), currentUser(r.Context()).ID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, map[string][]Template{"templates": list})
+}
+
+func getTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := templates.Get(r.Context(), currentUser(r.Context()).ID, r.PathValue("id"))
+	if err != nil {
+		templateStoreError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, t)
+}
+
+// deleteTemplateHandler removes a template. Tasks made from it are left
+// alone.
+func deleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	if err := templates.Delete(r.Context(), currentUser(r.Context()).ID, r.PathValue("id")); err != nil {
+		templateStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// createTaskFromTemplateHandler makes a task from one of the current user's
+// templates in the active workspace, or as a personal task. Fields given in
+// the body replace those of the template, as in PATCH /tasks/{id}; the
+// title, unless overridden, is the template's pattern with its placeholders
+// filled in.
+func createTaskFromTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	user := currentUser(r.Context())
+
+	var in taskPatch
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+	tmpl, err := templates.Get(r.Context(), user.ID, r.PathValue("templateID"))
+	if err != nil {
+		templateStoreError(w, err)
+		return
+	}
+
+	ti := taskInput{
+		Title:       expandTitle(tmpl.TitlePattern, time.Now()),
+		Description: tmpl.Description,
+		Tags:        tmpl.Tags,
+		Priority:    tmpl.Priority,
+		DueDate:     in.DueDate,
+	}
+	if in.Title != nil {
+		ti.Title = *in.Title
+	}
+	if in.Description != nil {
+		ti.Description = *in.Description
+	}
+	if in.Done != nil {
+		ti.Done = *in.Done
+	}
+	if in.Tags != nil {
+		ti.Tags = *in.Tags
+	}
+	if in.Priority != nil {
+		ti.Priority = *in.Priority
+	}
+	if in.Recurrence != nil {
+		ti.Recurrence = *in.Recurrence
+	}
+	if in.AutoComplete != nil {
+		ti.AutoComplete = *in.AutoComplete
+	}
+	// The expanded title can outgrow the pattern.
+	if err := ti.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+	t, err := taskFromInput(user.ID, activeWorkspaceRef(r.Context()), ti)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, err.Error())
+		return
+	}
+	for _, title := range tmpl.Subtasks {
+		id, err := newTaskID()
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		t.Subtasks = append(t.Subtasks, Subtask{ID: id, Title: title})
+	}
+
+	task, err := taskRepo.Create(r.Context(), t, taskQuota(user))
+	if err != nil {
+		taskRepoError(w, err)
+		return
+	}
+	recurringTasks.enqueue(task)
+	auditLog.record(r, user.ID, AuditTaskCreate, task.ID)
+	encode(w, r, http.StatusCreated, task.in(displayZone(w, r)))
+}
+
+func templateStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrTemplateNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "template not found")
+		return
+	}
+	serverError(w, err)
+}
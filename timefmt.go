@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	// Embedded so that X-Timezone works on hosts without a zoneinfo
+	// database.
+	_ "time/tzdata"
+)
+
+// Time formats TIME_FORMAT selects between for the timestamps of tasks.
+const (
+	timeFormatRFC3339     = "rfc3339"
+	timeFormatRFC3339Nano = "rfc3339nano"
+	timeFormatUnixMillis  = "unix_ms"
+)
+
+// taskTimeFormat is how the timestamps of tasks are written in responses
+// and events. It is set from TIME_FORMAT at boot.
+var taskTimeFormat = timeFormatRFC3339Nano
+
+// taskTime is a task timestamp as written in JSON: an RFC 3339 string, with
+// or without fractional seconds, in the zone of the time, or a number of
+// milliseconds since the Unix epoch.
+type taskTime struct {
+	t      time.Time
+	format string
+}
+
+func (tt taskTime) MarshalJSON() ([]byte, error) {
+	switch tt.format {
+	case timeFormatUnixMillis:
+		return strconv.AppendInt(nil, tt.t.UnixMilli(), 10), nil
+	case timeFormatRFC3339:
+		return json.Marshal(tt.t.Format(time.RFC3339))
+	}
+	return tt.t.MarshalJSON()
+}
+
+// optionalTaskTime is taskTime for times that may be unset.
+func optionalTaskTime(t *time.Time, format string) *taskTime {
+	if t == nil {
+		return nil
+	}
+	return &taskTime{*t, format}
+}
+
+// in returns t with its timestamps, and those of its attachments, in loc.
+// Only their presentation changes; the instants are the same.
+func (t Task) in(loc *time.Location) Task {
+	inLoc := func(p *time.Time) *time.Time {
+		if p == nil {
+			return nil
+		}
+		v := p.In(loc)
+		return &v
+	}
+	t.DueDate = inLoc(t.DueDate)
+	t.ReminderSentAt = inLoc(t.ReminderSentAt)
+	t.CreatedAt = t.CreatedAt.In(loc)
+	t.CompletedAt = inLoc(t.CompletedAt)
+	t.DeletedAt = inLoc(t.DeletedAt)
+	t.ArchivedAt = inLoc(t.ArchivedAt)
+	if t.Attachments != nil {
+		// Copied, as the stored task may share the array.
+		attachments := make([]Attachment, len(t.Attachments))
+		for i, a := range t.Attachments {
+			a.CreatedAt = a.CreatedAt.In(loc)
+			attachments[i] = a
+		}
+		t.Attachments = attachments
+	}
+	return t
+}
+
+// tasksIn is Task.in for a list of tasks.
+func tasksIn(tasks []Task, loc *time.Location) []Task {
+	if loc == time.UTC {
+		return tasks
+	}
+	out := make([]Task, len(tasks))
+	for i, t := range tasks {
+		out[i] = t.in(loc)
+	}
+	return out
+}
+
+// displayZone returns the zone named by r's X-Timezone header, in which the
+// endpoints that display tasks render their timestamps, or UTC if there is
+// none. An unknown zone falls back to UTC with a Warning header saying so.
+func displayZone(w http.ResponseWriter, r *http.Request) *time.Location {
+	w.Header().Add("Vary", "X-Timezone")
+	name := r.Header.Get("X-Timezone")
+	if name == "" {
+		return time.UTC
+	}
+	// "Local" would be the server's zone, which clients can't know.
+	loc, err := time.LoadLocation(name)
+	if err != nil || name == "Local" {
+		w.Header().Set("Warning", fmt.Sprintf("299 - %q", fmt.Sprintf("unknown time zone %q, using UTC", name)))
+		return time.UTC
+	}
+	return loc
+}
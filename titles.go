@@ -0,0 +1,226 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// UniqueTitleTaskRepo is a TaskRepository that sets UniqueTitle on every
+// task created or updated through it from the setting of the task's scope:
+// its workspace's EnforceUniqueTitles or, for a personal task, its owner's.
+// The wrapped repository enforces the flag.
+//
+// A completed occurrence of a recurring task keeps the series' title next
+// to its successor: the recurrence worker marks it Succeeded, which leaves
+// it out of the check until it is renamed.
+//
+// A setting changes through SetEnforced, never while a task is being
+// flagged and written, so that no task is stored under the old setting
+// once the new one is in effect.
+type UniqueTitleTaskRepo struct {
+	TaskRepository
+	users      UserStore
+	workspaces WorkspaceStore
+
+	// mu is held for reading from reading a task's setting until the task
+	// is written, and for writing by SetEnforced.
+	mu sync.RWMutex
+}
+
+// NewUniqueTitleTaskRepo wraps repo so that the tasks written through it
+// follow the settings in users and workspaces.
+func NewUniqueTitleTaskRepo(repo TaskRepository, users UserStore, workspaces WorkspaceStore) *UniqueTitleTaskRepo {
+	return &UniqueTitleTaskRepo{TaskRepository: repo, users: users, workspaces: workspaces}
+}
+
+// enforced reports whether the scope of t enforces unique titles. Those of
+// deleted users and workspaces don't.
+func (r *UniqueTitleTaskRepo) enforced(ctx context.Context, t Task) (bool, error) {
+	if t.WorkspaceID != nil {
+		ws, err := r.workspaces.Get(ctx, *t.WorkspaceID)
+		if errors.Is(err, ErrWorkspaceNotFound) {
+			return false, nil
+		}
+		return ws.EnforceUniqueTitles, err
+	}
+	u, err := r.users.Get(ctx, t.OwnerID)
+	if errors.Is(err, ErrUserNotFound) {
+		return false, nil
+	}
+	return u.EnforceUniqueTitles, err
+}
+
+// flagged returns a copy of tasks with UniqueTitle set as enforced says.
+func (r *UniqueTitleTaskRepo) flagged(ctx context.Context, tasks []Task) ([]Task, error) {
+	out := make([]Task, len(tasks))
+	for i, t := range tasks {
+		var err error
+		if t.UniqueTitle, err = r.enforced(ctx, t); err != nil {
+			return nil, err
+		}
+		out[i] = t
+	}
+	return out, nil
+}
+
+func (r *UniqueTitleTaskRepo) Create(ctx context.Context, t Task, quota int) (Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var err error
+	if t.UniqueTitle, err = r.enforced(ctx, t); err != nil {
+		return Task{}, err
+	}
+	return r.TaskRepository.Create(ctx, t, quota)
+}
+
+func (r *UniqueTitleTaskRepo) CreateMany(ctx context.Context, tasks []Task, quota int) ([]Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tasks, err := r.flagged(ctx, tasks)
+	if err != nil {
+		return nil, err
+	}
+	return r.TaskRepository.CreateMany(ctx, tasks, quota)
+}
+
+func (r *UniqueTitleTaskRepo) ReplaceByOwner(ctx context.Context, ownerID int, tasks []Task, quota int) ([]Task, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tasks, err := r.flagged(ctx, tasks)
+	if err != nil {
+		return nil, err
+	}
+	return r.TaskRepository.ReplaceByOwner(ctx, ownerID, tasks, quota)
+}
+
+func (r *UniqueTitleTaskRepo) Update(ctx context.Context, t Task) error {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var err error
+	if t.UniqueTitle, err = r.enforced(ctx, t); err != nil {
+		return err
+	}
+	if t.Succeeded {
+		old, err := r.TaskRepository.Get(ctx, t.ID)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(old.Title, t.Title) {
+			t.Succeeded = false
+		}
+	}
+	return r.TaskRepository.Update(ctx, t)
+}
+
+// SetEnforced flags the tasks of a scope, as SetUniqueTitles does, and then
+// saves the setting with save. If save fails the flags go back to was, so
+// that the tasks never follow a setting that isn't stored.
+func (r *UniqueTitleTaskRepo) SetEnforced(ctx context.Context, ownerID, workspaceID int, was, enforce bool, save func() error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.TaskRepository.SetUniqueTitles(ctx, ownerID, workspaceID, enforce); err != nil {
+		return err
+	}
+	if err := save(); err != nil {
+		if undoErr := r.TaskRepository.SetUniqueTitles(ctx, ownerID, workspaceID, was); undoErr != nil {
+			slog.Error("restoring unique title flags failed", "owner_id", ownerID, "workspace_id", workspaceID, "error", undoErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// settingsInput is the body of PUT /me/settings and PUT
+// /workspaces/{id}/settings.
+type settingsInput struct {
+	EnforceUniqueTitles *bool `json:"enforce_unique_titles"`
+}
+
+func (in settingsInput) Validate() error {
+	errs := validationErrors{}
+	if in.EnforceUniqueTitles == nil {
+		errs["enforce_unique_titles"] = "required"
+	}
+	return errs.orNil()
+}
+
+// userSettings is the body of GET and PUT /me/settings.
+type userSettings struct {
+	EnforceUniqueTitles bool `json:"enforce_unique_titles"`
+}
+
+// getSettingsHandler returns the current user's settings.
+func getSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	encode(w, r, http.StatusOK, userSettings{EnforceUniqueTitles: currentUser(r.Context()).EnforceUniqueTitles})
+}
+
+// updateSettingsHandler changes the current user's settings. Enforcing
+// unique titles applies to their personal tasks, and fails with 409
+// Conflict while two of the live ones share a title.
+func updateSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	var in settingsInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+
+	u := currentUser(r.Context())
+	err := uniqueTitles.SetEnforced(r.Context(), u.ID, 0, u.EnforceUniqueTitles, *in.EnforceUniqueTitles, func() error {
+		u.EnforceUniqueTitles = *in.EnforceUniqueTitles
+		return userStore.Update(r.Context(), u)
+	})
+	if err != nil {
+		uniqueTitlesError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, userSettings{EnforceUniqueTitles: u.EnforceUniqueTitles})
+}
+
+// updateWorkspaceSettingsHandler changes a workspace's settings, as
+// updateSettingsHandler does for a user's personal tasks. Only the
+// workspace's owner may do so.
+func updateWorkspaceSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	ws, m, ok := loadMemberWorkspace(w, r)
+	if !ok {
+		return
+	}
+	if m.Role != WorkspaceRoleOwner {
+		writeError(w, http.StatusForbidden, CodeForbidden, "only the workspace's owner can change its settings")
+		return
+	}
+	var in settingsInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+
+	err := uniqueTitles.SetEnforced(r.Context(), m.UserID, ws.ID, ws.EnforceUniqueTitles, *in.EnforceUniqueTitles, func() error {
+		ws.EnforceUniqueTitles = *in.EnforceUniqueTitles
+		return workspaces.Update(r.Context(), ws)
+	})
+	if err != nil {
+		uniqueTitlesError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, ws)
+}
+
+// uniqueTitlesError maps the errors of SetEnforced, those of the
+// WorkspaceStore included, to HTTP responses.
+func uniqueTitlesError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrDuplicateTitle) {
+		writeError(w, http.StatusConflict, CodeConflict, "some tasks already share a title; rename them first")
+		return
+	}
+	workspaceStoreError(w, err)
+}
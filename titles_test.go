@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryTaskRepoParallelCreateSameTitle(t *testing.T) {
+	repo := NewMemoryTaskRepo(0)
+	const n = 50
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			// Titles differ only in case, which the check ignores.
+			title := "Water the plants"
+			if i%2 == 1 {
+				title = "water the PLANTS"
+			}
+			_, errs[i] = repo.Create(context.Background(), Task{OwnerID: 1, Title: title, UniqueTitle: true}, 0)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	created := 0
+	for i, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case !errors.Is(err, ErrDuplicateTitle):
+			t.Errorf("Create %d: got error %v, want ErrDuplicateTitle", i, err)
+		}
+	}
+	if created != 1 {
+		t.Errorf("%d tasks created, want exactly 1", created)
+	}
+}
+
+func TestSucceededOccurrenceLeftOutOfTitleCheck(t *testing.T) {
+	ctx := context.Background()
+	repo := NewMemoryTaskRepo(0)
+	prev, err := repo.Create(ctx, Task{OwnerID: 1, Title: "Standup", Done: true, UniqueTitle: true}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Create(ctx, Task{OwnerID: 1, Title: "Standup", UniqueTitle: true}, 0); !errors.Is(err, ErrDuplicateTitle) {
+		t.Fatalf("Create beside a live task: got %v, want ErrDuplicateTitle", err)
+	}
+	prev.Succeeded = true
+	if err := repo.Update(ctx, prev); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Create(ctx, Task{OwnerID: 1, Title: "Standup", UniqueTitle: true}, 0); err != nil {
+		t.Fatalf("Create beside a succeeded task: %v", err)
+	}
+}
+
+func TestRecurrenceUnderUniqueTitles(t *testing.T) {
+	ctx := context.Background()
+	users := NewMemoryUserStore()
+	u, err := users.Create(ctx, User{Username: "alice", EnforceUniqueTitles: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewUniqueTitleTaskRepo(NewMemoryTaskRepo(0), users, NewMemoryWorkspaceStore())
+	w := &recurrenceWorker{repo: repo}
+
+	task, err := repo.Create(ctx, Task{OwnerID: u.ID, Title: "Standup", Recurrence: "FREQ=DAILY"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Each occurrence spawns the next, all under the series' title.
+	for i := 0; i < 3; i++ {
+		task.Done = true
+		if err := repo.Update(ctx, task); err != nil {
+			t.Fatalf("occurrence %d: completing: %v", i, err)
+		}
+		if err := w.spawn(ctx, task.ID); err != nil {
+			t.Fatalf("occurrence %d: spawning the next: %v", i, err)
+		}
+		if task, err = repo.Get(ctx, task.ID); err != nil {
+			t.Fatal(err)
+		}
+		if task.Recurrence != "" {
+			t.Fatalf("occurrence %d still has its rule", i)
+		}
+		// Later edits of the completed occurrence still succeed.
+		task.Description = "done"
+		if err := repo.Update(ctx, task); err != nil {
+			t.Fatalf("occurrence %d: editing it: %v", i, err)
+		}
+
+		tasks, _, err := repo.List(ctx, ListOptions{OwnerID: u.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, next := range tasks {
+			if !next.Done && next.Recurrence != "" {
+				task, found = next, true
+			}
+		}
+		if !found {
+			t.Fatalf("occurrence %d: no successor", i)
+		}
+	}
+
+	// Renamed to a live title, a completed occurrence is checked again.
+	if _, err := repo.Create(ctx, Task{OwnerID: u.ID, Title: "Retro"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	tasks, _, err := repo.List(ctx, ListOptions{OwnerID: u.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, done := range tasks {
+		if done.Done {
+			done.Title = "retro"
+			if err := repo.Update(ctx, done); !errors.Is(err, ErrDuplicateTitle) {
+				t.Fatalf("renaming a completed occurrence: got %v, want ErrDuplicateTitle", err)
+			}
+			break
+		}
+	}
+}
+
+// pausedUserStore holds up the first Get after reading the user until
+// resume is closed, announcing the read on read.
+type pausedUserStore struct {
+	UserStore
+	once         sync.Once
+	read, resume chan struct{}
+}
+
+func (s *pausedUserStore) Get(ctx context.Context, id int) (User, error) {
+	u, err := s.UserStore.Get(ctx, id)
+	s.once.Do(func() {
+		close(s.read)
+		<-s.resume
+	})
+	return u, err
+}
+
+// TestSetEnforcedDuringCreate turns enforcement on while a task with a
+// taken title is being created, after the create read the old setting: one
+// of the two must fail.
+func TestSetEnforcedDuringCreate(t *testing.T) {
+	ctx := context.Background()
+	users := &pausedUserStore{UserStore: NewMemoryUserStore(), read: make(chan struct{}), resume: make(chan struct{})}
+	u, err := users.UserStore.Create(ctx, User{Username: "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := NewMemoryTaskRepo(0)
+	if _, err := base.Create(ctx, Task{OwnerID: u.ID, Title: "Standup"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	repo := NewUniqueTitleTaskRepo(base, users, NewMemoryWorkspaceStore())
+
+	created := make(chan error)
+	go func() {
+		_, err := repo.Create(ctx, Task{OwnerID: u.ID, Title: "standup"}, 0)
+		created <- err
+	}()
+	<-users.read
+	set := make(chan error)
+	go func() {
+		set <- repo.SetEnforced(ctx, u.ID, 0, false, true, func() error {
+			u.EnforceUniqueTitles = true
+			return users.Update(ctx, u)
+		})
+	}()
+	// Give SetEnforced the chance to overtake the create.
+	time.Sleep(20 * time.Millisecond)
+	close(users.resume)
+	createErr, setErr := <-created, <-set
+
+	for _, err := range []error{setErr, createErr} {
+		if err != nil && !errors.Is(err, ErrDuplicateTitle) {
+			t.Fatalf("got error %v, want ErrDuplicateTitle", err)
+		}
+	}
+	if setErr == nil && createErr == nil {
+		t.Fatal("enforcement turned on and a duplicate title created")
+	}
+}
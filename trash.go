@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// startTrashPurger permanently removes tasks that have been in the trash for
+// longer than retention, checking every interval. The returned function stops
+// it and waits for a running purge to finish.
+func startTrashPurger(repo TaskRepository, retention, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			n, err := repo.PurgeDeleted(ctx, time.Now().Add(-retention))
+			if err != nil {
+				slog.Error("purging trash failed", "error", err)
+				continue
+			}
+			if n > 0 {
+				slog.Info("purged trash", "tasks", n)
+			}
+		}
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
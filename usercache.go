@@ -0,0 +1,130 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UserCacheConfig sizes the cache in front of the user store. A Size of 0
+// disables it.
+type UserCacheConfig struct {
+	Size int
+	TTL  time.Duration
+}
+
+// CachedUserStore is a UserStore that keeps up to size users looked up by ID
+// in memory for ttl, evicting the least recently used, so that requireAuth
+// doesn't hit the underlying store on every request. Writes through it
+// invalidate the cached entry; writes to the underlying store that bypass it,
+// e.g. from another instance, are seen once the entry expires.
+type CachedUserStore struct {
+	UserStore
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[int]*list.Element
+	// lru holds *userCacheEntry values, most recently used first.
+	lru *list.List
+	// generation counts invalidations, so that a lookup racing with a write
+	// doesn't cache the user it read before the write.
+	generation uint64
+
+	hits, misses atomic.Uint64
+}
+
+type userCacheEntry struct {
+	user    User
+	expires time.Time
+}
+
+// NewCachedUserStore wraps store with a cache of size entries kept for ttl.
+func NewCachedUserStore(store UserStore, size int, ttl time.Duration) *CachedUserStore {
+	return &CachedUserStore{
+		UserStore: store,
+		size:      size,
+		ttl:       ttl,
+		entries:   make(map[int]*list.Element),
+		lru:       list.New(),
+	}
+}
+
+func (s *CachedUserStore) Get(ctx context.Context, id int) (User, error) {
+	s.mu.Lock()
+	if el, ok := s.entries[id]; ok {
+		e := el.Value.(*userCacheEntry)
+		if time.Now().Before(e.expires) {
+			s.lru.MoveToFront(el)
+			s.mu.Unlock()
+			s.hits.Add(1)
+			return e.user, nil
+		}
+		s.remove(el)
+	}
+	generation := s.generation
+	s.mu.Unlock()
+	s.misses.Add(1)
+
+	u, err := s.UserStore.Get(ctx, id)
+	if err != nil {
+		return User{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.generation == generation {
+		s.add(u)
+	}
+	return u, nil
+}
+
+// add caches u. The caller must hold s.mu.
+func (s *CachedUserStore) add(u User) {
+	e := &userCacheEntry{user: u, expires: time.Now().Add(s.ttl)}
+	if el, ok := s.entries[u.ID]; ok {
+		el.Value = e
+		s.lru.MoveToFront(el)
+		return
+	}
+	s.entries[u.ID] = s.lru.PushFront(e)
+	for s.lru.Len() > s.size {
+		s.remove(s.lru.Back())
+	}
+}
+
+// remove drops el from the cache. The caller must hold s.mu.
+func (s *CachedUserStore) remove(el *list.Element) {
+	s.lru.Remove(el)
+	delete(s.entries, el.Value.(*userCacheEntry).user.ID)
+}
+
+// invalidate drops any cached copy of the user with the given ID.
+func (s *CachedUserStore) invalidate(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+	if el, ok := s.entries[id]; ok {
+		s.remove(el)
+	}
+}
+
+// Update writes u through to the underlying store, covering password and
+// role changes, and invalidates its cached copy.
+func (s *CachedUserStore) Update(ctx context.Context, u User) error {
+	defer s.invalidate(u.ID)
+	return s.UserStore.Update(ctx, u)
+}
+
+func (s *CachedUserStore) Delete(ctx context.Context, id int) error {
+	defer s.invalidate(id)
+	return s.UserStore.Delete(ctx, id)
+}
+
+// stats returns the number of lookups by ID served from the cache and
+// passed on to the underlying store.
+func (s *CachedUserStore) stats() (hits, misses uint64) {
+	return s.hits.Load(), s.misses.Load()
+}
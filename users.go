@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User is an account that can log in and own tasks.
+type User struct {
+	ID       int
+	Username string
+	// PasswordHash is nil for users created by signing in with Google, who
+	// can't log in with a password.
+	PasswordHash []byte
+	// GoogleSubject is the ID of the Google account the user signs in with,
+	// if any.
+	GoogleSubject string
+	// Role is RoleUser or RoleAdmin.
+	Role string
+	// TaskQuota overrides the default task quota for this user when set; 0
+	// means no limit.
+	TaskQuota *int
+	// EnforceUniqueTitles forbids two of the user's live personal tasks to
+	// share a title.
+	EnforceUniqueTitles bool
+	CreatedAt           time.Time
+}
+
+// User roles.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// ErrUserNotFound is returned by a UserStore when no user matches the lookup.
+var ErrUserNotFound = errors.New("user not found")
+
+// ErrUsernameTaken is returned by UserStore.Create when the username is in use.
+var ErrUsernameTaken = errors.New("username already taken")
+
+// ErrGoogleSubjectTaken is returned by UserStore.Create when another user is
+// already linked to the Google account.
+var ErrGoogleSubjectTaken = errors.New("Google account already linked")
+
+// UserStore stores user accounts. Implementations must be safe for concurrent use.
+type UserStore interface {
+	// Create assigns a new ID and creation time to u, stores it and returns
+	// the stored user. It fails with ErrUsernameTaken if the username exists
+	// and with ErrGoogleSubjectTaken if u.GoogleSubject is set and linked to
+	// another user.
+	Create(ctx context.Context, u User) (User, error)
+	Get(ctx context.Context, id int) (User, error)
+	GetByUsername(ctx context.Context, username string) (User, error)
+	GetByGoogleSubject(ctx context.Context, subject string) (User, error)
+	// Update replaces the stored user with the same ID as u. The username
+	// and Google subject cannot be changed.
+	Update(ctx context.Context, u User) error
+	// Delete removes the user with the given ID.
+	Delete(ctx context.Context, id int) error
+	// Count returns the number of stored users.
+	Count(ctx context.Context) (int, error)
+}
+
+// MemoryUserStore is an in-memory UserStore. Data is lost on restart.
+type MemoryUserStore struct {
+	mu         sync.RWMutex
+	users      map[int]User
+	byUsername map[string]int
+	bySubject  map[string]int
+	nextID     int
+}
+
+// NewMemoryUserStore returns an empty MemoryUserStore.
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{
+		users:      make(map[int]User),
+		byUsername: make(map[string]int),
+		bySubject:  make(map[string]int),
+		nextID:     1,
+	}
+}
+
+func (s *MemoryUserStore) Create(ctx context.Context, u User) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.byUsername[u.Username]; ok {
+		return User{}, ErrUsernameTaken
+	}
+	if _, ok := s.bySubject[u.GoogleSubject]; ok && u.GoogleSubject != "" {
+		return User{}, ErrGoogleSubjectTaken
+	}
+	u.ID = s.nextID
+	u.CreatedAt = time.Now().UTC()
+	s.nextID++
+	s.users[u.ID] = u
+	s.byUsername[u.Username] = u.ID
+	if u.GoogleSubject != "" {
+		s.bySubject[u.GoogleSubject] = u.ID
+	}
+	return u, nil
+}
+
+func (s *MemoryUserStore) Get(ctx context.Context, id int) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (s *MemoryUserStore) GetByUsername(ctx context.Context, username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.byUsername[username]
+	if !ok {
+		return User{}, ErrUserNotFound
+	}
+	return s.users[id], nil
+}
+
+func (s *MemoryUserStore) GetByGoogleSubject(ctx context.Context, subject string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	id, ok := s.bySubject[subject]
+	if !ok || subject == "" {
+		return User{}, ErrUserNotFound
+	}
+	return s.users[id], nil
+}
+
+func (s *MemoryUserStore) Update(ctx context.Context, u User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.users[u.ID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	u.Username = old.Username
+	u.GoogleSubject = old.GoogleSubject
+	s.users[u.ID] = u
+	return nil
+}
+
+func (s *MemoryUserStore) Delete(ctx context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return ErrUserNotFound
+	}
+	delete(s.users, id)
+	delete(s.byUsername, u.Username)
+	delete(s.bySubject, u.GoogleSubject)
+	return nil
+}
+
+func (s *MemoryUserStore) Count(ctx context.Context) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.users), nil
+}
+
+// normalizeUsername trims surrounding whitespace and lowercases the username
+// so that "Alice" and " alice " refer to the same account.
+func normalizeUsername(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
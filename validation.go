@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits enforced on task fields.
+const (
+	maxTitleLen       = 200
+	maxDescriptionLen = 2000
+	maxTags           = 20
+	maxTagLen         = 50
+)
+
+// validationErrors maps a field name to what is wrong with it. It is returned
+// by the Validate methods of request bodies and rendered as a 422.
+type validationErrors map[string]string
+
+func (e validationErrors) Error() string {
+	fields := make([]string, 0, len(e))
+	for f := range e {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	msgs := make([]string, len(fields))
+	for i, f := range fields {
+		msgs[i] = f + ": " + e[f]
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// orNil returns e as an error, or nil if it is empty.
+func (e validationErrors) orNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// writeValidationErrors writes a validation_failed 422 listing each field
+// error. index is the position of the invalid element in a bulk request and
+// is omitted when negative.
+func writeValidationErrors(w http.ResponseWriter, err error, index int) {
+	writeAPIError(w, http.StatusUnprocessableEntity, validationAPIError(err, index))
+}
+
+// validationAPIError is the body of the response writeValidationErrors
+// sends.
+func validationAPIError(err error, index int) apiError {
+	e := apiError{Code: CodeValidationFailed, Message: "request body failed validation"}
+	if errs, ok := err.(validationErrors); ok {
+		e.Fields = errs
+	} else {
+		e.Message = err.Error()
+	}
+	if index >= 0 {
+		e.Index = &index
+	}
+	return e
+}
+
+// Validate checks the field limits of a task body: a non-blank title of at
+// most maxTitleLen characters, a description within maxDescriptionLen and at
+// most maxTags tags of up to maxTagLen characters each.
+func (in taskInput) Validate() error {
+	errs := validationErrors{}
+	validateTitle(errs, in.Title)
+	validateDescription(errs, in.Description)
+	validateTags(errs, in.Tags)
+	return errs.orNil()
+}
+
+// Validate applies the taskInput limits to the fields present in the patch.
+func (in taskPatch) Validate() error {
+	errs := validationErrors{}
+	if in.Title != nil {
+		validateTitle(errs, *in.Title)
+	}
+	if in.Description != nil {
+		validateDescription(errs, *in.Description)
+	}
+	if in.Tags != nil {
+		validateTags(errs, *in.Tags)
+	}
+	return errs.orNil()
+}
+
+func validateTitle(errs validationErrors, title string) {
+	switch {
+	case strings.TrimSpace(title) == "":
+		errs["title"] = "required"
+	case utf8.RuneCountInString(title) > maxTitleLen:
+		errs["title"] = fmt.Sprintf("must be at most %d characters", maxTitleLen)
+	}
+}
+
+func validateDescription(errs validationErrors, description string) {
+	if utf8.RuneCountInString(description) > maxDescriptionLen {
+		errs["description"] = fmt.Sprintf("must be at most %d characters", maxDescriptionLen)
+	}
+}
+
+func validateTags(errs validationErrors, tags []string) {
+	if len(tags) > maxTags {
+		errs["tags"] = fmt.Sprintf("must have at most %d entries", maxTags)
+		return
+	}
+	for i, tag := range tags {
+		if utf8.RuneCountInString(strings.TrimSpace(tag)) > maxTagLen {
+			errs[fmt.Sprintf("tags[%d]", i)] = fmt.Sprintf("must be at most %d characters", maxTagLen)
+		}
+	}
+}
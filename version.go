@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build metadata, set at build time with
+//
+//	go build -ldflags "-X main.version=1.4.0 -X main.commit=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Unset values fall back to "dev", or to the VCS information the go command
+// embeds when building from a checkout.
+var (
+	version   = ""
+	commit    = ""
+	buildTime = ""
+)
+
+// buildInfo is the body of GET /version.
+type buildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time"`
+	GoVersion string `json:"go_version"`
+}
+
+// currentBuildInfo resolves the build metadata once; it is used at startup
+// and by GET /version.
+var currentBuildInfo = func() buildInfo {
+	info := buildInfo{Version: version, Commit: commit, BuildTime: buildTime, GoVersion: runtime.Version()}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.BuildTime == "":
+				info.BuildTime = s.Value
+			}
+		}
+	}
+	for _, v := range []*string{&info.Version, &info.Commit, &info.BuildTime} {
+		if *v == "" {
+			*v = "dev"
+		}
+	}
+	return info
+}()
+
+// versionHandler reports which build is running. Like the health probes it
+// never touches the session store.
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+	encode(w, r, http.StatusOK, currentBuildInfo)
+}
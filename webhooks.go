@@ -0,0 +1,539 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// maxWebhooksPerUser bounds how many webhooks one user may register.
+	maxWebhooksPerUser = 10
+	// maxWebhookURLLen bounds the target URL of a webhook.
+	maxWebhookURLLen = 2048
+	// minWebhookSecretLen and maxWebhookSecretLen bound the secret
+	// deliveries are signed with.
+	minWebhookSecretLen = 16
+	maxWebhookSecretLen = 256
+	// webhookDeliveryLogSize is how many delivery attempts are kept per
+	// user for GET /me/webhooks/deliveries.
+	webhookDeliveryLogSize = 100
+	// webhookQueueSize is how many attempts may wait for a worker.
+	webhookQueueSize = 1024
+	// webhookWorkers is how many attempts are made at once.
+	webhookWorkers = 4
+	// maxWebhookRetryDelay caps the wait between two attempts of a delivery.
+	maxWebhookRetryDelay = 10 * time.Minute
+)
+
+// Webhook delivery attempt statuses.
+const (
+	DeliverySucceeded = "succeeded"
+	// DeliveryFailed attempts are retried.
+	DeliveryFailed = "failed"
+	// DeliveryDeadLetter is the last failed attempt of a delivery, after
+	// which it is given up.
+	DeliveryDeadLetter = "dead_letter"
+)
+
+// WebhookConfig controls how task events are delivered to webhooks.
+type WebhookConfig struct {
+	// MaxAttempts counts the first attempt of a delivery; 1 means no
+	// retries.
+	MaxAttempts int
+	// BaseDelay is the wait after the first failed attempt. It doubles
+	// after each further one, up to maxWebhookRetryDelay.
+	BaseDelay time.Duration
+	// Timeout bounds each attempt, connecting included.
+	Timeout time.Duration
+	// AllowPrivateTargets lets webhooks reach loopback, private and
+	// link-local addresses, which are refused by default so that webhooks
+	// can't be used to probe the server's own network.
+	AllowPrivateTargets bool
+}
+
+// Webhook is a URL a user wants the events of their tasks POSTed to.
+type Webhook struct {
+	ID     string `json:"id"`
+	UserID int    `json:"user_id"`
+	URL    string `json:"url"`
+	// Secret signs each delivery; it is never sent back.
+	Secret    string    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ErrWebhookNotFound is returned by a WebhookStore when no webhook matches.
+var ErrWebhookNotFound = errors.New("webhook not found")
+
+// ErrTooManyWebhooks is returned by WebhookStore.Create when the user
+// already has maxWebhooksPerUser webhooks.
+var ErrTooManyWebhooks = fmt.Errorf("a user can have at most %d webhooks", maxWebhooksPerUser)
+
+// WebhookStore stores webhook registrations. Implementations must be safe
+// for concurrent use.
+type WebhookStore interface {
+	// Create assigns a new ID and creation time to h, stores it and returns
+	// the stored webhook, unless the user already has maxWebhooksPerUser.
+	Create(ctx context.Context, h Webhook) (Webhook, error)
+	// ListByUser returns userID's webhooks, oldest first.
+	ListByUser(ctx context.Context, userID int) ([]Webhook, error)
+	// Delete removes userID's webhook with the given ID.
+	Delete(ctx context.Context, userID int, id string) error
+	// DeleteByUser removes all of userID's webhooks.
+	DeleteByUser(ctx context.Context, userID int) error
+}
+
+// MemoryWebhookStore is an in-memory WebhookStore. Data is lost on restart.
+type MemoryWebhookStore struct {
+	mu    sync.RWMutex
+	hooks map[string]Webhook
+}
+
+// NewMemoryWebhookStore returns an empty MemoryWebhookStore.
+func NewMemoryWebhookStore() *MemoryWebhookStore {
+	return &MemoryWebhookStore{hooks: make(map[string]Webhook)}
+}
+
+func (s *MemoryWebhookStore) Create(ctx context.Context, h Webhook) (Webhook, error) {
+	id, err := newTaskID()
+	if err != nil {
+		return Webhook{}, err
+	}
+	h.ID = id
+	h.CreatedAt = time.Now().UTC()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, other := range s.hooks {
+		if other.UserID == h.UserID {
+			n++
+		}
+	}
+	if n >= maxWebhooksPerUser {
+		return Webhook{}, ErrTooManyWebhooks
+	}
+	s.hooks[h.ID] = h
+	return h, nil
+}
+
+func (s *MemoryWebhookStore) ListByUser(ctx context.Context, userID int) ([]Webhook, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Webhook, 0)
+	for _, h := range s.hooks {
+		if h.UserID == userID {
+			list = append(list, h)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+func (s *MemoryWebhookStore) Delete(ctx context.Context, userID int, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h, ok := s.hooks[id]
+	if !ok || h.UserID != userID {
+		return ErrWebhookNotFound
+	}
+	delete(s.hooks, id)
+	return nil
+}
+
+func (s *MemoryWebhookStore) DeleteByUser(ctx context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, h := range s.hooks {
+		if h.UserID == userID {
+			delete(s.hooks, id)
+		}
+	}
+	return nil
+}
+
+// WebhookDelivery records one attempt to deliver an event to a webhook.
+type WebhookDelivery struct {
+	// ID is the same for every attempt of a delivery; it is sent as
+	// X-TMS-Delivery so that targets can drop duplicates.
+	ID        string `json:"id"`
+	WebhookID string `json:"webhook_id"`
+	URL       string `json:"url"`
+	Event     string `json:"event"`
+	TaskID    string `json:"task_id"`
+	Attempt   int    `json:"attempt"`
+	// Status is one of the Delivery* values.
+	Status string `json:"status"`
+	// StatusCode is what the target answered with, or 0 if it wasn't
+	// reached.
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error"`
+	At         time.Time `json:"at"`
+	// NextAttemptAt is when a failed attempt is retried.
+	NextAttemptAt *time.Time `json:"next_attempt_at"`
+}
+
+// webhookDeliveryLog keeps the latest delivery attempts of each user in
+// memory.
+type webhookDeliveryLog struct {
+	mu sync.Mutex
+	// byUser holds at most webhookDeliveryLogSize attempts per user, oldest
+	// first.
+	byUser map[int][]WebhookDelivery
+}
+
+func (l *webhookDeliveryLog) add(userID int, d WebhookDelivery) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := append(l.byUser[userID], d)
+	if len(entries) > webhookDeliveryLogSize {
+		entries = append(entries[:0], entries[len(entries)-webhookDeliveryLogSize:]...)
+	}
+	l.byUser[userID] = entries
+}
+
+// list returns the attempts of userID, newest first.
+func (l *webhookDeliveryLog) list(userID int) []WebhookDelivery {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	entries := l.byUser[userID]
+	out := make([]WebhookDelivery, len(entries))
+	for i, d := range entries {
+		out[len(entries)-1-i] = d
+	}
+	return out
+}
+
+func (l *webhookDeliveryLog) forget(userID int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.byUser, userID)
+}
+
+// webhookPayload is the body POSTed to a webhook.
+type webhookPayload struct {
+	// DeliveryID matches the X-TMS-Delivery header.
+	DeliveryID string    `json:"delivery_id"`
+	Type       string    `json:"type"`
+	OccurredAt time.Time `json:"occurred_at"`
+	Task       Task      `json:"task"`
+	Comment    *Comment  `json:"comment,omitempty"`
+}
+
+// webhookJob is one attempt of a delivery waiting for a worker.
+type webhookJob struct {
+	hook     Webhook
+	body     []byte
+	delivery WebhookDelivery
+}
+
+// webhookDispatcher delivers task events to the webhooks of the users they
+// are published to. Deliveries are made by a few workers in the
+// background, retried with exponential backoff, and dead-lettered, which
+// is logged, once MaxAttempts have failed. Deliveries still waiting at
+// shutdown are lost.
+type webhookDispatcher struct {
+	cfg    WebhookConfig
+	store  WebhookStore
+	client *http.Client
+	log    webhookDeliveryLog
+	queue  chan webhookJob
+	quit   chan struct{}
+	wg     sync.WaitGroup
+}
+
+func startWebhookDispatcher(cfg WebhookConfig, store WebhookStore) *webhookDispatcher {
+	d := &webhookDispatcher{
+		cfg:    cfg,
+		store:  store,
+		client: newWebhookClient(cfg),
+		log:    webhookDeliveryLog{byUser: make(map[int][]WebhookDelivery)},
+		queue:  make(chan webhookJob, webhookQueueSize),
+		quit:   make(chan struct{}),
+	}
+	for range webhookWorkers {
+		d.wg.Add(1)
+		go d.run()
+	}
+	return d
+}
+
+// newWebhookClient returns a client that ignores proxy settings, doesn't
+// follow redirects and, unless cfg allows it, refuses to connect to
+// private addresses. The addresses are checked as they are dialled, after
+// DNS resolution, so that a name can't be pointed at one later.
+func newWebhookClient(cfg WebhookConfig) *http.Client {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivateTargets {
+		dialer.Control = refusePrivateAddr
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: cfg.Timeout,
+			MaxIdleConnsPerHost: webhookWorkers,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+}
+
+func refusePrivateAddr(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("webhook target %s is not a public address", ip)
+	}
+	return nil
+}
+
+// notify queues a delivery of ev to each webhook of userID. It is
+// registered with Hub.Observe.
+func (d *webhookDispatcher) notify(userID int, ev TaskEvent) {
+	hooks, err := d.store.ListByUser(context.Background(), userID)
+	if err != nil {
+		slog.Error("loading webhooks failed", "user_id", userID, "error", err)
+		return
+	}
+	now := time.Now().UTC()
+	for _, h := range hooks {
+		id, err := newTaskID()
+		if err != nil {
+			slog.Error("creating webhook delivery ID failed", "error", err)
+			return
+		}
+		body, err := json.Marshal(webhookPayload{DeliveryID: id, Type: ev.Type, OccurredAt: now, Task: ev.Task, Comment: ev.Comment})
+		if err != nil {
+			slog.Error("encoding webhook payload failed", "task_id", ev.Task.ID, "error", err)
+			return
+		}
+		d.enqueue(webhookJob{
+			hook:     h,
+			body:     body,
+			delivery: WebhookDelivery{ID: id, WebhookID: h.ID, URL: h.URL, Event: ev.Type, TaskID: ev.Task.ID, Attempt: 1},
+		})
+	}
+}
+
+// enqueue hands job to the workers without blocking. If the queue is full
+// the delivery is dead-lettered right away.
+func (d *webhookDispatcher) enqueue(job webhookJob) {
+	select {
+	case <-d.quit:
+		return
+	default:
+	}
+	select {
+	case d.queue <- job:
+	default:
+		d.deadLetter(job.hook.UserID, job.delivery, 0, errors.New("delivery queue full"))
+	}
+}
+
+// stop waits for the attempts in progress and stops the workers. As with
+// the recurrence worker, the queue is left open for late enqueues.
+func (d *webhookDispatcher) stop() {
+	close(d.quit)
+	d.wg.Wait()
+}
+
+func (d *webhookDispatcher) run() {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-d.quit:
+			return
+		case job := <-d.queue:
+			d.attempt(job)
+		}
+	}
+}
+
+// attempt makes one attempt of a delivery, records it and schedules the
+// next one if it failed.
+func (d *webhookDispatcher) attempt(job webhookJob) {
+	rec := job.delivery
+	code, err := d.post(job)
+	rec.StatusCode = code
+	rec.At = time.Now().UTC()
+	switch {
+	case err == nil:
+		rec.Status = DeliverySucceeded
+		d.log.add(job.hook.UserID, rec)
+	case rec.Attempt >= d.cfg.MaxAttempts:
+		d.deadLetter(job.hook.UserID, rec, code, err)
+	default:
+		delay := d.retryDelay(rec.Attempt)
+		next := rec.At.Add(delay)
+		rec.Status = DeliveryFailed
+		rec.Error = err.Error()
+		rec.NextAttemptAt = &next
+		d.log.add(job.hook.UserID, rec)
+		slog.Warn("webhook delivery failed, retrying", "webhook_id", rec.WebhookID, "delivery_id", rec.ID, "attempt", rec.Attempt, "retry_in", delay.String(), "error", err)
+		job.delivery.Attempt++
+		time.AfterFunc(delay, func() { d.enqueue(job) })
+	}
+}
+
+// retryDelay returns the wait after the given failed attempt.
+func (d *webhookDispatcher) retryDelay(attempt int) time.Duration {
+	delay := d.cfg.BaseDelay
+	for i := 1; i < attempt && delay < maxWebhookRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxWebhookRetryDelay)
+}
+
+// deadLetter gives up on a delivery.
+func (d *webhookDispatcher) deadLetter(userID int, rec WebhookDelivery, code int, err error) {
+	rec.Status = DeliveryDeadLetter
+	rec.StatusCode = code
+	rec.Error = err.Error()
+	if rec.At.IsZero() {
+		rec.At = time.Now().UTC()
+	}
+	d.log.add(userID, rec)
+	slog.Error("webhook delivery dead-lettered", "user_id", userID, "webhook_id", rec.WebhookID, "delivery_id", rec.ID,
+		"event", rec.Event, "task_id", rec.TaskID, "attempts", rec.Attempt, "error", err)
+}
+
+// post sends the delivery and returns the status code the target answered
+// with. Anything but a 2xx is an error.
+func (d *webhookDispatcher) post(job webhookJob) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d.cfg.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, job.hook.URL, bytes.NewReader(job.body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "TMS-Webhooks/"+version)
+	req.Header.Set("X-TMS-Event", job.delivery.Event)
+	req.Header.Set("X-TMS-Delivery", job.delivery.ID)
+	req.Header.Set("X-TMS-Delivery-Attempt", strconv.Itoa(job.delivery.Attempt))
+	req.Header.Set("X-TMS-Signature", webhookSignature(job.hook.Secret, job.body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("target answered %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// webhookSignature returns the X-TMS-Signature of body: "sha256=" and the
+// hex HMAC-SHA256 of the body keyed with the webhook's secret.
+func webhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookInput is the body of POST /me/webhooks.
+type webhookInput struct {
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+func (in webhookInput) Validate() error {
+	errs := validationErrors{}
+	switch u, err := url.Parse(in.URL); {
+	case in.URL == "":
+		errs["url"] = "required"
+	case len(in.URL) > maxWebhookURLLen:
+		errs["url"] = fmt.Sprintf("must be at most %d characters", maxWebhookURLLen)
+	case err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "":
+		errs["url"] = "must be an absolute http or https URL"
+	case u.User != nil:
+		errs["url"] = "must not contain credentials"
+	}
+	if len(in.Secret) < minWebhookSecretLen || len(in.Secret) > maxWebhookSecretLen {
+		errs["secret"] = fmt.Sprintf("must be %d to %d characters", minWebhookSecretLen, maxWebhookSecretLen)
+	}
+	return errs.orNil()
+}
+
+// createWebhookHandler registers a webhook for the current user. The
+// events of every task the user is told about, as on GET /tasks/events, are
+// POSTed to it from then on.
+func createWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	var in webhookInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if err := in.Validate(); err != nil {
+		writeValidationErrors(w, err, -1)
+		return
+	}
+	h, err := webhooks.Create(r.Context(), Webhook{UserID: currentUser(r.Context()).ID, URL: in.URL, Secret: in.Secret})
+	if err != nil {
+		if errors.Is(err, ErrTooManyWebhooks) {
+			writeError(w, http.StatusConflict, CodeConflict, err.Error())
+			return
+		}
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusCreated, h)
+}
+
+// listWebhooksHandler returns the current user's webhooks, oldest first.
+func listWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := webhooks.ListByUser(r.Context(), currentUser(r.Context()).ID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, map[string][]Webhook{"webhooks": list})
+}
+
+// deleteWebhookHandler removes a webhook. Deliveries already queued for it
+// are still attempted.
+func deleteWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	err := webhooks.Delete(r.Context(), currentUser(r.Context()).ID, r.PathValue("id"))
+	if errors.Is(err, ErrWebhookNotFound) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "webhook not found")
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listWebhookDeliveriesHandler returns the latest delivery attempts to the
+// current user's webhooks, newest first.
+func listWebhookDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	encode(w, r, http.StatusOK, map[string][]WebhookDelivery{"deliveries": webhookDispatch.log.list(currentUser(r.Context()).ID)})
+}
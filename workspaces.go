@@ -0,0 +1,543 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxWorkspaceNameLen bounds a workspace's name.
+const maxWorkspaceNameLen = 100
+
+// workspaceHeader selects the active workspace of a single request,
+// overriding the one stored in the session.
+const workspaceHeader = "X-Workspace-ID"
+
+// Workspace is a team space whose tasks are shared by all of its members.
+// Tasks outside any workspace are their owner's personal tasks.
+type Workspace struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// OwnerID is the member who manages the workspace's membership.
+	OwnerID int `json:"owner_id"`
+	// EnforceUniqueTitles forbids two of the workspace's live tasks to
+	// share a title.
+	EnforceUniqueTitles bool      `json:"enforce_unique_titles"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+// WorkspaceMember is a user's membership of a workspace.
+type WorkspaceMember struct {
+	WorkspaceID int
+	UserID      int
+	// Role is WorkspaceRoleOwner or WorkspaceRoleMember.
+	Role     string
+	JoinedAt time.Time
+}
+
+// Workspace roles.
+const (
+	WorkspaceRoleOwner  = "owner"
+	WorkspaceRoleMember = "member"
+)
+
+var (
+	// ErrWorkspaceNotFound is returned by a WorkspaceStore when no workspace
+	// has the given ID.
+	ErrWorkspaceNotFound = errors.New("workspace not found")
+	// ErrNotMember is returned by a WorkspaceStore when the user doesn't
+	// belong to the workspace.
+	ErrNotMember = errors.New("not a member of the workspace")
+	// ErrAlreadyMember is returned by WorkspaceStore.AddMember when the user
+	// already belongs to the workspace.
+	ErrAlreadyMember = errors.New("already a member of the workspace")
+	// ErrRemoveOwner is returned by WorkspaceStore.RemoveMember for the
+	// workspace's owner.
+	ErrRemoveOwner = errors.New("the workspace owner cannot be removed")
+)
+
+// WorkspaceStore stores workspaces and their members. Implementations must be
+// safe for concurrent use.
+type WorkspaceStore interface {
+	// Create assigns a new ID and creation time to ws, stores it with its
+	// owner as the first member and returns the stored workspace.
+	Create(ctx context.Context, ws Workspace) (Workspace, error)
+	Get(ctx context.Context, id int) (Workspace, error)
+	// Update replaces the stored workspace with the same ID as ws. Its
+	// owner and creation time cannot be changed.
+	Update(ctx context.Context, ws Workspace) error
+	// ListByUser returns the workspaces userID belongs to, oldest first.
+	ListByUser(ctx context.Context, userID int) ([]Workspace, error)
+	// Member returns userID's membership of the workspace, or ErrNotMember.
+	Member(ctx context.Context, workspaceID, userID int) (WorkspaceMember, error)
+	// Members returns the members of the workspace in the order they joined.
+	Members(ctx context.Context, workspaceID int) ([]WorkspaceMember, error)
+	// AddMember assigns a join time to m, stores it and returns it.
+	AddMember(ctx context.Context, m WorkspaceMember) (WorkspaceMember, error)
+	// RemoveMember removes userID from the workspace. It fails with
+	// ErrRemoveOwner for the owner.
+	RemoveMember(ctx context.Context, workspaceID, userID int) error
+	// RemoveUser removes userID from every workspace. Workspaces they owned
+	// pass to their longest-standing remaining member; those left without
+	// members are deleted and their IDs returned.
+	RemoveUser(ctx context.Context, userID int) ([]int, error)
+}
+
+// MemoryWorkspaceStore is an in-memory WorkspaceStore. Data is lost on
+// restart.
+type MemoryWorkspaceStore struct {
+	mu         sync.RWMutex
+	workspaces map[int]Workspace
+	// members maps workspace IDs to their members by user ID.
+	members map[int]map[int]WorkspaceMember
+	nextID  int
+}
+
+// NewMemoryWorkspaceStore returns an empty MemoryWorkspaceStore.
+func NewMemoryWorkspaceStore() *MemoryWorkspaceStore {
+	return &MemoryWorkspaceStore{
+		workspaces: make(map[int]Workspace),
+		members:    make(map[int]map[int]WorkspaceMember),
+		nextID:     1,
+	}
+}
+
+func (s *MemoryWorkspaceStore) Create(ctx context.Context, ws Workspace) (Workspace, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ws.ID = s.nextID
+	s.nextID++
+	ws.CreatedAt = time.Now().UTC()
+	s.workspaces[ws.ID] = ws
+	s.members[ws.ID] = map[int]WorkspaceMember{
+		ws.OwnerID: {WorkspaceID: ws.ID, UserID: ws.OwnerID, Role: WorkspaceRoleOwner, JoinedAt: ws.CreatedAt},
+	}
+	return ws, nil
+}
+
+func (s *MemoryWorkspaceStore) Get(ctx context.Context, id int) (Workspace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ws, ok := s.workspaces[id]
+	if !ok {
+		return Workspace{}, ErrWorkspaceNotFound
+	}
+	return ws, nil
+}
+
+func (s *MemoryWorkspaceStore) Update(ctx context.Context, ws Workspace) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.workspaces[ws.ID]
+	if !ok {
+		return ErrWorkspaceNotFound
+	}
+	ws.OwnerID, ws.CreatedAt = old.OwnerID, old.CreatedAt
+	s.workspaces[ws.ID] = ws
+	return nil
+}
+
+func (s *MemoryWorkspaceStore) ListByUser(ctx context.Context, userID int) ([]Workspace, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Workspace, 0)
+	for id, members := range s.members {
+		if _, ok := members[userID]; ok {
+			list = append(list, s.workspaces[id])
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list, nil
+}
+
+func (s *MemoryWorkspaceStore) Member(ctx context.Context, workspaceID, userID int) (WorkspaceMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members, ok := s.members[workspaceID]
+	if !ok {
+		return WorkspaceMember{}, ErrWorkspaceNotFound
+	}
+	m, ok := members[userID]
+	if !ok {
+		return WorkspaceMember{}, ErrNotMember
+	}
+	return m, nil
+}
+
+func (s *MemoryWorkspaceStore) Members(ctx context.Context, workspaceID int) ([]WorkspaceMember, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	members, ok := s.members[workspaceID]
+	if !ok {
+		return nil, ErrWorkspaceNotFound
+	}
+	return sortedMembers(members), nil
+}
+
+// sortedMembers returns members in the order they joined.
+func sortedMembers(members map[int]WorkspaceMember) []WorkspaceMember {
+	list := make([]WorkspaceMember, 0, len(members))
+	for _, m := range members {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].JoinedAt.Equal(list[j].JoinedAt) {
+			return list[i].JoinedAt.Before(list[j].JoinedAt)
+		}
+		return list[i].UserID < list[j].UserID
+	})
+	return list
+}
+
+func (s *MemoryWorkspaceStore) AddMember(ctx context.Context, m WorkspaceMember) (WorkspaceMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members, ok := s.members[m.WorkspaceID]
+	if !ok {
+		return WorkspaceMember{}, ErrWorkspaceNotFound
+	}
+	if _, ok := members[m.UserID]; ok {
+		return WorkspaceMember{}, ErrAlreadyMember
+	}
+	m.JoinedAt = time.Now().UTC()
+	members[m.UserID] = m
+	return m, nil
+}
+
+func (s *MemoryWorkspaceStore) RemoveMember(ctx context.Context, workspaceID, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	members, ok := s.members[workspaceID]
+	if !ok {
+		return ErrWorkspaceNotFound
+	}
+	m, ok := members[userID]
+	if !ok {
+		return ErrNotMember
+	}
+	if m.Role == WorkspaceRoleOwner {
+		return ErrRemoveOwner
+	}
+	delete(members, userID)
+	return nil
+}
+
+func (s *MemoryWorkspaceStore) RemoveUser(ctx context.Context, userID int) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted []int
+	for id, members := range s.members {
+		m, ok := members[userID]
+		if !ok {
+			continue
+		}
+		delete(members, userID)
+		if m.Role != WorkspaceRoleOwner {
+			continue
+		}
+		remaining := sortedMembers(members)
+		if len(remaining) == 0 {
+			delete(s.members, id)
+			delete(s.workspaces, id)
+			deleted = append(deleted, id)
+			continue
+		}
+		heir := remaining[0]
+		heir.Role = WorkspaceRoleOwner
+		members[heir.UserID] = heir
+		ws := s.workspaces[id]
+		ws.OwnerID = heir.UserID
+		s.workspaces[id] = ws
+	}
+	sort.Ints(deleted)
+	return deleted, nil
+}
+
+// selectWorkspace resolves the active workspace from the X-Workspace-ID
+// header or, failing that, the session, and checks that the current user
+// belongs to it. It must run behind requireAuth. Without either, requests act
+// on the user's personal tasks.
+func selectWorkspace(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		id := int(sessionInt64(ctx, "workspaceID"))
+		if v := r.Header.Get(workspaceHeader); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				writeError(w, http.StatusBadRequest, CodeBadRequest, workspaceHeader+" must be a positive integer")
+				return
+			}
+			id = n
+		}
+		if id != 0 {
+			_, err := workspaces.Member(ctx, id, currentUser(ctx).ID)
+			if errors.Is(err, ErrNotMember) || errors.Is(err, ErrWorkspaceNotFound) {
+				writeError(w, http.StatusForbidden, CodeForbidden, fmt.Sprintf("not a member of workspace %d", id))
+				return
+			}
+			if err != nil {
+				serverError(w, err)
+				return
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(ctx, workspaceKey, id)))
+	})
+}
+
+// activeWorkspaceID returns the workspace selected by selectWorkspace, or 0
+// for the user's personal tasks.
+func activeWorkspaceID(ctx context.Context) int {
+	id, _ := ctx.Value(workspaceKey).(int)
+	return id
+}
+
+// activeWorkspaceRef returns the active workspace as a Task.WorkspaceID, nil
+// for personal tasks.
+func activeWorkspaceRef(ctx context.Context) *int {
+	if id := activeWorkspaceID(ctx); id != 0 {
+		return &id
+	}
+	return nil
+}
+
+// inActiveWorkspace reports whether t belongs to the request's active
+// workspace or, with none active, is a personal task.
+func inActiveWorkspace(ctx context.Context, t Task) bool {
+	if t.WorkspaceID == nil {
+		return activeWorkspaceID(ctx) == 0
+	}
+	return *t.WorkspaceID == activeWorkspaceID(ctx)
+}
+
+// workspaceMemberInfo describes a member in API responses.
+type workspaceMemberInfo struct {
+	UserID   int       `json:"user_id"`
+	Username string    `json:"username"`
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+func newWorkspaceMemberInfo(ctx context.Context, m WorkspaceMember) (workspaceMemberInfo, error) {
+	info := workspaceMemberInfo{UserID: m.UserID, Role: m.Role, JoinedAt: m.JoinedAt}
+	u, err := userStore.Get(ctx, m.UserID)
+	if err != nil {
+		return info, err
+	}
+	info.Username = u.Username
+	return info, nil
+}
+
+// workspaceInput is the body of POST /workspaces.
+type workspaceInput struct {
+	Name string `json:"name"`
+}
+
+// createWorkspaceHandler creates a workspace owned by the current user.
+func createWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	var in workspaceInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	in.Name = strings.TrimSpace(in.Name)
+	switch {
+	case in.Name == "":
+		writeValidationErrors(w, validationErrors{"name": "required"}, -1)
+		return
+	case utf8.RuneCountInString(in.Name) > maxWorkspaceNameLen:
+		writeValidationErrors(w, validationErrors{"name": fmt.Sprintf("must be at most %d characters", maxWorkspaceNameLen)}, -1)
+		return
+	}
+
+	ws, err := workspaces.Create(r.Context(), Workspace{Name: in.Name, OwnerID: currentUser(r.Context()).ID})
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusCreated, ws)
+}
+
+// listWorkspacesHandler lists the workspaces the current user belongs to.
+func listWorkspacesHandler(w http.ResponseWriter, r *http.Request) {
+	list, err := workspaces.ListByUser(r.Context(), currentUser(r.Context()).ID)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusOK, map[string][]Workspace{"workspaces": list})
+}
+
+// loadMemberWorkspace fetches the workspace named by the {id} path segment
+// and checks that the current user belongs to it. If it returns false a
+// response has already been written.
+func loadMemberWorkspace(w http.ResponseWriter, r *http.Request) (Workspace, WorkspaceMember, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id < 1 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "workspace id must be a positive integer")
+		return Workspace{}, WorkspaceMember{}, false
+	}
+	ws, err := workspaces.Get(r.Context(), id)
+	if err != nil {
+		workspaceStoreError(w, err)
+		return Workspace{}, WorkspaceMember{}, false
+	}
+	m, err := workspaces.Member(r.Context(), id, currentUser(r.Context()).ID)
+	if err != nil {
+		workspaceStoreError(w, err)
+		return Workspace{}, WorkspaceMember{}, false
+	}
+	return ws, m, true
+}
+
+// listWorkspaceMembersHandler lists a workspace's members to any of them.
+func listWorkspaceMembersHandler(w http.ResponseWriter, r *http.Request) {
+	ws, _, ok := loadMemberWorkspace(w, r)
+	if !ok {
+		return
+	}
+	members, err := workspaces.Members(r.Context(), ws.ID)
+	if err != nil {
+		workspaceStoreError(w, err)
+		return
+	}
+	infos := make([]workspaceMemberInfo, 0, len(members))
+	for _, m := range members {
+		info, err := newWorkspaceMemberInfo(r.Context(), m)
+		if errors.Is(err, ErrUserNotFound) {
+			continue
+		}
+		if err != nil {
+			serverError(w, err)
+			return
+		}
+		infos = append(infos, info)
+	}
+	encode(w, r, http.StatusOK, map[string][]workspaceMemberInfo{"members": infos})
+}
+
+// memberInput is the body of POST /workspaces/{id}/members.
+type memberInput struct {
+	UserID int `json:"user_id"`
+}
+
+// addWorkspaceMemberHandler adds a user to a workspace. Only the workspace's
+// owner may do so.
+func addWorkspaceMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ws, m, ok := loadMemberWorkspace(w, r)
+	if !ok {
+		return
+	}
+	if m.Role != WorkspaceRoleOwner {
+		writeError(w, http.StatusForbidden, CodeForbidden, "only the workspace's owner can add members")
+		return
+	}
+	var in memberInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if _, err := userStore.Get(r.Context(), in.UserID); errors.Is(err, ErrUserNotFound) {
+		writeValidationErrors(w, validationErrors{"user_id": "user not found"}, -1)
+		return
+	} else if err != nil {
+		serverError(w, err)
+		return
+	}
+
+	added, err := workspaces.AddMember(r.Context(), WorkspaceMember{WorkspaceID: ws.ID, UserID: in.UserID, Role: WorkspaceRoleMember})
+	if err != nil {
+		workspaceStoreError(w, err)
+		return
+	}
+	info, err := newWorkspaceMemberInfo(r.Context(), added)
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	encode(w, r, http.StatusCreated, info)
+}
+
+// removeWorkspaceMemberHandler removes a member from a workspace. The owner
+// may remove anyone but themselves; other members may only leave.
+func removeWorkspaceMemberHandler(w http.ResponseWriter, r *http.Request) {
+	ws, m, ok := loadMemberWorkspace(w, r)
+	if !ok {
+		return
+	}
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil || userID < 1 {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "user id must be a positive integer")
+		return
+	}
+	if m.Role != WorkspaceRoleOwner && userID != m.UserID {
+		writeError(w, http.StatusForbidden, CodeForbidden, "only the workspace's owner can remove other members")
+		return
+	}
+	err = workspaces.RemoveMember(r.Context(), ws.ID, userID)
+	if errors.Is(err, ErrNotMember) {
+		writeError(w, http.StatusNotFound, CodeNotFound, "member not found")
+		return
+	}
+	if err != nil {
+		workspaceStoreError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// activeWorkspaceInput is the body of PUT /me/workspace. A null workspace_id
+// switches back to personal tasks.
+type activeWorkspaceInput struct {
+	WorkspaceID *int `json:"workspace_id"`
+}
+
+// setActiveWorkspaceHandler stores the workspace task requests act on in the
+// session. Requests authenticated by API key have no session to store it in
+// and send X-Workspace-ID instead.
+func setActiveWorkspaceHandler(w http.ResponseWriter, r *http.Request) {
+	if _, ok := r.Context().Value(apiKeyKey).(APIKey); ok {
+		writeError(w, http.StatusBadRequest, CodeBadRequest, "requests using an API key select a workspace with the "+workspaceHeader+" header")
+		return
+	}
+	var in activeWorkspaceInput
+	if !decodeJSON(w, r, &in) {
+		return
+	}
+	if in.WorkspaceID == nil {
+		sessionManager.Remove(r.Context(), "workspaceID")
+		encode(w, r, http.StatusOK, in)
+		return
+	}
+	_, err := workspaces.Member(r.Context(), *in.WorkspaceID, currentUser(r.Context()).ID)
+	if errors.Is(err, ErrNotMember) || errors.Is(err, ErrWorkspaceNotFound) {
+		writeValidationErrors(w, validationErrors{"workspace_id": "not a member of this workspace"}, -1)
+		return
+	}
+	if err != nil {
+		serverError(w, err)
+		return
+	}
+	sessionManager.Put(r.Context(), "workspaceID", *in.WorkspaceID)
+	encode(w, r, http.StatusOK, in)
+}
+
+// workspaceStoreError maps WorkspaceStore errors to HTTP responses.
+func workspaceStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrWorkspaceNotFound):
+		writeError(w, http.StatusNotFound, CodeNotFound, "workspace not found")
+	case errors.Is(err, ErrNotMember):
+		writeError(w, http.StatusForbidden, CodeForbidden, "not a member of this workspace")
+	case errors.Is(err, ErrAlreadyMember):
+		writeError(w, http.StatusConflict, CodeConflict, "user is already a member of this workspace")
+	case errors.Is(err, ErrRemoveOwner):
+		writeError(w, http.StatusConflict, CodeConflict, err.Error())
+	default:
+		serverError(w, err)
+	}
+}
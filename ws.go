@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection limits. The server pings every wsPingPeriod and drops
+// the connection if nothing (pong, ping or message) arrives within wsPongWait.
+const (
+	wsMaxMessageSize = 4096
+	wsWriteWait      = 10 * time.Second
+	wsPongWait       = 60 * time.Second
+	wsPingPeriod     = wsPongWait * 9 / 10
+)
+
+// wsHandler pushes the current user's task events over a WebSocket, the same
+// feed as GET /tasks/events for clients whose proxies break SSE. It must run
+// behind requireAuth so that unauthenticated upgrades are refused with 401.
+// Browsers send the session cookie cross-site too, so the Origin must be this
+// host or one of allowedOrigins.
+func wsHandler(allowedOrigins []string) http.HandlerFunc {
+	allow := make(map[string]bool, len(allowedOrigins))
+	for _, o := range allowedOrigins {
+		allow[o] = true
+	}
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" || allow[origin] {
+				return true
+			}
+			u, err := url.Parse(origin)
+			return err == nil && strings.EqualFold(u.Host, r.Host)
+		},
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := currentUser(r.Context()).ID
+		conn, err := upgrader.Upgrade(hijackableWriter{w}, r, nil)
+		if err != nil {
+			// Upgrade has already written an error response.
+			return
+		}
+		defer conn.Close()
+
+		events, unsubscribe := taskEvents.Subscribe(userID)
+		defer unsubscribe()
+
+		// The read loop only exists to process control frames and notice
+		// when the client goes away; client messages are ignored.
+		conn.SetReadLimit(wsMaxMessageSize)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+		conn.SetPingHandler(func(data string) error {
+			conn.SetReadDeadline(time.Now().Add(wsPongWait))
+			err := conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(wsWriteWait))
+			if err == websocket.ErrCloseSent {
+				return nil
+			}
+			return err
+		})
+		readerDone := make(chan struct{})
+		go func() {
+			defer close(readerDone)
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+
+		ping := time.NewTicker(wsPingPeriod)
+		defer ping.Stop()
+		for {
+			select {
+			case <-readerDone:
+				return
+			case ev, ok := <-events:
+				conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+				if !ok {
+					// Dropped as a slow consumer or the server is shutting down.
+					conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""))
+					return
+				}
+				if err := conn.WriteJSON(ev); err != nil {
+					return
+				}
+			case <-ping.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait)); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+// hijackableWriter exposes http.Hijacker through our middleware wrappers,
+// which only support http.ResponseController via Unwrap.
+type hijackableWriter struct {
+	http.ResponseWriter
+}
+
+func (w hijackableWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}